@@ -1,166 +1,410 @@
 package cli
 
 import (
-	"bytes"
-	"encoding/csv"
 	"encoding/json"
 	"errors"
+	"flag"
 	"fmt"
 	"invoice-generator/pkg/invoice"
 	"invoice-generator/pkg/invoice/call"
+	"invoice-generator/pkg/invoice/charge"
+	"invoice-generator/pkg/invoice/exchange"
+	"invoice-generator/pkg/invoice/holiday"
+	"invoice-generator/pkg/invoice/plan"
+	"invoice-generator/pkg/invoice/promotion"
+	"invoice-generator/pkg/invoice/tariff"
+	"invoice-generator/pkg/invoice/tax"
 	"invoice-generator/pkg/platform/timeutil"
 	"invoice-generator/pkg/user"
 	"io"
-	"strconv"
 	"time"
 )
 
 // Nota de diseño: Podría haber usado un pkg como https://github.com/spf13/cobra
-// para hacer el CLI, pero para este caso es overkill porque todos los
-// argumentos son obligatorios, pueden ir en orden, y no hay flags.
+// para hacer el CLI, pero para este caso es overkill porque los argumentos
+// obligatorios pueden ir en orden, y los flags opcionales alcanza con el pkg
+// flag de la stdlib.
+
+const usage = "./invoice-generator [--tariff <tariff_file> | --tariff-history <tariff_history_file>] [--rates <rates_file>] [--taxes <taxes_file>] [--promotions <promotions_file>] [--plans <plans_file>] [--charges <charges_file>] [--holidays <holidays_file>] [--columns <columns_file>] [--input-format <csv|json|ndjson>] [--time-zone <time_zone>] [--cycle <AAAA-MM> [--cycle-day <day>]] <telephone> [<billing_start> <billing_end>] <calls_file>..."
 
 type arguments struct {
 	userTelephoneNumber string
-	billingPeriodStart  string // AAAA-MM-DD
-	billingPeriodEnd    string // AAAA-MM-DD
-	callsCSVFileName    string
+	billingPeriodStart  string   // AAAA-MM-DD, empty with billingCycle
+	billingPeriodEnd    string   // AAAA-MM-DD, empty with billingCycle
+	billingCycle        string   // AAAA-MM, optional instead of the start and end
+	cycleDay            int      // Day of the month billing cycles start on
+	callsFileNames      []string // Paths, glob patterns or directories, - is stdin
+	tariffFileName      string   // Optional, empty means the default tariff
+	tariffHistoryName   string   // Optional, instead of tariffFileName
+	ratesFileName       string   // Optional, empty means no exchange rates
+	taxesFileName       string   // Optional, empty means no taxes
+	promotionsFileName  string   // Optional, empty means only the built-in promotions
+	plansFileName       string   // Optional, empty means no plans
+	chargesFileName     string   // Optional, empty means only calls are charged
+	holidaysFileName    string   // Optional, empty means there are no holidays
+	columnsFileName     string   // Optional, empty means only the default headers
+	inputFormat         string   // Of the calls files, empty means by their extension
+	timeZone            string   // IANA name of the time zone of the billing period, UTC by default
+	callTimestamp       string   // Only for explain, empty means all the calls
 }
 
-// FileReader reads a file from the filesystem. Used to mock reading of csv
-// files.
-type FileReader func(name string) ([]byte, error)
+func Run(userFinder user.Finder, files FileSystem, rawArgs []string) (json.RawMessage, error) {
+	args, err := parseArgs(rawArgs, false)
+	if err != nil {
+		return nil, fmt.Errorf("parsing arguments: %s. Usage:\n\t%s", err, usage)
+	}
 
-func Run(userFinder user.Finder, fileReader FileReader, rawArgs []string) (json.RawMessage, error) {
-	args, err := parseArgs(rawArgs)
+	invoice, err := generate(userFinder, files, args, false)
 	if err != nil {
-		return nil, fmt.Errorf("parsing arguments: %s. Usage:\n\t./invoice-generator <telephone> <billing_start> <billing_end> <calls_csv_file>", err)
+		return nil, err
 	}
 
-	billingPeriod, err := makeBillingPeriod(args.billingPeriodStart, args.billingPeriodEnd)
+	invoiceJSON, err := json.Marshal(invoice)
 	if err != nil {
-		return nil, fmt.Errorf("invalid billing period format: %s", err)
+		return nil, fmt.Errorf("invoice json marshal: %s", err)
 	}
 
-	calls, err := readCalls(fileReader, args.callsCSVFileName)
+	return invoiceJSON, nil
+}
+
+// generate reads the input files and generates the invoice. With trace, each
+// call explains how it was rated.
+func generate(userFinder user.Finder, files FileSystem, args arguments, trace bool) (invoice.Invoice, error) {
+	types := call.DefaultRegistry()
+
+	callTariff, err := readTariff(files, args.tariffFileName, types.CallTypes())
 	if err != nil {
-		return nil, fmt.Errorf("reading calls: %s", err)
+		return invoice.Invoice{}, fmt.Errorf("reading tariff: %s", err)
 	}
 
-	invoice, err := invoice.Generate(userFinder, args.userTelephoneNumber, billingPeriod, calls)
+	tariffHistory, err := readTariffHistory(files, args.tariffHistoryName, types.CallTypes())
 	if err != nil {
-		return nil, fmt.Errorf("generating invoice: %s", err)
+		return invoice.Invoice{}, fmt.Errorf("reading tariff history: %s", err)
 	}
 
-	invoiceJSON, err := json.Marshal(invoice)
+	rates, err := readRates(files, args.ratesFileName)
 	if err != nil {
-		return nil, fmt.Errorf("invoice json marshal: %s", err)
+		return invoice.Invoice{}, fmt.Errorf("reading exchange rates: %s", err)
 	}
 
-	return invoiceJSON, nil
-}
+	taxes, err := readTaxes(files, args.taxesFileName, types.CallTypes())
+	if err != nil {
+		return invoice.Invoice{}, fmt.Errorf("reading taxes: %s", err)
+	}
 
-func parseArgs(args []string) (arguments, error) {
-	if len(args) != 4 {
-		return arguments{}, errors.New("wrong number of arguments, expected 4")
+	promotions, err := readPromotions(files, args.promotionsFileName, types.CallTypes())
+	if err != nil {
+		return invoice.Invoice{}, fmt.Errorf("reading promotions: %s", err)
 	}
 
-	return arguments{
-		userTelephoneNumber: args[0],
-		billingPeriodStart:  args[1],
-		billingPeriodEnd:    args[2],
-		callsCSVFileName:    args[3],
-	}, nil
-}
+	plans, err := readPlans(files, args.plansFileName, types.CallTypes())
+	if err != nil {
+		return invoice.Invoice{}, fmt.Errorf("reading plans: %s", err)
+	}
 
-func makeBillingPeriod(start, end string) (timeutil.Period, error) {
-	const dateFormat = "2006-01-02"
-	billingPeriodStart, err := time.Parse(dateFormat, start)
+	charges, err := readCharges(files, args.chargesFileName)
 	if err != nil {
-		return timeutil.Period{}, errors.New("invalid start date format, expected AAAA-MM-DD")
+		return invoice.Invoice{}, fmt.Errorf("reading charges: %s", err)
 	}
 
-	billingPeriodEnd, err := time.Parse(dateFormat, end)
+	holidays, err := readHolidays(files, args.holidaysFileName)
 	if err != nil {
-		return timeutil.Period{}, errors.New("invalid end date format, expected AAAA-MM-DD")
+		return invoice.Invoice{}, fmt.Errorf("reading holidays: %s", err)
+	}
+
+	billingPeriod, err := makeBillingPeriod(args)
+	if err != nil {
+		return invoice.Invoice{}, fmt.Errorf("invalid billing period format: %s", err)
 	}
 
-	return timeutil.Period{Start: billingPeriodStart, End: billingPeriodEnd}, nil
+	callColumns, err := readColumns(files, args.columnsFileName)
+	if err != nil {
+		return invoice.Invoice{}, fmt.Errorf("reading columns: %s", err)
+	}
+
+	callsFiles, err := expandCallsPaths(files, args.callsFileNames)
+	if err != nil {
+		return invoice.Invoice{}, fmt.Errorf("reading calls: %s", err)
+	}
+
+	calls := newCallFiles(files, callsFiles, callColumns, args.inputFormat)
+	defer calls.Close()
+
+	generated, err := invoice.GenerateFrom(
+		userFinder,
+		args.userTelephoneNumber,
+		billingPeriod,
+		invoice.Config{
+			Tariff:        callTariff,
+			TariffHistory: tariffHistory,
+			Rates:         rates,
+			Taxes:         taxes,
+			Promotions:    promotions,
+			Plans:         plans,
+			Charges:       charges,
+			Holidays:      holidays,
+			Types:         types,
+			Trace:         trace,
+		},
+		calls,
+	)
+	if calls.err != nil {
+		return invoice.Invoice{}, fmt.Errorf("reading calls: %s", calls.err)
+	}
+
+	if err != nil {
+		return invoice.Invoice{}, fmt.Errorf("generating invoice: %s", err)
+	}
+
+	return generated, nil
 }
 
-// readCalls reads the calls csv file from the specified file. Each row should
-// have the following fields:
-//  - Destination phone number
-//  - Source phone number
-//  - Duration (in seconds)
-//  - Date (ISO8601 in UTC)
-//
-func readCalls(fileReader FileReader, path string) ([]call.Call, error) {
-	// Nota de diseño: En vez de hacer os.ReadFile para leer el contenido
-	// entero, podría haber hecho os.Read y leer línea por línea. Eso sería
-	// más escalable para archivos más grandes que no entren en memoria.
-	// Tome esta decisión por simplicidad y dado que era más sencillo de mockear
-	// (os.Read devuelve *os.File).
-	content, err := fileReader(path)
-	if err != nil {
-		return nil, fmt.Errorf("invalid csv path: %s", err)
-	}
-
-	// Columns are: numero origen,numero destino,duracion,fecha
-	reader := csv.NewReader(bytes.NewReader(content))
-	reader.FieldsPerRecord = 4
-	reader.Read() // Skip the header column
-
-	var calls []call.Call
-
-	currentRow := 2 // we skipped the first one
-	for {
-		record, err := reader.Read()
-		if err == io.EOF {
-			break // finished reading the file
-		}
+// parseArgs parses the arguments of the invoice, and the ones of the explain
+// mode if explain is set.
+func parseArgs(rawArgs []string, explain bool) (arguments, error) {
+	var args arguments
+
+	flags := flag.NewFlagSet("invoice-generator", flag.ContinueOnError)
+	flags.SetOutput(io.Discard) // errors are returned, not printed
+	flags.StringVar(&args.tariffFileName, "tariff", "", "path to the tariff file")
+	flags.StringVar(&args.tariffHistoryName, "tariff-history", "", "path to the tariff history file")
+	flags.StringVar(&args.ratesFileName, "rates", "", "path to the exchange rates file")
+	flags.StringVar(&args.taxesFileName, "taxes", "", "path to the taxes file")
+	flags.StringVar(&args.promotionsFileName, "promotions", "", "path to the promotions file")
+	flags.StringVar(&args.plansFileName, "plans", "", "path to the plans file")
+	flags.StringVar(&args.chargesFileName, "charges", "", "path to the charges file")
+	flags.StringVar(&args.holidaysFileName, "holidays", "", "path to the holidays file")
+	flags.StringVar(&args.columnsFileName, "columns", "", "path to the file with aliases of the headers of the calls file")
+	flags.StringVar(&args.inputFormat, "input-format", "", "format of the calls files (csv, json or ndjson), by default detected by their extension")
+	flags.StringVar(&args.timeZone, "time-zone", "UTC", "time zone of the billing period (e.g. America/Argentina/Buenos_Aires)")
+	flags.StringVar(&args.billingCycle, "cycle", "", "billing cycle to invoice, instead of the start and end dates (AAAA-MM)")
+	flags.IntVar(&args.cycleDay, "cycle-day", 1, "day of the month billing cycles start on")
+	if explain {
+		flags.StringVar(&args.callTimestamp, "call", "", "timestamp of the call to explain")
+	}
 
-		if err != nil {
-			// csv reader errors already have line numbers, so we don't need
-			// to add currentRow
-			return nil, err
+	if err := flags.Parse(rawArgs); err != nil {
+		return arguments{}, err
+	}
+
+	if args.tariffFileName != "" && args.tariffHistoryName != "" {
+		return arguments{}, errors.New("--tariff and --tariff-history can't be used together")
+	}
+
+	if err := validateInputFormat(args.inputFormat); err != nil {
+		return arguments{}, err
+	}
+
+	positional := flags.Args()
+	if args.billingCycle != "" {
+		// The billing period is the cycle, so there are no start and end dates
+		if len(positional) < 2 {
+			return arguments{}, errors.New("wrong number of arguments, expected at least 2 with --cycle")
 		}
 
-		call, err := recordToCall(record)
-		if err != nil {
-			return nil, fmt.Errorf("record on line %d: %s", currentRow, err)
+		// Dates would be taken as calls files, which is surely a mistake
+		if _, err := time.Parse(timeutil.LayoutDate, positional[1]); err == nil {
+			return arguments{}, errors.New("billing period dates can't be used with --cycle")
 		}
 
-		calls = append(calls, call)
+		args.userTelephoneNumber = positional[0]
+		args.callsFileNames = positional[1:]
+		return args, nil
+	}
+
+	if len(positional) < 4 {
+		return arguments{}, errors.New("wrong number of arguments, expected at least 4")
+	}
+
+	args.userTelephoneNumber = positional[0]
+	args.billingPeriodStart = positional[1]
+	args.billingPeriodEnd = positional[2]
+	args.callsFileNames = positional[3:]
+
+	return args, nil
+}
+
+// readTariff reads the tariff from the specified file, or returns the default
+// one if no file was specified.
+func readTariff(files FileSystem, path string, types tariff.CallTypes) (tariff.Tariff, error) {
+	if path == "" {
+		return tariff.Default(), nil
+	}
+
+	content, err := files.ReadFile(path)
+	if err != nil {
+		return tariff.Tariff{}, fmt.Errorf("invalid tariff path: %s", err)
+	}
+
+	return tariff.Load(content, types)
+}
+
+// readTariffHistory reads the versions of the tariff from the specified file.
+// If no file was specified the history is empty, and the tariff is always
+// effective.
+func readTariffHistory(files FileSystem, path string, types tariff.CallTypes) (tariff.History, error) {
+	if path == "" {
+		return tariff.History{}, nil
+	}
+
+	content, err := files.ReadFile(path)
+	if err != nil {
+		return tariff.History{}, fmt.Errorf("invalid tariff history path: %s", err)
+	}
+
+	return tariff.LoadHistory(content, types)
+}
 
-		currentRow++
+// readRates reads the exchange rates from the specified file. If no file was
+// specified there are no rates, which is fine as long as the invoice doesn't
+// need to convert currencies.
+func readRates(files FileSystem, path string) (exchange.Rates, error) {
+	if path == "" {
+		return exchange.Rates{}, nil
 	}
 
-	return calls, nil
+	content, err := files.ReadFile(path)
+	if err != nil {
+		return exchange.Rates{}, fmt.Errorf("invalid rates path: %s", err)
+	}
+
+	return exchange.Load(content)
 }
 
-func recordToCall(record []string) (call.Call, error) {
-	sourcePhoneNumber := record[0]
-	destPhoneNumber := record[1]
-	duration, err := parseDuration(record[2])
+// readTaxes reads the taxes from the specified file. If no file was specified
+// no taxes are charged.
+func readTaxes(files FileSystem, path string, types tariff.CallTypes) (tax.Taxes, error) {
+	if path == "" {
+		return tax.Taxes{}, nil
+	}
+
+	content, err := files.ReadFile(path)
 	if err != nil {
-		return call.Call{}, fmt.Errorf("parsing duration: %s", err)
+		return tax.Taxes{}, fmt.Errorf("invalid taxes path: %s", err)
+	}
+
+	return tax.Load(content, types)
+}
+
+// readPromotions reads the configurable promotions from the specified file. If
+// no file was specified only the built-in promotions apply.
+func readPromotions(files FileSystem, path string, types tariff.CallTypes) (promotion.Config, error) {
+	if path == "" {
+		return promotion.Config{}, nil
 	}
 
-	date, err := time.Parse(timeutil.LayoutISO8601, record[3])
+	content, err := files.ReadFile(path)
 	if err != nil {
-		return call.Call{}, fmt.Errorf("parsing date: %s", err)
+		return promotion.Config{}, fmt.Errorf("invalid promotions path: %s", err)
 	}
 
-	return call.New(destPhoneNumber, sourcePhoneNumber, duration, date)
+	return promotion.Load(content, types)
 }
 
-func parseDuration(rawDuration string) (uint, error) {
-	// Nota: con 32 bits para segundos nos alcanza para llamadas de 8100 años,
-	// así que deberíamos estar bien :P
-	duration, err := strconv.ParseUint(rawDuration, 10, 32)
+// readPlans reads the plans users subscribe to from the specified file. If no
+// file was specified there are no plans, so users with one can't be invoiced.
+func readPlans(files FileSystem, path string, types tariff.CallTypes) (plan.Plans, error) {
+	if path == "" {
+		return plan.Plans{}, nil
+	}
+
+	content, err := files.ReadFile(path)
+	if err != nil {
+		return plan.Plans{}, fmt.Errorf("invalid plans path: %s", err)
+	}
+
+	return plan.Load(content, types)
+}
+
+// readCharges reads the charges of users that aren't calls from the specified
+// file. If no file was specified users are only charged for their calls.
+func readCharges(files FileSystem, path string) (charge.Charges, error) {
+	if path == "" {
+		return charge.Charges{}, nil
+	}
+
+	content, err := files.ReadFile(path)
+	if err != nil {
+		return charge.Charges{}, fmt.Errorf("invalid charges path: %s", err)
+	}
+
+	return charge.Load(content)
+}
+
+// readHolidays reads the holiday calendar from the specified file. If no file
+// was specified there are no holidays.
+func readHolidays(files FileSystem, path string) (holiday.Calendar, error) {
+	if path == "" {
+		return holiday.Calendar{}, nil
+	}
+
+	content, err := files.ReadFile(path)
+	if err != nil {
+		return holiday.Calendar{}, fmt.Errorf("invalid holidays path: %s", err)
+	}
+
+	return holiday.Load(content)
+}
+
+// readColumns reads the aliases of the headers of the calls files from the
+// specified file. If no file was specified only the default headers are known.
+func readColumns(files FileSystem, path string) (call.Columns, error) {
+	if path == "" {
+		return call.DefaultColumns(), nil
+	}
+
+	content, err := files.ReadFile(path)
+	if err != nil {
+		return call.Columns{}, fmt.Errorf("invalid columns path: %s", err)
+	}
+
+	return call.LoadColumns(content)
+}
+
+// makeBillingPeriod returns the billing period of the arguments, in their time
+// zone: either the billing cycle, or the whole days from the start date to the
+// end one (both included). For example, from 2022-11-01 to 2022-11-30 is from
+// the start of the 1st of November until the start of the 1st of December.
+func makeBillingPeriod(args arguments) (timeutil.Period, error) {
+	location, err := time.LoadLocation(args.timeZone)
+	if err != nil {
+		return timeutil.Period{}, fmt.Errorf("invalid time zone %q", args.timeZone)
+	}
+
+	if args.billingCycle != "" {
+		return makeCyclePeriod(args.billingCycle, args.cycleDay, location)
+	}
+
+	billingPeriodStart, err := time.Parse(timeutil.LayoutDate, args.billingPeriodStart)
+	if err != nil {
+		return timeutil.Period{}, errors.New("invalid start date format, expected AAAA-MM-DD")
+	}
+
+	billingPeriodEnd, err := time.Parse(timeutil.LayoutDate, args.billingPeriodEnd)
+	if err != nil {
+		return timeutil.Period{}, errors.New("invalid end date format, expected AAAA-MM-DD")
+	}
+
+	if billingPeriodEnd.Before(billingPeriodStart) {
+		return timeutil.Period{}, errors.New("end date is before the start date")
+	}
+
+	return timeutil.Days(billingPeriodStart, billingPeriodEnd, location), nil
+}
+
+// makeCyclePeriod returns the period of the billing cycle that starts on the
+// day of the month (AAAA-MM).
+func makeCyclePeriod(month string, day int, location *time.Location) (timeutil.Period, error) {
+	const monthFormat = "2006-01"
+	cycleMonth, err := time.Parse(monthFormat, month)
+	if err != nil {
+		return timeutil.Period{}, errors.New("invalid cycle format, expected AAAA-MM")
+	}
+
+	cycle, err := timeutil.NewCycle(day, location)
 	if err != nil {
-		return 0, err
+		return timeutil.Period{}, err
 	}
 
-	return uint(duration), nil
+	return cycle.Starting(cycleMonth.Year(), cycleMonth.Month()), nil
 }
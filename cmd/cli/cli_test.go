@@ -1,12 +1,19 @@
 package cli_test
 
 import (
-	"errors"
+	"bytes"
+	"compress/gzip"
+	"encoding/json"
 	"fmt"
 	"invoice-generator/cmd/cli"
 	"invoice-generator/pkg/user"
+	"io"
+	"os"
+	"runtime"
 	"testing"
+	"testing/fstest"
 
+	"github.com/klauspost/compress/zstd"
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/require"
 )
@@ -18,7 +25,7 @@ const (
 
 func TestOnInvalidArgumentsShouldReturnError(t *testing.T) {
 	_, err := cli.Run(defaultUserFinder(), defaultReader(), []string{"just one arg"})
-	assert.EqualError(t, err, "parsing arguments: wrong number of arguments, expected 4. Usage:\n\t./invoice-generator <telephone> <billing_start> <billing_end> <calls_csv_file>")
+	assert.EqualError(t, err, "parsing arguments: wrong number of arguments, expected at least 4. Usage:\n\t./invoice-generator [--tariff <tariff_file> | --tariff-history <tariff_history_file>] [--rates <rates_file>] [--taxes <taxes_file>] [--promotions <promotions_file>] [--plans <plans_file>] [--charges <charges_file>] [--holidays <holidays_file>] [--columns <columns_file>] [--input-format <csv|json|ndjson>] [--time-zone <time_zone>] [--cycle <AAAA-MM> [--cycle-day <day>]] <telephone> [<billing_start> <billing_end>] <calls_file>...")
 }
 
 func TestShouldFailWithInvalidBillingPeriodStart(t *testing.T) {
@@ -33,65 +40,148 @@ func TestShouldFailWithInvalidBillingPeriodEnd(t *testing.T) {
 	assert.EqualError(t, err, "invalid billing period format: invalid end date format, expected AAAA-MM-DD")
 }
 
-func TestShouldFailOnInvalidCSVPath(t *testing.T) {
-	failingReader := func(_ string) ([]byte, error) {
-		return nil, errors.New("not found")
+func TestShouldFailWithBillingPeriodEndBeforeStart(t *testing.T) {
+	_, err := cli.Run(defaultUserFinder(), defaultReader(), []string{phone, "2022-10-02", "2022-10-01", filename})
+	assert.EqualError(t, err, "invalid billing period format: end date is before the start date")
+}
+
+func TestShouldFailWithInvalidTimeZone(t *testing.T) {
+	_, err := cli.Run(defaultUserFinder(), defaultReader(), []string{"--time-zone", "Mars/Olympus_Mons", phone, "2022-10-01", "2022-10-01", filename})
+	assert.EqualError(t, err, `invalid billing period format: invalid time zone "Mars/Olympus_Mons"`)
+}
+
+func TestBillingPeriodIsOfWholeDaysInItsTimeZone(t *testing.T) {
+	// In Buenos Aires (UTC-3) the period starts on 2022-11-01T03:00:00Z and
+	// ends on 2022-12-01T03:00:00Z, including calls made on its last day.
+	reader := readerWithContent(`numero origen,numero destino,duracion,fecha
++5491167950940,+541167980953,60,2022-11-01T02:59:59Z
++5491167950940,+541167980953,60,2022-11-01T03:00:00Z
++5491167950940,+541167980953,60,2022-12-01T02:59:59Z
++5491167950940,+541167980953,60,2022-12-01T03:00:00Z`)
+
+	result, err := cli.Run(defaultUserFinder(), reader, []string{"--time-zone", "America/Argentina/Buenos_Aires", phone, "2022-11-01", "2022-11-30", filename})
+	require.NoError(t, err)
+
+	var invoice struct {
+		BillingPeriod map[string]string `json:"billing_period"`
+		Calls         []struct {
+			Timestamp string `json:"timestamp"`
+		} `json:"calls"`
 	}
+	require.NoError(t, json.Unmarshal(result, &invoice))
+
+	assert.Equal(t, map[string]string{
+		"start":     "2022-11-01T00:00:00-03:00",
+		"end":       "2022-12-01T00:00:00-03:00",
+		"time_zone": "America/Argentina/Buenos_Aires",
+	}, invoice.BillingPeriod)
+
+	require.Len(t, invoice.Calls, 2)
+	assert.Equal(t, "2022-11-01T03:00:00Z", invoice.Calls[0].Timestamp)
+	assert.Equal(t, "2022-12-01T02:59:59Z", invoice.Calls[1].Timestamp)
+}
+
+func TestBillingPeriodOfACycle(t *testing.T) {
+	// With a cycle day of 31, the cycle of February starts on its last day
+	reader := readerWithContent(`numero origen,numero destino,duracion,fecha
++5491167950940,+541167980953,60,2024-02-28T23:59:59Z
++5491167950940,+541167980953,60,2024-02-29T00:00:00Z
++5491167950940,+541167980953,60,2024-03-30T23:59:59Z
++5491167950940,+541167980953,60,2024-03-31T00:00:00Z`)
+
+	result, err := cli.Run(defaultUserFinder(), reader, []string{"--cycle", "2024-02", "--cycle-day", "31", phone, filename})
+	require.NoError(t, err)
+
+	var invoice struct {
+		BillingPeriod map[string]string `json:"billing_period"`
+		Calls         []struct {
+			Timestamp string `json:"timestamp"`
+		} `json:"calls"`
+	}
+	require.NoError(t, json.Unmarshal(result, &invoice))
+
+	assert.Equal(t, map[string]string{
+		"start":     "2024-02-29T00:00:00Z",
+		"end":       "2024-03-31T00:00:00Z",
+		"time_zone": "UTC",
+	}, invoice.BillingPeriod)
+
+	require.Len(t, invoice.Calls, 2)
+	assert.Equal(t, "2024-02-29T00:00:00Z", invoice.Calls[0].Timestamp)
+	assert.Equal(t, "2024-03-30T23:59:59Z", invoice.Calls[1].Timestamp)
+}
+
+func TestShouldFailWithInvalidCycle(t *testing.T) {
+	_, err := cli.Run(defaultUserFinder(), defaultReader(), []string{"--cycle", "2022-11-01", phone, filename})
+	assert.EqualError(t, err, "invalid billing period format: invalid cycle format, expected AAAA-MM")
+
+	_, err = cli.Run(defaultUserFinder(), defaultReader(), []string{"--cycle", "2022-11", "--cycle-day", "32", phone, filename})
+	assert.EqualError(t, err, "invalid billing period format: invalid cycle day 32, should be between 1 and 31")
+}
+
+func TestCycleReplacesTheBillingPeriodDates(t *testing.T) {
+	_, err := cli.Run(defaultUserFinder(), defaultReader(), []string{"--cycle", "2022-11", phone, "2022-11-01", "2022-11-30", filename})
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), "parsing arguments: billing period dates can't be used with --cycle")
+}
+
+func TestShouldFailOnInvalidCSVPath(t *testing.T) {
+	failingReader := readerWithFiles(nil)
 
 	_, err := cli.Run(defaultUserFinder(), failingReader, []string{phone, "2022-10-01", "2022-10-01", filename})
-	assert.EqualError(t, err, "reading calls: invalid csv path: not found")
+	assert.EqualError(t, err, "reading calls: invalid calls path: open filename-doesnt-matter: file does not exist")
 }
 
 func TestShouldFailOnLineWithWrongNumberOfFields(t *testing.T) {
 	// Line 3 doesn't have the duration field
 	reader := readerWithContent(`numero origen,numero destino,duracion,fecha
-	+5491167980950,+191167980952,462,2020-11-10T04:02:45Z
-	+5491167980950,+191167980952,2020-11-10T04:02:45Z
-	+5491167910920,+191167980952,392,2020-08-09T04:45:25Z`)
++5491167980950,+191167980952,462,2020-11-10T04:02:45Z
++5491167980950,+191167980952,2020-11-10T04:02:45Z
++5491167910920,+191167980952,392,2020-08-09T04:45:25Z`)
 
 	_, err := cli.Run(defaultUserFinder(), reader, []string{phone, "2022-10-01", "2022-10-01", filename})
-	assert.EqualError(t, err, "reading calls: record on line 3: wrong number of fields")
+	assert.EqualError(t, err, "reading calls: filename-doesnt-matter: record on line 3: wrong number of fields")
 }
 
 func TestShouldFailOnLineWithInvalidDuration(t *testing.T) {
 	// In line 3, the duration field is a string instead of an int
 	reader := readerWithContent(`numero origen,numero destino,duracion,fecha
-	+5491167980950,+191167980952,462,2020-11-10T04:02:45Z
-	+5491167980950,+191167980952,esto-no-es-duracion,2020-11-10T04:02:45Z
-	+5491167910920,+191167980952,392,2020-08-09T04:45:25Z`)
++5491167980950,+191167980952,462,2020-11-10T04:02:45Z
++5491167980950,+191167980952,esto-no-es-duracion,2020-11-10T04:02:45Z
++5491167910920,+191167980952,392,2020-08-09T04:45:25Z`)
 
 	_, err := cli.Run(defaultUserFinder(), reader, []string{phone, "2022-10-01", "2022-10-01", filename})
-	assert.EqualError(t, err, "reading calls: record on line 3: parsing duration: strconv.ParseUint: parsing \"esto-no-es-duracion\": invalid syntax")
+	assert.EqualError(t, err, "reading calls: filename-doesnt-matter: record on line 3: parsing duration: strconv.ParseUint: parsing \"esto-no-es-duracion\": invalid syntax")
 }
 
 func TestShouldFailOnLineWithInvalidDate(t *testing.T) {
 	reader := readerWithContent(`numero origen,numero destino,duracion,fecha
-	+5491167980950,+191167980952,462,2020-11-10T04:02:45Z
-	+5491167980950,+191167980952,400,2020-11-10T:02:45Z
-	+5491167910920,+191167980952,392,2020-08-09T04:45:25Z`)
++5491167980950,+191167980952,462,2020-11-10T04:02:45Z
++5491167980950,+191167980952,400,2020-11-10T:02:45Z
++5491167910920,+191167980952,392,2020-08-09T04:45:25Z`)
 
 	_, err := cli.Run(defaultUserFinder(), reader, []string{phone, "2022-10-01", "2022-10-01", filename})
-	assert.EqualError(t, err, "reading calls: record on line 3: parsing date: parsing time \"2020-11-10T:02:45Z\" as \"2006-01-02T15:04:05Z\": cannot parse \":02:45Z\" as \"15\"")
+	assert.EqualError(t, err, "reading calls: filename-doesnt-matter: record on line 3: parsing date: parsing time \"2020-11-10T:02:45Z\" as \"2006-01-02T15:04:05Z\": cannot parse \":02:45Z\" as \"15\"")
 }
 
 func TestShouldFailOnLineWithInvalidDestinationNumber(t *testing.T) {
 	reader := readerWithContent(`numero origen,numero destino,duracion,fecha
-	+5491167980950,+191167980952,462,2020-11-10T04:02:45Z
-	+5491167980950,+191167980,400,2020-11-10T04:02:45Z
-	+5491167910920,+191167980952,392,2020-08-09T04:45:25Z`)
++5491167980950,+191167980952,462,2020-11-10T04:02:45Z
++5491167980950,+99911679809,400,2020-11-10T04:02:45Z
++5491167910920,+191167980952,392,2020-08-09T04:45:25Z`)
 
 	_, err := cli.Run(defaultUserFinder(), reader, []string{phone, "2022-10-01", "2022-10-01", filename})
-	assert.EqualError(t, err, "reading calls: record on line 3: destination phone: invalid format, should match \\+[0-9]{12,13}")
+	assert.EqualError(t, err, "reading calls: filename-doesnt-matter: record on line 3: destination phone: invalid number \"+99911679809\": unknown country code")
 }
 
 func TestShouldFailOnLineWithInvalidSourceNumber(t *testing.T) {
 	reader := readerWithContent(`numero origen,numero destino,duracion,fecha
-	+5491167980950,+191167980952,462,2020-11-10T04:02:45Z
-	+5491167980,+5491167980950,400,2020-11-10T04:02:45Z
-	+5491167910920,+191167980952,392,2020-08-09T04:45:25Z`)
++5491167980950,+191167980952,462,2020-11-10T04:02:45Z
+5491167980950,+5491167980950,400,2020-11-10T04:02:45Z
++5491167910920,+191167980952,392,2020-08-09T04:45:25Z`)
 
 	_, err := cli.Run(defaultUserFinder(), reader, []string{phone, "2022-10-01", "2022-10-01", filename})
-	assert.EqualError(t, err, "reading calls: record on line 3: source phone: invalid format, should match \\+[0-9]{12,13}")
+	assert.EqualError(t, err, "reading calls: filename-doesnt-matter: record on line 3: source phone: invalid number \"5491167980950\": must start with +")
 }
 
 func TestShouldReturnInvoiceGenerationErrors(t *testing.T) {
@@ -124,35 +214,792 @@ func TestReturnsGeneratedInvoice(t *testing.T) {
 			"name": "Hideo Kojima",
 			"phone_number": "+5491167950940"
 		},
+		"billing_period": {"start": "2020-01-01T00:00:00Z", "end": "2022-09-02T00:00:00Z", "time_zone": "UTC"},
+		"currency": "ARS",
 		"calls": [
 			{
 				"phone_number": "+191167980952",
 				"duration": 462,
+				"billed_duration": 462,
 				"timestamp": "2020-11-10T04:02:45Z",
-				"amount": 462.0
+				"amount": "462.00"
 			},
 			{
 				"phone_number": "+191167980952",
 				"duration": 392,
+				"billed_duration": 392,
 				"timestamp": "2020-08-09T04:45:25Z",
-				"amount": 392.0
+				"amount": "392.00"
 			},
 			{
 				"phone_number": "+541167980953",
 				"duration": 60,
+				"billed_duration": 60,
 				"timestamp": "2020-05-10T04:45:25Z",
-				"amount": 0.0
+				"amount": "0.00",
+				"promotions": ["free_calls_to_friends"]
 			}
 		],
 		"total_international_seconds":854,
 		"total_national_seconds":60,
 		"total_friends_seconds":60,
-		"total":854
+		"total_interplanetary_seconds":0,
+		"total_international_billed_seconds":854,
+		"total_national_billed_seconds":60,
+		"total_friends_billed_seconds":60,
+		"total_interplanetary_billed_seconds":0,
+		"items": [
+			{"type": "calls", "description": "international calls", "quantity": 2, "amount": "854.00"},
+			{"type": "calls", "description": "national calls", "quantity": 1, "amount": "0.00"}
+		],
+		"subtotal":"854.00",
+		"total":"854.00"
 	}`
 	fmt.Printf("expected: %s\nactual:%s", expectedInvoice, string(result))
 	assert.JSONEq(t, expectedInvoice, string(result))
 }
 
+func TestShouldFailOnInvalidTariffPath(t *testing.T) {
+	failingReader := readerWithFiles(nil)
+
+	_, err := cli.Run(defaultUserFinder(), failingReader, []string{"--tariff", "tariff.yaml", phone, "2022-10-01", "2022-10-01", filename})
+	assert.EqualError(t, err, "reading tariff: invalid tariff path: open tariff.yaml: file does not exist")
+}
+
+func TestShouldFailOnInvalidTariffWithItsLine(t *testing.T) {
+	reader := readerWithFiles(map[string]string{
+		"tariff.yaml": `national:
+  per_call: 2.5
+international:
+  per_second: -1`,
+	})
+
+	_, err := cli.Run(defaultUserFinder(), reader, []string{"--tariff", "tariff.yaml", phone, "2022-10-01", "2022-10-01", filename})
+	assert.EqualError(t, err, "reading tariff: line 4: international.per_second can't be negative")
+}
+
+func TestUsesTariffFromFile(t *testing.T) {
+	reader := readerWithFiles(map[string]string{
+		"tariff.yaml": `national:
+  per_call: 3
+international:
+  per_minute: 2
+  increments: {initial: 60, subsequent: 60}`,
+		filename: `numero origen,numero destino,duracion,fecha
++5491167950940,+191167980952,61,2020-11-10T04:02:45Z
++5491167950940,+541167980953,60,2020-05-10T04:45:25Z`,
+	})
+
+	result, err := cli.Run(defaultUserFinder(), reader, []string{"--tariff", "tariff.yaml", phone, "2020-01-01", "2022-09-01", filename})
+	require.NoError(t, err)
+
+	expectedInvoice := `{
+		"user": {
+			"address": "Calle Falsa 123",
+			"name": "Antonio Banderas",
+			"phone_number": "+5491167950940"
+		},
+		"billing_period": {"start": "2020-01-01T00:00:00Z", "end": "2022-09-02T00:00:00Z", "time_zone": "UTC"},
+		"currency": "ARS",
+		"calls": [
+			{
+				"phone_number": "+191167980952",
+				"duration": 61,
+				"billed_duration": 120,
+				"timestamp": "2020-11-10T04:02:45Z",
+				"amount": "4.00"
+			},
+			{
+				"phone_number": "+541167980953",
+				"duration": 60,
+				"billed_duration": 60,
+				"timestamp": "2020-05-10T04:45:25Z",
+				"amount": "3.00"
+			}
+		],
+		"total_international_seconds":61,
+		"total_national_seconds":60,
+		"total_friends_seconds":0,
+		"total_interplanetary_seconds":0,
+		"total_international_billed_seconds":120,
+		"total_national_billed_seconds":60,
+		"total_friends_billed_seconds":0,
+		"total_interplanetary_billed_seconds":0,
+		"items": [
+			{"type": "calls", "description": "international calls", "quantity": 1, "amount": "4.00"},
+			{"type": "calls", "description": "national calls", "quantity": 1, "amount": "3.00"}
+		],
+		"subtotal":"7.00",
+		"total":"7.00"
+	}`
+	assert.JSONEq(t, expectedInvoice, string(result))
+}
+
+func TestUsesTheTariffEffectiveOnTheDateOfEachCallFromHistoryFile(t *testing.T) {
+	reader := readerWithFiles(map[string]string{
+		"history.yaml": `versions:
+  - effective_from: 2020-01-01
+    national: {per_call: 2.5}
+    international: {per_second: 1}
+  - effective_from: 2020-11-15
+    national: {per_call: 3}
+    international: {per_second: 1}`,
+		filename: `numero origen,numero destino,duracion,fecha
++5491167950940,+541167980953,60,2020-11-14T23:59:59Z
++5491167950940,+541167980953,60,2020-11-15T00:00:00Z`,
+	})
+
+	result, err := cli.Run(defaultUserFinder(), reader, []string{"--tariff-history", "history.yaml", phone, "2020-11-01", "2020-11-30", filename})
+	require.NoError(t, err)
+
+	expectedInvoice := `{
+		"user": {
+			"address": "Calle Falsa 123",
+			"name": "Antonio Banderas",
+			"phone_number": "+5491167950940"
+		},
+		"billing_period": {"start": "2020-11-01T00:00:00Z", "end": "2020-12-01T00:00:00Z", "time_zone": "UTC"},
+		"currency": "ARS",
+		"calls": [
+			{
+				"phone_number": "+541167980953",
+				"duration": 60,
+				"billed_duration": 60,
+				"timestamp": "2020-11-14T23:59:59Z",
+				"amount": "2.50"
+			},
+			{
+				"phone_number": "+541167980953",
+				"duration": 60,
+				"billed_duration": 60,
+				"timestamp": "2020-11-15T00:00:00Z",
+				"amount": "3.00"
+			}
+		],
+		"total_international_seconds":0,
+		"total_national_seconds":120,
+		"total_friends_seconds":0,
+		"total_interplanetary_seconds":0,
+		"total_international_billed_seconds":0,
+		"total_national_billed_seconds":120,
+		"total_friends_billed_seconds":0,
+		"total_interplanetary_billed_seconds":0,
+		"items": [
+			{"type": "calls", "description": "national calls", "quantity": 2, "amount": "5.50"}
+		],
+		"subtotal":"5.50",
+		"total":"5.50"
+	}`
+	assert.JSONEq(t, expectedInvoice, string(result))
+}
+
+func TestTariffAndTariffHistoryCantBeUsedTogether(t *testing.T) {
+	_, err := cli.Run(defaultUserFinder(), defaultReader(), []string{"--tariff", "tariff.yaml", "--tariff-history", "history.yaml", phone, "2022-10-01", "2022-10-01", filename})
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), "parsing arguments: --tariff and --tariff-history can't be used together")
+}
+
+func TestConvertsToTheCurrencyOfTheUserWithRatesFromFile(t *testing.T) {
+	reader := readerWithFiles(map[string]string{
+		"tariff.yaml": `currency: USD
+national:
+  per_call: 0.03
+international:
+  per_second: 0.01`,
+		"rates.yaml": `rates:
+  - {date: 2020-01-01, from: USD, to: ARS, rate: 60}
+  - {date: 2020-10-01, from: USD, to: ARS, rate: 78.5}`,
+		filename: `numero origen,numero destino,duracion,fecha
++5491167950940,+191167980952,61,2020-11-10T04:02:45Z
++5491167950940,+541167980953,60,2020-05-10T04:45:25Z`,
+	})
+
+	userFinder := user.NewMockFinderForUser(
+		user.User{
+			Name:     "Antonio Banderas",
+			Address:  "Calle Falsa 123",
+			Phone:    phone,
+			Currency: "ARS",
+		},
+	)
+
+	result, err := cli.Run(userFinder, reader, []string{"--tariff", "tariff.yaml", "--rates", "rates.yaml", phone, "2020-01-01", "2022-09-01", filename})
+	require.NoError(t, err)
+
+	expectedInvoice := `{
+		"user": {
+			"address": "Calle Falsa 123",
+			"name": "Antonio Banderas",
+			"phone_number": "+5491167950940"
+		},
+		"billing_period": {"start": "2020-01-01T00:00:00Z", "end": "2022-09-02T00:00:00Z", "time_zone": "UTC"},
+		"currency": "ARS",
+		"calls": [
+			{
+				"phone_number": "+191167980952",
+				"duration": 61,
+				"billed_duration": 61,
+				"timestamp": "2020-11-10T04:02:45Z",
+				"amount": "47.89",
+				"conversion": {
+					"original_amount": "0.61",
+					"original_currency": "USD",
+					"rate": "78.5",
+					"rate_date": "2020-10-01"
+				}
+			},
+			{
+				"phone_number": "+541167980953",
+				"duration": 60,
+				"billed_duration": 60,
+				"timestamp": "2020-05-10T04:45:25Z",
+				"amount": "1.80",
+				"conversion": {
+					"original_amount": "0.03",
+					"original_currency": "USD",
+					"rate": "60",
+					"rate_date": "2020-01-01"
+				}
+			}
+		],
+		"total_international_seconds":61,
+		"total_national_seconds":60,
+		"total_friends_seconds":0,
+		"total_interplanetary_seconds":0,
+		"total_international_billed_seconds":61,
+		"total_national_billed_seconds":60,
+		"total_friends_billed_seconds":0,
+		"total_interplanetary_billed_seconds":0,
+		"items": [
+			{"type": "calls", "description": "international calls", "quantity": 1, "amount": "47.89"},
+			{"type": "calls", "description": "national calls", "quantity": 1, "amount": "1.80"}
+		],
+		"subtotal":"49.69",
+		"total":"49.69"
+	}`
+	assert.JSONEq(t, expectedInvoice, string(result))
+}
+
+func TestShouldFailOnInvalidRatesWithItsLine(t *testing.T) {
+	reader := readerWithFiles(map[string]string{
+		"rates.yaml": `rates:
+  - {date: 2020-01-01, from: USD, to: ARS, rate: 0}`,
+	})
+
+	_, err := cli.Run(defaultUserFinder(), reader, []string{"--rates", "rates.yaml", phone, "2022-10-01", "2022-10-01", filename})
+	assert.EqualError(t, err, "reading exchange rates: line 2: rate must be positive")
+}
+
+func TestShouldFailOnInvalidHolidaysWithItsLine(t *testing.T) {
+	reader := readerWithFiles(map[string]string{
+		"holidays.yaml": `countries:
+  AR:
+    - {date: 2022-12-25}`,
+	})
+
+	_, err := cli.Run(defaultUserFinder(), reader, []string{"--holidays", "holidays.yaml", phone, "2022-10-01", "2022-10-01", filename})
+	assert.EqualError(t, err, "reading holidays: line 3: holiday on 2022-12-25 must have a name")
+}
+
+func TestColumnsFromFile(t *testing.T) {
+	reader := readerWithFiles(map[string]string{
+		"columns.yaml": `source: [a_number]
+destination: [b_number]
+duration: [secs]
+date: [start_time]`,
+		filename: `start_time,b_number,a_number,secs,cell_id
+2020-11-10T04:02:45Z,+191167980952,+5491167950940,10,1234`,
+	})
+
+	result, err := cli.Run(defaultUserFinder(), reader, []string{"--columns", "columns.yaml", phone, "2020-01-01", "2022-09-01", filename})
+	require.NoError(t, err)
+
+	var generated struct {
+		Calls []struct {
+			Phone    string `json:"phone_number"`
+			Duration uint   `json:"duration"`
+		} `json:"calls"`
+	}
+	require.NoError(t, json.Unmarshal(result, &generated))
+	require.Len(t, generated.Calls, 1)
+	assert.Equal(t, "+191167980952", generated.Calls[0].Phone)
+	assert.Equal(t, uint(10), generated.Calls[0].Duration)
+}
+
+func TestShouldFailOnMissingColumn(t *testing.T) {
+	reader := readerWithContent(`numero origen,numero destino,fecha
++5491167950940,+191167980952,2020-11-10T04:02:45Z`)
+
+	_, err := cli.Run(defaultUserFinder(), reader, []string{phone, "2020-01-01", "2022-09-01", filename})
+	assert.EqualError(t, err, `reading calls: filename-doesnt-matter: header: missing duration column, expected one named "duracion" or "duración" or "duration"`)
+}
+
+func TestCallsFromSeveralFiles(t *testing.T) {
+	const header = "numero origen,numero destino,duracion,fecha\n"
+	reader := readerWithFiles(map[string]string{
+		"cdr/2020-11-10T04.csv":    header + "+5491167950940,+191167980952,1,2020-11-10T04:02:45Z",
+		"cdr/2020-11-10T05.csv":    header + "+5491167950940,+191167980952,2,2020-11-10T05:02:45Z",
+		"cdr/.hidden.csv":          header + "+5491167950940,+191167980952,9,2020-11-10T06:02:45Z",
+		"archive/2020-11-09.csv":   header + "+5491167950940,+191167980952,3,2020-11-09T04:02:45Z",
+		"archive/2020-11-08.csv":   header + "+5491167950940,+191167980952,4,2020-11-08T04:02:45Z",
+		"archive/readme.txt":       "not calls",
+		"-":                        header + "+5491167950940,+191167980952,5,2020-11-11T04:02:45Z",
+		"other/2020-11-12.csv":     header,
+		"other/sub/2020-11-13.csv": header + "+5491167950940,+191167980952,9,2020-11-13T04:02:45Z",
+	})
+
+	// A directory, a glob, stdin and a file (with no calls)
+	result, err := cli.Run(defaultUserFinder(), reader, []string{phone, "2020-01-01", "2022-09-01", "cdr", "archive/*.csv", "-", "other/2020-11-12.csv"})
+	require.NoError(t, err)
+
+	var generated struct {
+		Calls []struct {
+			Duration uint `json:"duration"`
+		} `json:"calls"`
+	}
+	require.NoError(t, json.Unmarshal(result, &generated))
+
+	var durations []uint
+	for _, c := range generated.Calls {
+		durations = append(durations, c.Duration)
+	}
+	assert.Equal(t, []uint{1, 2, 4, 3, 5}, durations)
+}
+
+func TestPathsThatExistAreNotPatterns(t *testing.T) {
+	const header = "numero origen,numero destino,duracion,fecha\n"
+	reader := readerWithFiles(map[string]string{
+		"calls[1].csv": header + "+5491167950940,+191167980952,1,2020-11-10T04:02:45Z",
+		"calls1.csv":   header + "+5491167950940,+191167980952,2,2020-11-10T05:02:45Z",
+	})
+
+	result, err := cli.Run(defaultUserFinder(), reader, []string{phone, "2020-01-01", "2022-09-01", "calls[1].csv"})
+	require.NoError(t, err)
+
+	var generated struct {
+		Calls []struct {
+			Duration uint `json:"duration"`
+		} `json:"calls"`
+	}
+	require.NoError(t, json.Unmarshal(result, &generated))
+	require.Len(t, generated.Calls, 1)
+	assert.Equal(t, uint(1), generated.Calls[0].Duration)
+}
+
+func TestErrorsOfSeveralFilesSayTheFile(t *testing.T) {
+	const header = "numero origen,numero destino,duracion,fecha\n"
+	reader := readerWithFiles(map[string]string{
+		"first.csv":  header + "+5491167950940,+191167980952,1,2020-11-10T04:02:45Z",
+		"second.csv": header + "+5491167950940,+191167980952,1,2020-11-10T04:02:45Z\n+5491167950940,+191167980952,-1,2020-11-10T04:02:45Z",
+		"-":          header + "+5491167950940,+191167980952,1,2020-11-10",
+	})
+
+	_, err := cli.Run(defaultUserFinder(), reader, []string{phone, "2020-01-01", "2022-09-01", "first.csv", "second.csv"})
+	assert.EqualError(t, err, `reading calls: second.csv: record on line 3: parsing duration: strconv.ParseUint: parsing "-1": invalid syntax`)
+
+	_, err = cli.Run(defaultUserFinder(), reader, []string{phone, "2020-01-01", "2022-09-01", "first.csv", "-"})
+	assert.EqualError(t, err, `reading calls: stdin: record on line 2: parsing date: parsing time "2020-11-10" as "2006-01-02T15:04:05Z": cannot parse "" as "T"`)
+}
+
+func TestInvalidCallsPaths(t *testing.T) {
+	reader := readerWithFiles(map[string]string{
+		"calls.csv":   "numero origen,numero destino,duracion,fecha",
+		"empty/.keep": "",
+	})
+
+	tests := []struct {
+		name  string
+		paths []string
+		err   string
+	}{
+		{
+			name:  "glob without matches",
+			paths: []string{"calls-*.csv"},
+			err:   `reading calls: no calls files match "calls-*.csv"`,
+		},
+		{
+			name:  "empty directory",
+			paths: []string{"empty"},
+			err:   `reading calls: no calls files in directory "empty"`,
+		},
+		{
+			name:  "stdin twice",
+			paths: []string{"-", "calls.csv", "-"},
+			err:   "reading calls: the standard input can only be read once",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			args := append([]string{"--cycle", "2022-11", phone}, tt.paths...)
+			_, err := cli.Run(defaultUserFinder(), reader, args)
+			assert.EqualError(t, err, tt.err)
+		})
+	}
+}
+
+func TestCompressedCallsFiles(t *testing.T) {
+	const calls = `numero origen,numero destino,duracion,fecha
++5491167950940,+191167980952,462,2020-11-10T04:02:45Z
+`
+
+	var gzipped bytes.Buffer
+	gzipWriter := gzip.NewWriter(&gzipped)
+	_, err := gzipWriter.Write([]byte(calls))
+	require.NoError(t, err)
+	require.NoError(t, gzipWriter.Close())
+
+	zstdEncoder, err := zstd.NewWriter(nil)
+	require.NoError(t, err)
+	zstdCompressed := zstdEncoder.EncodeAll([]byte(calls), nil)
+
+	// The standard library can't compress bzip2
+	bzip2Compressed, err := os.ReadFile("testdata/calls.csv.bz2")
+	require.NoError(t, err)
+
+	// Detected by content, not by name
+	reader := readerWithFiles(map[string]string{
+		"calls.gz":  gzipped.String(),
+		"calls.zst": string(zstdCompressed),
+		"calls.bz2": string(bzip2Compressed),
+		"-":         gzipped.String(),
+	})
+
+	for _, name := range []string{"calls.gz", "calls.zst", "calls.bz2", "-"} {
+		t.Run(name, func(t *testing.T) {
+			result, err := cli.Run(defaultUserFinder(), reader, []string{phone, "2020-01-01", "2022-09-01", name})
+			require.NoError(t, err)
+
+			var generated struct {
+				Calls []struct {
+					Duration uint `json:"duration"`
+				} `json:"calls"`
+			}
+			require.NoError(t, json.Unmarshal(result, &generated))
+			require.Len(t, generated.Calls, 1)
+			assert.Equal(t, uint(462), generated.Calls[0].Duration)
+		})
+	}
+}
+
+func TestShouldFailOnCorruptedCompressedFile(t *testing.T) {
+	reader := readerWithContent("\x1f\x8bnot really gzip")
+
+	_, err := cli.Run(defaultUserFinder(), reader, []string{phone, "2020-01-01", "2022-09-01", filename})
+	assert.EqualError(t, err, "reading calls: filename-doesnt-matter: gzip: invalid header")
+}
+
+func TestCallsInJSONFormats(t *testing.T) {
+	const call = `{"source": "+5491167950940", "destination": "+191167980952", "duration": 462, "date": "2020-11-10T04:02:45Z"}`
+	reader := readerWithFiles(map[string]string{
+		"calls.json":   "[" + call + "]",
+		"calls.ndjson": call + "\n" + call,
+		"calls.txt":    call,
+	})
+
+	tests := []struct {
+		name  string
+		args  []string
+		calls int
+	}{
+		{name: "json by extension", args: []string{"calls.json"}, calls: 1},
+		{name: "ndjson by extension", args: []string{"calls.ndjson"}, calls: 2},
+		{name: "several formats", args: []string{"calls.json", "calls.ndjson"}, calls: 3},
+		{name: "flag", args: []string{"--input-format", "ndjson", phone, "2020-01-01", "2022-09-01", "calls.txt"}, calls: 1},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			args := tt.args
+			if args[0] != "--input-format" {
+				args = append([]string{phone, "2020-01-01", "2022-09-01"}, args...)
+			}
+
+			result, err := cli.Run(defaultUserFinder(), reader, args)
+			require.NoError(t, err)
+
+			var generated struct {
+				Calls []json.RawMessage `json:"calls"`
+			}
+			require.NoError(t, json.Unmarshal(result, &generated))
+			assert.Len(t, generated.Calls, tt.calls)
+		})
+	}
+}
+
+func TestShouldFailOnInvalidInputFormat(t *testing.T) {
+	_, err := cli.Run(defaultUserFinder(), defaultReader(), []string{"--input-format", "xml", phone, "2020-01-01", "2022-09-01", filename})
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), `parsing arguments: invalid input format "xml", expected csv, json or ndjson`)
+}
+
+func TestChargesTaxesFromFile(t *testing.T) {
+	reader := readerWithFiles(map[string]string{
+		"taxes.yaml": `taxes:
+  - {name: IVA, percentage: 21}`,
+		filename: `numero origen,numero destino,duracion,fecha
++5491167950940,+191167980952,10,2020-11-10T04:02:45Z`,
+	})
+
+	result, err := cli.Run(defaultUserFinder(), reader, []string{"--taxes", "taxes.yaml", phone, "2020-01-01", "2022-09-01", filename})
+	require.NoError(t, err)
+
+	expectedInvoice := `{
+		"user": {
+			"address": "Calle Falsa 123",
+			"name": "Antonio Banderas",
+			"phone_number": "+5491167950940"
+		},
+		"billing_period": {"start": "2020-01-01T00:00:00Z", "end": "2022-09-02T00:00:00Z", "time_zone": "UTC"},
+		"currency": "ARS",
+		"calls": [
+			{
+				"phone_number": "+191167980952",
+				"duration": 10,
+				"billed_duration": 10,
+				"timestamp": "2020-11-10T04:02:45Z",
+				"amount": "10.00"
+			}
+		],
+		"total_international_seconds":10,
+		"total_national_seconds":0,
+		"total_friends_seconds":0,
+		"total_interplanetary_seconds":0,
+		"total_international_billed_seconds":10,
+		"total_national_billed_seconds":0,
+		"total_friends_billed_seconds":0,
+		"total_interplanetary_billed_seconds":0,
+		"items": [
+			{"type": "calls", "description": "international calls", "quantity": 1, "amount": "10.00"}
+		],
+		"subtotal":"10.00",
+		"taxes": [
+			{"name": "IVA", "base": "10.00", "percentage": "21", "amount": "2.10"}
+		],
+		"total":"12.10"
+	}`
+	assert.JSONEq(t, expectedInvoice, string(result))
+}
+
+func TestConsumesAllowancesOfPlansFromFile(t *testing.T) {
+	reader := readerWithFiles(map[string]string{
+		"plans.yaml": `plans:
+  internacional:
+    allowances: {international: 1}`,
+		filename: `numero origen,numero destino,duracion,fecha
++5491167950940,+191167980952,90,2020-11-10T04:02:45Z`,
+	})
+
+	userFinder := user.NewMockFinderForUser(user.User{
+		Name:    "Antonio Banderas",
+		Address: "Calle Falsa 123",
+		Phone:   phone,
+		Plan:    "internacional",
+	})
+
+	result, err := cli.Run(userFinder, reader, []string{"--plans", "plans.yaml", phone, "2020-01-01", "2022-09-01", filename})
+	require.NoError(t, err)
+
+	expectedInvoice := `{
+		"user": {
+			"address": "Calle Falsa 123",
+			"name": "Antonio Banderas",
+			"phone_number": "+5491167950940"
+		},
+		"billing_period": {"start": "2020-01-01T00:00:00Z", "end": "2022-09-02T00:00:00Z", "time_zone": "UTC"},
+		"currency": "ARS",
+		"calls": [
+			{
+				"phone_number": "+191167980952",
+				"duration": 90,
+				"billed_duration": 90,
+				"timestamp": "2020-11-10T04:02:45Z",
+				"amount": "30.00",
+				"promotions": ["allowance_international"]
+			}
+		],
+		"total_international_seconds":90,
+		"total_national_seconds":0,
+		"total_friends_seconds":0,
+		"total_interplanetary_seconds":0,
+		"total_international_billed_seconds":90,
+		"total_national_billed_seconds":0,
+		"total_friends_billed_seconds":0,
+		"total_interplanetary_billed_seconds":0,
+		"allowances": [
+			{"call_type": "international", "included_seconds": 60, "consumed_seconds": 60, "remaining_seconds": 0}
+		],
+		"items": [
+			{"type": "calls", "description": "international calls", "quantity": 1, "amount": "30.00"}
+		],
+		"subtotal":"30.00",
+		"total":"30.00"
+	}`
+	assert.JSONEq(t, expectedInvoice, string(result))
+}
+
+func TestExplainsHowACallWasRated(t *testing.T) {
+	reader := readerWithFiles(map[string]string{
+		"calls.csv": `numero origen,numero destino,duracion,fecha
++5491167950940,+191167980952,462,2020-11-10T04:02:45Z
++5491167950940,+541167980953,60,2020-05-10T04:45:25Z`,
+		"promotions.yaml": `discounts:
+  - name: international_discount
+    match: {call_types: [international]}
+    percentage: 10`,
+	})
+
+	userFinder := user.NewMockFinderForUser(user.User{
+		Phone:   phone,
+		Friends: []user.PhoneNumber{"+541167980953"},
+	})
+
+	args := []string{"--call", "2020-05-10T04:45:25Z", "--promotions", "promotions.yaml", phone, "2020-01-01", "2022-09-01", "calls.csv"}
+	result, err := cli.Explain(userFinder, reader, args)
+	require.NoError(t, err)
+
+	expected := `Call to +541167980953 at 2020-05-10T04:45:25Z
+  Duration: 60s, billed 60s
+  Types: friends, national
+  Characteristics: to_friend
+  Base cost: 2.50 ARS
+  Promotion free_calls_to_friends (exclusive): used
+    Cost with it: 0.00 ARS
+  Amount: 0.00 ARS
+`
+	assert.Equal(t, expected, result)
+
+	// Without --call all the calls are explained
+	result, err = cli.Explain(userFinder, reader, args[2:])
+	require.NoError(t, err)
+
+	expected = `Call to +191167980952 at 2020-11-10T04:02:45Z
+  Duration: 462s, billed 462s
+  Types: international
+  Base cost: 462.00 ARS
+  Promotion free_calls_to_friends (exclusive): doesn't apply
+  Promotion international_discount (stackable): used
+    Cost with it: 415.80 ARS
+  Amount: 415.80 ARS
+
+` + expected
+	assert.Equal(t, expected, result)
+}
+
+func TestExplainWithoutTheCallShouldFail(t *testing.T) {
+	reader := readerWithContent(`numero origen,numero destino,duracion,fecha
++5491167950940,+191167980952,462,2020-11-10T04:02:45Z`)
+
+	_, err := cli.Explain(defaultUserFinder(), reader, []string{"--call", "2020-11-10T04:02:46Z", phone, "2020-01-01", "2022-09-01", filename})
+	assert.EqualError(t, err, "no call at 2020-11-10T04:02:46Z in the invoice")
+}
+
+// TestRunStreamsCalls checks that the peak of the live heap doesn't grow with
+// the size of the calls file, since calls are read as they are rated. Keeping
+// the calls of a million rows would take hundreds of MB.
+func TestRunStreamsCalls(t *testing.T) {
+	if testing.Short() {
+		t.Skip("generates a million calls")
+	}
+
+	small := runGenerated(t, 10_000)
+	big := runGenerated(t, 1_000_000)
+
+	const tolerance = 1 << 20 // 1 MiB, for the noise of the runtime
+	assert.LessOrEqual(t, big, small+tolerance, "peak heap of 1M rows (%d B) vs 10k rows (%d B)", big, small)
+}
+
+// BenchmarkRunStreamsCalls generates invoices from calls files of increasing
+// size, most of them calls of other users, reporting the peak of the live heap.
+func BenchmarkRunStreamsCalls(b *testing.B) {
+	for _, rows := range []int{10_000, 100_000, 1_000_000} {
+		b.Run(fmt.Sprintf("%d rows", rows), func(b *testing.B) {
+			b.ReportAllocs()
+
+			var peak uint64
+			for n := 0; n < b.N; n++ {
+				if runPeak := runGenerated(b, rows); runPeak > peak {
+					peak = runPeak
+				}
+			}
+
+			b.ReportMetric(float64(peak), "peak-heap-B")
+		})
+	}
+}
+
+// runGenerated generates the invoice of a generated calls file with the rows,
+// and returns the peak of the live heap while it was read.
+func runGenerated(t testing.TB, rows int) uint64 {
+	file := &generatedCalls{rows: rows}
+	files := generatedFiles{
+		fakeFiles: readerWithContent("").(fakeFiles),
+		file:      file,
+	}
+
+	_, err := cli.Run(defaultUserFinder(), files, []string{phone, "2020-01-01", "2020-12-31", filename})
+	require.NoError(t, err)
+
+	return file.peakHeap
+}
+
+// generatedCalls is a calls file that is generated as it's read, so it's
+// never entirely in memory. The first 100 calls are of the user, and the rest
+// of other users. It samples the live heap while it's read.
+type generatedCalls struct {
+	rows     int
+	row      int
+	pending  []byte
+	peakHeap uint64
+}
+
+func (g *generatedCalls) Read(p []byte) (int, error) {
+	if len(g.pending) == 0 {
+		if g.row > g.rows {
+			return 0, io.EOF
+		}
+
+		g.pending = g.nextRow()
+		g.row++
+	}
+
+	n := copy(p, g.pending)
+	g.pending = g.pending[n:]
+	return n, nil
+}
+
+func (g *generatedCalls) nextRow() []byte {
+	if g.row%100_000 == 0 || g.row == g.rows {
+		var stats runtime.MemStats
+		runtime.GC()
+		runtime.ReadMemStats(&stats)
+		if stats.HeapAlloc > g.peakHeap {
+			g.peakHeap = stats.HeapAlloc
+		}
+	}
+
+	if g.row == 0 {
+		return []byte("numero origen,numero destino,duracion,fecha\n")
+	}
+
+	source := "+5491167910920"
+	if g.row <= 100 {
+		source = phone
+	}
+
+	return []byte(fmt.Sprintf("%s,+191167980952,%d,2020-11-10T04:%02d:%02dZ\n", source, g.row%600, g.row/60%60, g.row%60))
+}
+
+// generatedFiles is a filesystem where the calls file is generated.
+type generatedFiles struct {
+	fakeFiles
+	file *generatedCalls
+}
+
+func (f generatedFiles) Open(name string) (io.ReadCloser, error) {
+	return io.NopCloser(f.file), nil
+}
+
 func defaultUserFinder() user.Finder {
 	return user.NewMockFinderForUser(
 		user.User{
@@ -163,14 +1010,30 @@ func defaultUserFinder() user.Finder {
 	)
 }
 
-func defaultReader() cli.FileReader {
+func defaultReader() cli.FileSystem {
 	return readerWithContent(`numero origen,numero destino,duracion,fecha
 +5491167980950,+191167980952,462,2020-11-10T04:02:45Z
 +5491167910920,+191167980952,392,2020-08-09T04:45:25Z`)
 }
 
-func readerWithContent(content string) cli.FileReader {
-	return func(name string) ([]byte, error) {
-		return []byte(content), nil
+func readerWithContent(content string) cli.FileSystem {
+	return readerWithFiles(map[string]string{filename: content})
+}
+
+func readerWithFiles(files map[string]string) cli.FileSystem {
+	mapFS := make(fstest.MapFS)
+	for name, content := range files {
+		mapFS[name] = &fstest.MapFile{Data: []byte(content)}
 	}
+
+	return fakeFiles{MapFS: mapFS}
+}
+
+// fakeFiles is an in-memory filesystem, where - is the standard input.
+type fakeFiles struct {
+	fstest.MapFS
+}
+
+func (f fakeFiles) Open(name string) (io.ReadCloser, error) {
+	return f.MapFS.Open(name)
 }
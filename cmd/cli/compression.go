@@ -0,0 +1,83 @@
+package cli
+
+import (
+	"bufio"
+	"bytes"
+	"compress/bzip2"
+	"compress/gzip"
+	"io"
+
+	"github.com/klauspost/compress/zstd"
+)
+
+// Magic bytes each compression format starts with
+var (
+	gzipMagic  = []byte{0x1f, 0x8b}
+	zstdMagic  = []byte{0x28, 0xb5, 0x2f, 0xfd}
+	bzip2Magic = []byte("BZh") // followed by the block size, from 1 to 9
+)
+
+// decompressed returns the content of the file decompressed as it's read,
+// detecting its compression (gzip, zstd or bzip2) by its first bytes rather
+// than by its name, since stdin doesn't have one. Files that aren't compressed
+// are read as they are. Closing it closes the file.
+//
+// Nota de diseño: Antes los CDRs archivados se descomprimían a un directorio
+// temporal antes de correr el CLI. Descomprimir mientras se lee hace que los
+// archivos grandes nunca estén enteros descomprimidos, ni en disco ni en
+// memoria.
+func decompressed(file io.ReadCloser) (io.ReadCloser, error) {
+	buffered := bufio.NewReader(file)
+	magic, err := buffered.Peek(len(zstdMagic))
+	if err != nil && err != io.EOF {
+		return nil, err
+	}
+
+	switch {
+	case bytes.HasPrefix(magic, gzipMagic):
+		reader, err := gzip.NewReader(buffered)
+		if err != nil {
+			return nil, err
+		}
+
+		return readCloser{Reader: reader, close: []func() error{reader.Close, file.Close}}, nil
+	case bytes.HasPrefix(magic, zstdMagic):
+		// A single goroutine is enough, since rows are read one by one
+		reader, err := zstd.NewReader(buffered, zstd.WithDecoderConcurrency(1))
+		if err != nil {
+			return nil, err
+		}
+
+		return readCloser{Reader: reader, close: []func() error{closeZstd(reader), file.Close}}, nil
+	case bytes.HasPrefix(magic, bzip2Magic) && len(magic) > 3 && magic[3] >= '1' && magic[3] <= '9':
+		return readCloser{Reader: bzip2.NewReader(buffered), close: []func() error{file.Close}}, nil
+	}
+
+	return readCloser{Reader: buffered, close: []func() error{file.Close}}, nil
+}
+
+// readCloser is a reader that closes several things when closed, in order.
+type readCloser struct {
+	io.Reader
+	close []func() error
+}
+
+// Close closes everything, returning the first error.
+func (r readCloser) Close() error {
+	var first error
+	for _, close := range r.close {
+		if err := close(); err != nil && first == nil {
+			first = err
+		}
+	}
+
+	return first
+}
+
+// closeZstd returns a function that closes the decoder, which can't fail.
+func closeZstd(decoder *zstd.Decoder) func() error {
+	return func() error {
+		decoder.Close()
+		return nil
+	}
+}
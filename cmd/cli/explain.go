@@ -0,0 +1,109 @@
+package cli
+
+import (
+	"fmt"
+	"invoice-generator/pkg/invoice"
+	"invoice-generator/pkg/user"
+	"strings"
+)
+
+const explainUsage = "./invoice-generator explain [--call <timestamp>] [--tariff <tariff_file> | --tariff-history <tariff_history_file>] [--rates <rates_file>] [--taxes <taxes_file>] [--promotions <promotions_file>] [--plans <plans_file>] [--charges <charges_file>] [--holidays <holidays_file>] [--columns <columns_file>] [--input-format <csv|json|ndjson>] [--time-zone <time_zone>] [--cycle <AAAA-MM> [--cycle-day <day>]] <telephone> [<billing_start> <billing_end>] <calls_file>..."
+
+// Explain generates the invoice like Run, but returns an explanation of how
+// each call was rated instead. With --call, only the calls made at that
+// timestamp (as shown on the invoice) are explained.
+//
+// Nota de diseño: Sirve para cuando un cliente reclama un cargo, así se puede
+// ver qué tipo se detectó, qué promociones se evaluaron y por qué costó eso.
+func Explain(userFinder user.Finder, files FileSystem, rawArgs []string) (string, error) {
+	args, err := parseArgs(rawArgs, true)
+	if err != nil {
+		return "", fmt.Errorf("parsing arguments: %s. Usage:\n\t%s", err, explainUsage)
+	}
+
+	generated, err := generate(userFinder, files, args, true)
+	if err != nil {
+		return "", err
+	}
+
+	var explanation strings.Builder
+	explained := 0
+	for _, invoiceCall := range generated.Calls {
+		if args.callTimestamp != "" && invoiceCall.Timestamp != args.callTimestamp {
+			continue
+		}
+
+		if explained > 0 {
+			explanation.WriteString("\n")
+		}
+
+		explainCall(&explanation, invoiceCall)
+		explained++
+	}
+
+	if args.callTimestamp != "" && explained == 0 {
+		return "", fmt.Errorf("no call at %s in the invoice", args.callTimestamp)
+	}
+
+	return explanation.String(), nil
+}
+
+// explainCall writes the trace of the call.
+func explainCall(w *strings.Builder, invoiceCall invoice.InvoiceCall) {
+	trace := invoiceCall.Trace
+
+	fmt.Fprintf(w, "Call to %s at %s\n", invoiceCall.DestinationPhone, invoiceCall.Timestamp)
+	fmt.Fprintf(w, "  Duration: %ds, billed %ds\n", invoiceCall.Duration, invoiceCall.BilledDuration)
+	fmt.Fprintf(w, "  Types: %s\n", strings.Join(trace.Types, ", "))
+	if len(trace.Characteristics) > 0 {
+		fmt.Fprintf(w, "  Characteristics: %s\n", strings.Join(trace.Characteristics, ", "))
+	}
+
+	if trace.TariffEffectiveFrom != "" {
+		fmt.Fprintf(w, "  Tariff effective from %s\n", trace.TariffEffectiveFrom)
+	}
+
+	if invoiceCall.Holiday != "" {
+		fmt.Fprintf(w, "  Holiday: %s\n", invoiceCall.Holiday)
+	}
+
+	if invoiceCall.Band != "" {
+		fmt.Fprintf(w, "  Time band: %s\n", invoiceCall.Band)
+	}
+
+	if invoiceCall.DefaultRate {
+		fmt.Fprintf(w, "  Destination not in the rate table, priced with the default rate\n")
+	}
+
+	fmt.Fprintf(w, "  Base cost: %s\n", trace.BaseCost)
+	for _, allowance := range trace.Allowances {
+		fmt.Fprintf(w, "  Allowance %s: covered %ds\n", allowance.Name, allowance.Covered)
+	}
+
+	if trace.Overage != nil {
+		fmt.Fprintf(w, "  Overage: %s\n", trace.Overage)
+	}
+
+	for _, promo := range trace.Promotions {
+		fmt.Fprintf(w, "  Promotion %s (%s): %s\n", promo.Name, promo.Policy, promotionOutcome(promo.Applies, promo.Used))
+		if promo.Amount != nil {
+			fmt.Fprintf(w, "    Cost with it: %s\n", promo.Amount)
+		}
+	}
+
+	fmt.Fprintf(w, "  Amount: %s\n", trace.Amount)
+	if conversion := invoiceCall.Conversion; conversion != nil {
+		fmt.Fprintf(w, "  Converted: %s (rate %s since %s)\n", invoiceCall.Amount, conversion.Rate, conversion.RateDate)
+	}
+}
+
+func promotionOutcome(applies, used bool) string {
+	switch {
+	case used:
+		return "used"
+	case applies:
+		return "applies, not used"
+	default:
+		return "doesn't apply"
+	}
+}
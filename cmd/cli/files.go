@@ -0,0 +1,312 @@
+package cli
+
+import (
+	"errors"
+	"fmt"
+	"invoice-generator/pkg/invoice/call"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// stdinPath is the path of the calls read from the standard input.
+const stdinPath = "-"
+
+// Formats of the calls files
+const (
+	formatCSV    = "csv"
+	formatJSON   = "json"   // An array of objects
+	formatNDJSON = "ndjson" // An object on each line
+)
+
+// inputFormats are the formats of the calls files, by name.
+var inputFormats = map[string]func(io.Reader, call.Columns) call.Source{
+	formatCSV:    call.FromCSV,
+	formatJSON:   call.FromJSON,
+	formatNDJSON: call.FromNDJSON,
+}
+
+// formatExtensions are the formats of the extensions of calls files. Files
+// with other extensions (and stdin) are csv.
+var formatExtensions = map[string]string{
+	".json":   formatJSON,
+	".ndjson": formatNDJSON,
+	".jsonl":  formatNDJSON,
+}
+
+// compressionExtensions are ignored to find the format of a file by its
+// extension. Compression itself is detected by content (see decompressed).
+var compressionExtensions = map[string]bool{".gz": true, ".zst": true, ".bz2": true}
+
+// validateInputFormat returns an error if the format isn't known. An empty
+// format is detected by the extension of each file.
+func validateInputFormat(format string) error {
+	if _, ok := inputFormats[format]; format != "" && !ok {
+		return fmt.Errorf("invalid input format %q, expected %s, %s or %s", format, formatCSV, formatJSON, formatNDJSON)
+	}
+
+	return nil
+}
+
+// formatOf returns the format of the file by its extension (e.g. calls.json or
+// calls.json.gz are json), unless a format was specified.
+func formatOf(name, format string) string {
+	if format != "" {
+		return format
+	}
+
+	ext := strings.ToLower(filepath.Ext(name))
+	if compressionExtensions[ext] {
+		ext = strings.ToLower(filepath.Ext(strings.TrimSuffix(name, filepath.Ext(name))))
+	}
+
+	if format, ok := formatExtensions[ext]; ok {
+		return format
+	}
+
+	return formatCSV
+}
+
+// FileSystem reads files. Used to mock the filesystem in tests.
+type FileSystem interface {
+	// ReadFile reads the whole content of a file, like configuration files.
+	ReadFile(name string) ([]byte, error)
+
+	// Open opens a file to read it as a stream, like the calls files, which
+	// can be too big to fit in memory. The name - is the standard input.
+	Open(name string) (io.ReadCloser, error)
+
+	// Stat returns the information of a file, like whether it's a directory.
+	Stat(name string) (fs.FileInfo, error)
+
+	// ReadDir returns the entries of a directory, sorted by name.
+	ReadDir(name string) ([]fs.DirEntry, error)
+
+	// Glob returns the names of the files that match the pattern, with the
+	// syntax of filepath.Match.
+	Glob(pattern string) ([]string, error)
+}
+
+// OSFileSystem is the FileSystem of the operating system.
+type OSFileSystem struct{}
+
+// Verify interface compliance
+var _ FileSystem = OSFileSystem{}
+
+// ReadFile implements FileSystem.
+func (OSFileSystem) ReadFile(name string) ([]byte, error) {
+	return os.ReadFile(name)
+}
+
+// Open implements FileSystem.
+func (OSFileSystem) Open(name string) (io.ReadCloser, error) {
+	if name == stdinPath {
+		return io.NopCloser(os.Stdin), nil // it's not ours to close
+	}
+
+	return os.Open(name)
+}
+
+// Stat implements FileSystem.
+func (OSFileSystem) Stat(name string) (fs.FileInfo, error) {
+	return os.Stat(name)
+}
+
+// ReadDir implements FileSystem.
+func (OSFileSystem) ReadDir(name string) ([]fs.DirEntry, error) {
+	return os.ReadDir(name)
+}
+
+// Glob implements FileSystem.
+func (OSFileSystem) Glob(pattern string) ([]string, error) {
+	return filepath.Glob(pattern)
+}
+
+// expandCallsPaths returns the calls files of the paths, in order. A path can
+// be a file, - for the standard input, a glob pattern (the matching files, by
+// name) or a directory (its files by name, without hidden ones or
+// subdirectories). Paths that exist are never patterns, so files with [ or *
+// in their name (e.g. calls[1].csv) can be read.
+func expandCallsPaths(files FileSystem, paths []string) ([]string, error) {
+	var expanded []string
+	readStdin := false
+	for _, p := range paths {
+		if p == stdinPath {
+			if readStdin {
+				return nil, errors.New("the standard input can only be read once")
+			}
+
+			readStdin = true
+			expanded = append(expanded, p)
+			continue
+		}
+
+		matches := []string{p}
+		if isGlob(p) && !exists(files, p) {
+			var err error
+			matches, err = files.Glob(p)
+			if err != nil {
+				return nil, fmt.Errorf("invalid pattern %q: %s", p, err)
+			}
+
+			if len(matches) == 0 {
+				return nil, fmt.Errorf("no calls files match %q", p)
+			}
+		}
+
+		for _, match := range matches {
+			matchFiles, err := filesOf(files, match)
+			if err != nil {
+				return nil, err
+			}
+
+			expanded = append(expanded, matchFiles...)
+		}
+	}
+
+	return expanded, nil
+}
+
+// filesOf returns the path if it's a file, or its files if it's a directory.
+func filesOf(files FileSystem, name string) ([]string, error) {
+	info, err := files.Stat(name)
+	if err != nil {
+		return nil, fmt.Errorf("invalid calls path: %s", err)
+	}
+
+	if !info.IsDir() {
+		return []string{name}, nil
+	}
+
+	entries, err := files.ReadDir(name)
+	if err != nil {
+		return nil, fmt.Errorf("invalid calls path: %s", err)
+	}
+
+	var dirFiles []string
+	for _, entry := range entries {
+		if entry.IsDir() || strings.HasPrefix(entry.Name(), ".") {
+			continue
+		}
+
+		dirFiles = append(dirFiles, filepath.Join(name, entry.Name()))
+	}
+
+	if len(dirFiles) == 0 {
+		return nil, fmt.Errorf("no calls files in directory %q", name)
+	}
+
+	return dirFiles, nil
+}
+
+func isGlob(p string) bool {
+	return strings.ContainsAny(p, "*?[")
+}
+
+// exists returns whether there is a file or directory with the name. Errors
+// other than it not existing are left to the reading of the file.
+func exists(files FileSystem, name string) bool {
+	_, err := files.Stat(name)
+	return !errors.Is(err, fs.ErrNotExist)
+}
+
+// callFiles is a source of the calls of several files, read one after the
+// other as a single stream. Only one file is open at a time, compressed files
+// are decompressed as they are read, and errors say which file they come
+// from. Each file is read in its own format (see formatOf).
+type callFiles struct {
+	files   FileSystem
+	names   []string // Files that weren't read yet
+	columns call.Columns
+	format  string // Of all the files, empty means by extension
+
+	name    string // Of the file being read
+	current io.ReadCloser
+	calls   call.Source
+
+	err error // That stopped the reading, if any
+}
+
+// Verify interface compliance
+var _ call.Source = &callFiles{}
+
+func newCallFiles(files FileSystem, names []string, cols call.Columns, format string) *callFiles {
+	return &callFiles{files: files, names: names, columns: cols, format: format}
+}
+
+// Next implements call.Source.
+func (s *callFiles) Next() (call.Call, error) {
+	aCall, err := s.next()
+	if err != nil && err != io.EOF {
+		s.err = err
+	}
+
+	return aCall, err
+}
+
+func (s *callFiles) next() (call.Call, error) {
+	for {
+		if s.current == nil {
+			if len(s.names) == 0 {
+				return call.Call{}, io.EOF
+			}
+
+			s.name, s.names = s.names[0], s.names[1:]
+			file, err := s.files.Open(s.name)
+			if err != nil {
+				return call.Call{}, fmt.Errorf("%s: %s", s.displayName(), err)
+			}
+
+			content, err := decompressed(file)
+			if err != nil {
+				file.Close()
+				return call.Call{}, fmt.Errorf("%s: %s", s.displayName(), err)
+			}
+
+			s.current = content
+			s.calls = inputFormats[formatOf(s.name, s.format)](content, s.columns)
+		}
+
+		aCall, err := s.calls.Next()
+		if err == io.EOF {
+			// Continue with the next file
+			if err := s.Close(); err != nil {
+				return call.Call{}, err
+			}
+
+			continue
+		}
+
+		if err != nil {
+			return call.Call{}, fmt.Errorf("%s: %s", s.displayName(), err)
+		}
+
+		return aCall, nil
+	}
+}
+
+// Close closes the file being read, if any.
+func (s *callFiles) Close() error {
+	if s.current == nil {
+		return nil
+	}
+
+	err := s.current.Close()
+	s.current, s.calls = nil, nil
+	if err != nil {
+		return fmt.Errorf("%s: %s", s.displayName(), err)
+	}
+
+	return nil
+}
+
+// displayName returns the name of the file being read for errors.
+func (s *callFiles) displayName() string {
+	if s.name == stdinPath {
+		return "stdin"
+	}
+
+	return s.name
+}
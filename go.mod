@@ -2,10 +2,13 @@ module invoice-generator
 
 go 1.18
 
-require github.com/stretchr/testify v1.8.1
+require (
+	github.com/klauspost/compress v1.17.2
+	github.com/stretchr/testify v1.8.1
+	gopkg.in/yaml.v3 v3.0.1
+)
 
 require (
 	github.com/davecgh/go-spew v1.1.1 // indirect
 	github.com/pmezard/go-difflib v1.0.0 // indirect
-	gopkg.in/yaml.v3 v3.0.1 // indirect
 )
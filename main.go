@@ -7,13 +7,25 @@ import (
 	"log"
 	"net/http"
 	"os"
+	_ "time/tzdata" // time zones of tariffs shouldn't depend on the system
 )
 
 // -----------
 
 func main() {
 	finder := user.NewFinder(http.DefaultClient)
-	inv, err := cli.Run(finder, os.ReadFile, os.Args[1:])
+
+	if len(os.Args) > 1 && os.Args[1] == "explain" {
+		explanation, err := cli.Explain(finder, cli.OSFileSystem{}, os.Args[2:])
+		if err != nil {
+			log.Fatal(err.Error())
+		}
+
+		fmt.Print(explanation)
+		return
+	}
+
+	inv, err := cli.Run(finder, cli.OSFileSystem{}, os.Args[1:])
 	if err != nil {
 		log.Fatal(err.Error())
 	}
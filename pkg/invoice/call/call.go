@@ -4,8 +4,10 @@ package call
 
 import (
 	"fmt"
+	"invoice-generator/pkg/invoice/tariff"
+	"invoice-generator/pkg/platform/money"
+	"invoice-generator/pkg/platform/phone"
 	"invoice-generator/pkg/user"
-	"regexp"
 	"time"
 )
 
@@ -14,6 +16,20 @@ type Call struct {
 	SourcePhone      string
 	Duration         uint // Seconds
 	Date             time.Time
+
+	// Holiday is the name of the holiday the call was made on, in the country
+	// and time zone of the user, or empty if it wasn't. The processor sets it
+	// when it has a holiday calendar (see Processor.UseHolidays).
+	Holiday string
+
+	// AfterHoliday is whether the day before the call, in the time zone of the
+	// user, was a holiday. The processor sets it along with Holiday.
+	AfterHoliday bool
+
+	// BilledDuration is the duration of the call that is charged according to
+	// the billing increments of its tariff (in seconds). The processor sets it
+	// before applying promotions.
+	BilledDuration uint
 }
 
 func New(destPhone string, sourcePhone string, duration uint, date time.Time) (Call, error) {
@@ -33,34 +49,24 @@ func New(destPhone string, sourcePhone string, duration uint, date time.Time) (C
 	}, nil
 }
 
-// Phone number format: +549XXXXXXXXXX
-// Nota de diseño: asumo que son válidos de 12 a 13 porque así están en los
-// datos de ejemplo provistos.
-var phoneNumberFormat = regexp.MustCompile(`\+[0-9]{12,13}`)
-
+// ValidatePhoneNumber validates that the phone number is in E.164 format
+// (e.g. +5491111111111)
 func ValidatePhoneNumber(phoneNumber string) error {
-	if !phoneNumberFormat.MatchString(phoneNumber) {
-		return fmt.Errorf("invalid format, should match %s", phoneNumberFormat.String())
-	}
-
-	return nil
+	_, err := phone.Parse(phoneNumber)
+	return err
 }
 
-// Type returns the type of the call
-func (c Call) Type(friends []user.PhoneNumber) Type {
-	if c.isFriend(friends) {
-		return FriendCall{subtype: c.baseType()}
-	}
-
-	return c.baseType()
+// Type returns the type of the call with the built-in types (see
+// DefaultRegistry). The tariff declares which destinations are interplanetary.
+func (c Call) Type(friends []user.PhoneNumber, t tariff.Tariff) Type {
+	return defaultRegistry.Classify(c, friends, t)
 }
 
-func (c Call) baseType() Type {
-	if c.isNational() {
-		return NationalCall{}
-	}
-
-	return InternationalCall{durationSecs: c.Duration}
+// Holidays returns whether the call was made on a holiday and whether the day
+// before it was one, as resolved by the processor, to match it against time
+// windows in any time zone.
+func (c Call) Holidays() tariff.Holidays {
+	return tariff.Holidays{Today: c.Holiday != "", Yesterday: c.AfterHoliday}
 }
 
 // isFriend returns whether this call was made to a friend
@@ -77,18 +83,15 @@ func (c Call) isFriend(friends []user.PhoneNumber) bool {
 // isNational returns whether the call was made to the same country (by
 // comparing source and destination country codes)
 func (c Call) isNational() bool {
-	sourceCountry := getCountryCode(c.SourcePhone)
-	destinationCountry := getCountryCode(c.DestinationPhone)
-
-	return sourceCountry == destinationCountry
-}
+	source, sourceErr := phone.Parse(c.SourcePhone)
+	destination, destinationErr := phone.Parse(c.DestinationPhone)
+	if sourceErr != nil || destinationErr != nil {
+		// Calls are validated when created, so this shouldn't happen. If it
+		// does, we can't tell it's national.
+		return false
+	}
 
-// destinationCountryCode assumes that the phone number has a valid format,
-//	+ (2 digit country) (11 digit number)
-// For example,
-//	+549XXXXXXXXXX -> 54
-func getCountryCode(phoneNumber string) string {
-	return phoneNumber[1:3]
+	return source.CountryCode == destination.CountryCode
 }
 
 // A Characteristic of a call, orthogonal to their type
@@ -98,48 +101,203 @@ const (
 	CharacteristicToFriend Characteristic = iota + 1
 )
 
+// characteristics are all the characteristics, in order
+var characteristics = []Characteristic{CharacteristicToFriend}
+
+func (c Characteristic) String() string {
+	switch c {
+	case CharacteristicToFriend:
+		return "to_friend"
+	default:
+		return "unknown"
+	}
+}
+
+// Cost is the cost of a call, along with details of how it was rated.
+type Cost struct {
+	Amount money.Money
+
+	// Type is the name of the type of the call in the tariff (e.g. national)
+	Type string
+
+	// BilledDuration is the duration of the call that was charged, according
+	// to the billing increments of its tariff (in seconds)
+	BilledDuration uint
+
+	// DefaultRate is true when the destination of the call wasn't in the
+	// tariff rate table, so it was priced with the default rate.
+	DefaultRate bool
+
+	// Band is the name of the time band the call was rated in, empty if the
+	// tariff has none.
+	Band string
+
+	// Holiday is the name of the holiday the call was made on, if any
+	Holiday string
+
+	// Promotions are the names of the promotions that contributed to the
+	// amount, in the order they were applied.
+	Promotions []string
+
+	// Trace explains how the call was rated, nil unless tracing is enabled
+	Trace *Trace
+}
+
+// rate builds the cost of a call with the pricing of its type, applying the
+// time band the call was made in. This is where the amount is rounded to the
+// currency of the pricing.
+func rate(t tariff.Tariff, callType string, pricing tariff.Pricing, durationSecs uint, date time.Time, holidays tariff.Holidays) (Cost, error) {
+	amount, billedSecs, err := pricing.Cost(durationSecs)
+	if err != nil {
+		return Cost{}, err
+	}
+
+	cost := Cost{Type: callType, BilledDuration: billedSecs}
+
+	if band, ok := t.TimeBands.At(date, holidays); ok {
+		amount, err = band.Apply(callType, amount)
+		if err != nil {
+			return Cost{}, fmt.Errorf("time band %s: %s", band.Name, err)
+		}
+
+		cost.Band = band.Name
+	}
+
+	cost.Amount = money.FromDecimal(amount, t.CurrencyOf(pricing))
+	return cost, nil
+}
+
 type Type interface {
-	BaseCost() float64
+	// Name of the type in tariffs (e.g. national). Characteristics like calls
+	// to friends have the name of their base type.
+	Name() string
+
+	// BaseCost returns the cost of the call according to the tariff, without
+	// any promotions. It returns an error if the cost is out of range.
+	BaseCost(tariff.Tariff) (Cost, error)
 	RegisterDuration(uint, DurationRegisterer)
 	HasCharacteristic(Characteristic) bool
 }
 
-// A DurationRegisterer knows how to register durations of different types of
-// calls.
+// A DurationRegisterer registers durations of calls by the ID of their type
+// (see Registry). The duration can be either the real one or the billed one,
+// depending on what is being totalized.
 //
 // Nota de diseño: Esta interfaz rara tuve que hacerla para evitar pasar un
 // puntero a struct al CallType para que lo modifiquen, me parece que quedó un
 // poco más limpio.
 type DurationRegisterer interface {
-	RegisterFriendCall(uint)
-	RegisterNationalCall(uint)
-	RegisterInternationalCall(uint)
+	Register(typeID string, duration uint)
 }
 
 type InternationalCall struct {
-	durationSecs uint
+	durationSecs     uint
+	date             time.Time
+	holidays         tariff.Holidays
+	destinationPhone string
 }
 
-func (c InternationalCall) BaseCost() float64 {
-	return float64(c.durationSecs)
+// BaseCost of international calls depends on the rate of their destination and
+// the time band they were made in.
+func (c InternationalCall) BaseCost(t tariff.Tariff) (Cost, error) {
+	pricing, isDefault := t.International.Rate(c.destinationPhone)
+
+	cost, err := rate(t, tariff.TypeInternational, pricing, c.durationSecs, c.date, c.holidays)
+	if err != nil {
+		return Cost{}, err
+	}
+
+	cost.DefaultRate = isDefault
+	return cost, nil
 }
 
+func (c InternationalCall) Name() string { return tariff.TypeInternational }
+
 func (c InternationalCall) HasCharacteristic(_ Characteristic) bool { return false }
 
 func (c InternationalCall) RegisterDuration(duration uint, registerer DurationRegisterer) {
-	registerer.RegisterInternationalCall(duration)
+	registerer.Register(tariff.TypeInternational, duration)
 }
 
-type NationalCall struct{}
+type NationalCall struct {
+	durationSecs uint
+	date         time.Time
+	holidays     tariff.Holidays
+}
 
-func (c NationalCall) BaseCost() float64 {
-	return 2.5
+// BaseCost of national calls depends on the time band they were made in.
+func (c NationalCall) BaseCost(t tariff.Tariff) (Cost, error) {
+	return rate(t, tariff.TypeNational, t.National, c.durationSecs, c.date, c.holidays)
 }
 
+func (c NationalCall) Name() string { return tariff.TypeNational }
+
 func (c NationalCall) HasCharacteristic(_ Characteristic) bool { return false }
 
 func (c NationalCall) RegisterDuration(duration uint, registerer DurationRegisterer) {
-	registerer.RegisterNationalCall(duration)
+	registerer.Register(tariff.TypeNational, duration)
+}
+
+type InterplanetaryCall struct {
+	durationSecs uint
+	date         time.Time
+	holidays     tariff.Holidays
+}
+
+func (c InterplanetaryCall) Name() string { return tariff.TypeInterplanetary }
+
+// BaseCost of interplanetary calls depends on the time band they were made in.
+func (c InterplanetaryCall) BaseCost(t tariff.Tariff) (Cost, error) {
+	return rate(t, tariff.TypeInterplanetary, t.Interplanetary.Pricing, c.durationSecs, c.date, c.holidays)
+}
+
+func (c InterplanetaryCall) HasCharacteristic(_ Characteristic) bool { return false }
+
+func (c InterplanetaryCall) RegisterDuration(duration uint, registerer DurationRegisterer) {
+	registerer.Register(tariff.TypeInterplanetary, duration)
+}
+
+// PricedCall is a call of a type that isn't built-in, priced with the pricing
+// of its key in the tariff (see tariff.Tariff.PricingOf) and the time band it
+// was made in. Classifiers of new types can return it rather than implementing
+// Type.
+type PricedCall struct {
+	name         string
+	pricingKey   string
+	durationSecs uint
+	date         time.Time
+	holidays     tariff.Holidays
+}
+
+// NewPricedCall returns the call as a call of the type with the name, priced
+// with the pricing of the key.
+func NewPricedCall(c Call, name, pricingKey string) PricedCall {
+	return PricedCall{
+		name:         name,
+		pricingKey:   pricingKey,
+		durationSecs: c.Duration,
+		date:         c.Date,
+		holidays:     c.Holidays(),
+	}
+}
+
+func (c PricedCall) Name() string { return c.name }
+
+// BaseCost of priced calls depends on the pricing of their key in the tariff,
+// which must have it.
+func (c PricedCall) BaseCost(t tariff.Tariff) (Cost, error) {
+	pricing, ok := t.PricingOf(c.pricingKey)
+	if !ok {
+		return Cost{}, fmt.Errorf("the tariff has no %s pricing for %s calls", c.pricingKey, c.name)
+	}
+
+	return rate(t, c.name, pricing, c.durationSecs, c.date, c.holidays)
+}
+
+func (c PricedCall) HasCharacteristic(_ Characteristic) bool { return false }
+
+func (c PricedCall) RegisterDuration(duration uint, registerer DurationRegisterer) {
+	registerer.Register(c.name, duration)
 }
 
 // FriendCall is a call characteristic
@@ -147,8 +305,10 @@ type FriendCall struct {
 	subtype Type
 }
 
-func (c FriendCall) BaseCost() float64 {
-	return c.subtype.BaseCost()
+func (c FriendCall) Name() string { return c.subtype.Name() }
+
+func (c FriendCall) BaseCost(t tariff.Tariff) (Cost, error) {
+	return c.subtype.BaseCost(t)
 }
 
 func (c FriendCall) HasCharacteristic(characteristic Characteristic) bool {
@@ -156,7 +316,7 @@ func (c FriendCall) HasCharacteristic(characteristic Characteristic) bool {
 }
 
 func (c FriendCall) RegisterDuration(duration uint, registerer DurationRegisterer) {
-	registerer.RegisterFriendCall(duration)
+	registerer.Register(TypeFriends, duration)
 
 	// Friend call register durations for friend and their base type
 	c.subtype.RegisterDuration(duration, registerer)
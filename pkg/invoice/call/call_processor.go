@@ -1,59 +1,129 @@
 package call
 
 import (
+	"fmt"
+	"invoice-generator/pkg/invoice/holiday"
+	"invoice-generator/pkg/invoice/tariff"
+	"invoice-generator/pkg/platform/money"
+	"invoice-generator/pkg/platform/phone"
 	"invoice-generator/pkg/platform/timeutil"
 	"invoice-generator/pkg/user"
+	"time"
 )
 
 // A Processor processes calls for a user one by one, returning their cost
-// with any suitable promotions applied. It also summarizes their durations and
-// total amount for statistical purposes.
+// with any suitable promotions applied. It also summarizes their durations (both
+// real and billed) for statistical purposes.
 type Processor struct {
 	usr           user.User
 	billingPeriod timeutil.Period
+	tariffs       tariff.History
 	promotions    []Promotion
+	allowances    []*Allowance
+	types         *Registry
+	tracing       bool
 
-	totalDurations TotalCallDurations
-	totalAmount    float64
+	// Holidays of the country of the user, resolved in their time zone
+	holidays        holiday.Calendar
+	holidayCountry  string
+	holidayLocation *time.Location
+
+	totalDurations  TotalCallDurations
+	billedDurations TotalCallDurations
 }
 
+// TotalCallDurations are the total seconds of each type of call, keyed by the
+// ID of the type (see Registry). It's a DurationRegisterer, so that calls
+// register their durations in it.
 type TotalCallDurations struct {
-	TotalInternationalSeconds uint
-	TotalNationalSeconds      uint
-	TotalFriendsSeconds       uint
+	ids     []string
+	seconds map[string]uint
 }
 
-// NewProcessor constructs a call processor.
-func NewProcessor(usr user.User, period timeutil.Period, promotions []Promotion) Processor {
+// Verify interface compliance
+var _ DurationRegisterer = &TotalCallDurations{}
+
+// NewTotalCallDurations returns totals of zero seconds for the types.
+func NewTotalCallDurations(ids ...string) TotalCallDurations {
+	totals := TotalCallDurations{seconds: make(map[string]uint)}
+	for _, id := range ids {
+		totals.Register(id, 0)
+	}
+
+	return totals
+}
+
+// NewProcessor constructs a call processor that prices calls according to the
+// version of the tariff effective when they were made, classifying them with
+// the registry of types. Calls made before the first version of the tariff are
+// rated with it, so the history should cover the billing period (see
+// tariff.History.Covers).
+func NewProcessor(usr user.User, period timeutil.Period, tariffs tariff.History, promotions []Promotion, types *Registry) Processor {
 	return Processor{
-		totalDurations: TotalCallDurations{},
-		totalAmount:    0,
+		totalDurations:  NewTotalCallDurations(types.IDs()...),
+		billedDurations: NewTotalCallDurations(types.IDs()...),
 
 		usr:           usr,
 		billingPeriod: period,
+		tariffs:       tariffs,
 		promotions:    promotions,
+		types:         types,
+	}
+}
+
+// EnableTracing makes the processor explain how each call was rated in the
+// Trace of its cost.
+func (c *Processor) EnableTracing() {
+	c.tracing = true
+}
+
+// UseAllowances makes the processor consume the allowances (e.g. the ones of
+// the plan of the user) in order before evaluating promotions, which only apply
+// to the cost of the billed seconds they don't cover.
+func (c *Processor) UseAllowances(allowances []*Allowance) {
+	c.allowances = allowances
+}
+
+// UseHolidays makes the processor rate calls made on holidays of the country
+// of the user (by their phone number) as holidays. Whether a call was made on a
+// holiday depends on its date in the location of the user.
+func (c *Processor) UseHolidays(calendar holiday.Calendar, location *time.Location) {
+	c.holidays = calendar
+	c.holidayLocation = location
+
+	// The phone of the user was validated before processing their calls
+	if number, err := phone.Parse(string(c.usr.Phone)); err == nil {
+		c.holidayCountry = number.Region()
 	}
 }
 
-// Summarize returns the total amount and summarized durations of the calls.
-func (c *Processor) Summarize() (float64, TotalCallDurations) {
-	return c.totalAmount, c.totalDurations
+// Summarize returns the summarized durations of the calls, both real and billed.
+func (c *Processor) Summarize() (real TotalCallDurations, billed TotalCallDurations) {
+	return c.totalDurations, c.billedDurations
 }
 
 // Process a call and return its cost. A call is skipped if it doesn't belong to
 // the user we're processing or if it was made outside of the billing period.
-func (c *Processor) Process(call Call) (cost float64, skip bool) {
+// It returns an error if the cost of the call is out of range.
+func (c *Processor) Process(call Call) (cost Cost, skip bool, err error) {
 	if c.shouldSkipCall(call) {
-		return 0, true
+		return Cost{}, true, nil
 	}
 
-	callType := call.Type(c.usr.Friends)
+	call.Holiday, call.AfterHoliday = c.holidaysOf(call)
+	version := c.tariffAt(call)
+	callType := c.types.Classify(call, c.usr.Friends, version.Tariff)
+	callCost, err := c.callCost(call, callType, version)
+	if err != nil {
+		return Cost{}, false, err
+	}
+
+	callCost.Holiday = call.Holiday
 
-	callType.RegisterDuration(call.Duration, c)
+	callType.RegisterDuration(call.Duration, &c.totalDurations)
+	callType.RegisterDuration(callCost.BilledDuration, &c.billedDurations)
 
-	callCost := c.callCost(call, callType)
-	c.totalAmount += callCost
-	return callCost, false
+	return callCost, false, nil
 }
 
 func (c *Processor) shouldSkipCall(call Call) bool {
@@ -63,26 +133,182 @@ func (c *Processor) shouldSkipCall(call Call) bool {
 	return isOutsideBillingPeriod || madeByOtherUser
 }
 
-func (c *Processor) callCost(call Call, callType Type) float64 {
-	for _, promo := range c.promotions {
-		if promo.AppliesTo(call) {
-			return promo.Apply(call)
+// holidaysOf returns the name of the holiday the call was made on, if any, and
+// whether the day before it was a holiday, both in the location of the user.
+// Without holidays, the ones the call already has are kept.
+func (c *Processor) holidaysOf(call Call) (name string, afterHoliday bool) {
+	if c.holidayLocation == nil {
+		return call.Holiday, call.AfterHoliday
+	}
+
+	local := call.Date.In(c.holidayLocation)
+	name, _ = c.holidays.On(c.holidayCountry, local)
+	_, afterHoliday = c.holidays.On(c.holidayCountry, local.AddDate(0, 0, -1))
+
+	return name, afterHoliday
+}
+
+// tariffAt returns the version of the tariff effective when the call was made.
+func (c *Processor) tariffAt(call Call) tariff.Version {
+	if version, ok := c.tariffs.At(call.Date); ok {
+		return version
+	}
+
+	versions := c.tariffs.Versions()
+	if len(versions) == 0 {
+		return tariff.Version{}
+	}
+
+	return versions[0]
+}
+
+func (c *Processor) callCost(call Call, callType Type, version tariff.Version) (Cost, error) {
+	cost, err := callType.BaseCost(version.Tariff)
+	if err != nil {
+		return Cost{}, err
+	}
+
+	call.BilledDuration = cost.BilledDuration
+
+	var trace *Trace
+	if c.tracing {
+		trace = newTrace(callType, cost.Amount, version)
+	}
+
+	overage, allowances := c.consumeAllowances(call, callType, cost.Amount, trace)
+	cost.Amount = overage
+	cost.Promotions = allowances
+
+	// Calls entirely covered by allowances don't use any promotion
+	if len(allowances) == 0 || !overage.IsZero() {
+		best, err := c.bestOffer(call, callType, overage, trace)
+		if err != nil {
+			return Cost{}, err
+		}
+
+		cost.Amount = best.amount
+		for _, promo := range best.promotions {
+			promo.Used(call)
+			cost.Promotions = append(cost.Promotions, promo.Name())
 		}
 	}
 
-	return callType.BaseCost()
+	if trace != nil {
+		trace.markUsed(cost.Promotions)
+		trace.Amount = cost.Amount
+		cost.Trace = trace
+	}
+
+	return cost, nil
 }
 
-// Methods to implement DurationRegisterer
+// consumeAllowances consumes the allowances of the type of the call, returning
+// the part of the base cost of the billed seconds they don't cover (the
+// overage) and the names of the ones that covered any.
+func (c *Processor) consumeAllowances(call Call, callType Type, baseCost money.Money, trace *Trace) (overage money.Money, names []string) {
+	uncovered := call.BilledDuration
+	for _, allowance := range c.allowances {
+		if uncovered == 0 {
+			break
+		}
+
+		if !allowance.appliesTo(callType) {
+			continue
+		}
+
+		covered := allowance.consume(uncovered)
+		uncovered -= covered
+		names = append(names, allowance.Name())
+		trace.covered(allowance, covered)
+	}
+
+	if len(names) == 0 {
+		return baseCost, nil
+	}
+
+	overage = baseCost.Prorate(int64(uncovered), int64(call.BilledDuration))
+	if trace != nil {
+		trace.Overage = &overage
+	}
 
-func (c *Processor) RegisterFriendCall(duration uint) {
-	c.totalDurations.TotalFriendsSeconds += duration
+	return overage, names
 }
 
-func (c *Processor) RegisterNationalCall(duration uint) {
-	c.totalDurations.TotalNationalSeconds += duration
+// An offer is a possible final cost of a call, with the promotions that
+// contributed to it.
+type offer struct {
+	amount     money.Money
+	promotions []Promotion
 }
 
-func (c *Processor) RegisterInternationalCall(duration uint) {
-	c.totalDurations.TotalInternationalSeconds += duration
+// bestOffer combines the promotions that apply to the call according to their
+// policies (see Policy), returning the final cost. If there is a trace, each
+// evaluated promotion is added to it.
+func (c *Processor) bestOffer(call Call, callType Type, baseCost money.Money, trace *Trace) (offer, error) {
+	stacked := offer{amount: baseCost}
+	var bestPrice []offer
+
+	for _, promo := range c.promotions {
+		if !promo.AppliesTo(call, callType) {
+			trace.evaluated(promo, nil)
+			continue
+		}
+
+		applyTo := baseCost
+		if promo.Policy() == PolicyStackable {
+			applyTo = stacked.amount
+		}
+
+		amount, err := promo.Apply(call, applyTo)
+		if err != nil {
+			return offer{}, fmt.Errorf("promotion %s: %s", promo.Name(), err)
+		}
+
+		switch promo.Policy() {
+		case PolicyExclusive:
+			trace.evaluated(promo, &amount)
+			return offer{amount: amount, promotions: []Promotion{promo}}, nil
+		case PolicyStackable:
+			stacked.amount = amount
+			stacked.promotions = append(stacked.promotions, promo)
+			trace.evaluated(promo, &stacked.amount)
+		case PolicyBestPrice:
+			o := offer{amount: amount, promotions: []Promotion{promo}}
+			bestPrice = append(bestPrice, o)
+			trace.evaluated(promo, &o.amount)
+		}
+	}
+
+	best := stacked
+	for _, o := range bestPrice {
+		if o.amount.Sub(best.amount).Sign() < 0 {
+			best = o
+		}
+	}
+
+	return best, nil
+}
+
+// IDs returns the IDs of the types of calls, in order.
+func (t TotalCallDurations) IDs() []string {
+	return t.ids
+}
+
+// Seconds returns the total seconds of calls of the type.
+func (t TotalCallDurations) Seconds(typeID string) uint {
+	return t.seconds[typeID]
+}
+
+// Register implements DurationRegisterer. Types that weren't known are added
+// at the end.
+func (t *TotalCallDurations) Register(typeID string, duration uint) {
+	if t.seconds == nil {
+		t.seconds = make(map[string]uint)
+	}
+
+	if _, ok := t.seconds[typeID]; !ok {
+		t.ids = append(t.ids, typeID)
+	}
+
+	t.seconds[typeID] += duration
 }
@@ -0,0 +1,337 @@
+package call_test
+
+import (
+	"invoice-generator/pkg/invoice/call"
+	"invoice-generator/pkg/invoice/tariff"
+	"invoice-generator/pkg/platform/money"
+	"invoice-generator/pkg/platform/sliceutil"
+	"invoice-generator/pkg/platform/timeutil"
+	"invoice-generator/pkg/user"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+var (
+	_user = user.User{Phone: "+5491111111111"}
+
+	_period = timeutil.Period{
+		Start: time.Date(2022, time.January, 1, 0, 0, 0, 0, time.UTC),
+		End:   time.Date(2022, time.December, 31, 0, 0, 0, 0, time.UTC),
+	}
+
+	// Costs $60 with the default tariff
+	_internationalCall = call.Call{
+		DestinationPhone: "+1991111111112",
+		SourcePhone:      string(_user.Phone),
+		Duration:         60,
+		Date:             time.Date(2022, time.September, 5, 20, 52, 44, 0, time.UTC),
+	}
+)
+
+func TestPromotionPolicies(t *testing.T) {
+	tests := []struct {
+		name       string
+		promotions []call.Promotion
+		amount     string
+		applied    []string
+	}{
+		{
+			name:       "without promotions the base cost is charged",
+			promotions: nil,
+			amount:     "60",
+		},
+		{
+			name: "stackable promotions are applied one after the other",
+			promotions: []call.Promotion{
+				&stubPromotion{name: "half", policy: call.PolicyStackable, factor: "0.5"},
+				&stubPromotion{name: "tenth_off", policy: call.PolicyStackable, factor: "0.9"},
+			},
+			amount:  "27",
+			applied: []string{"half", "tenth_off"},
+		},
+		{
+			name: "the first exclusive promotion is the only one applied",
+			promotions: []call.Promotion{
+				&stubPromotion{name: "half", policy: call.PolicyStackable, factor: "0.5"},
+				&stubPromotion{name: "exclusive", policy: call.PolicyExclusive, factor: "0.8"},
+				&stubPromotion{name: "other_exclusive", policy: call.PolicyExclusive, factor: "0"},
+			},
+			amount:  "48",
+			applied: []string{"exclusive"},
+		},
+		{
+			name: "best price promotion wins if it's cheaper than the stacked ones",
+			promotions: []call.Promotion{
+				&stubPromotion{name: "half", policy: call.PolicyStackable, factor: "0.5"},
+				&stubPromotion{name: "best", policy: call.PolicyBestPrice, factor: "0.4"},
+				&stubPromotion{name: "worse", policy: call.PolicyBestPrice, factor: "0.45"},
+			},
+			amount:  "24",
+			applied: []string{"best"},
+		},
+		{
+			name: "stacked promotions win ties",
+			promotions: []call.Promotion{
+				&stubPromotion{name: "best", policy: call.PolicyBestPrice, factor: "0.5"},
+				&stubPromotion{name: "half", policy: call.PolicyStackable, factor: "0.5"},
+			},
+			amount:  "30",
+			applied: []string{"half"},
+		},
+		{
+			name: "promotions that don't apply are ignored",
+			promotions: []call.Promotion{
+				&stubPromotion{name: "exclusive", policy: call.PolicyExclusive, factor: "0", doesntApply: true},
+				&stubPromotion{name: "half", policy: call.PolicyStackable, factor: "0.5"},
+			},
+			amount:  "30",
+			applied: []string{"half"},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			processor := call.NewProcessor(_user, _period, tariff.Single(tariff.Default()), tt.promotions, call.DefaultRegistry())
+
+			cost, skip, err := processor.Process(_internationalCall)
+			require.NoError(t, err)
+			assert.False(t, skip)
+			assert.Equal(t, money.MustParse(tt.amount, tariff.DefaultCurrency), cost.Amount)
+			assert.Equal(t, tt.applied, cost.Promotions)
+
+			for _, promo := range tt.promotions {
+				stub := promo.(*stubPromotion)
+				assert.Equal(t, sliceutil.Contains(tt.applied, stub.name), stub.used, "promotion %s used", stub.name)
+			}
+		})
+	}
+}
+
+func TestAllowancesAreConsumedInOrderAndOverageIsCharged(t *testing.T) {
+	allowance := call.NewAllowance(tariff.TypeInternational, 90)
+	promotions := []call.Promotion{
+		&stubPromotion{name: "half", policy: call.PolicyStackable, factor: "0.5"},
+	}
+
+	processor := call.NewProcessor(_user, _period, tariff.Single(tariff.Default()), promotions, call.DefaultRegistry())
+	processor.UseAllowances([]*call.Allowance{allowance})
+
+	// Each call is 60 seconds and costs $60
+	expected := []struct {
+		amount    string
+		applied   []string
+		remaining uint
+	}{
+		{amount: "0", applied: []string{"allowance_international"}, remaining: 30},         // Promotions don't apply to covered calls
+		{amount: "15", applied: []string{"allowance_international", "half"}, remaining: 0}, // 30 seconds of overage, halved
+		{amount: "30", applied: []string{"half"}, remaining: 0},
+	}
+
+	for _, e := range expected {
+		cost, skip, err := processor.Process(_internationalCall)
+		require.NoError(t, err)
+		assert.False(t, skip)
+		assert.Equal(t, money.MustParse(e.amount, tariff.DefaultCurrency), cost.Amount)
+		assert.Equal(t, e.applied, cost.Promotions)
+		assert.Equal(t, e.remaining, allowance.Remaining())
+	}
+
+	assert.Equal(t, uint(90), allowance.Consumed())
+}
+
+func TestAllowancesConsumeBilledSeconds(t *testing.T) {
+	// Calls are billed by the minute, so a call of 60 seconds and one second
+	// is billed as 120 seconds and costs $120
+	perMinute := tariff.Default()
+	perMinute.International.Default.Increments = tariff.Increments{Initial: 60, Subsequent: 60}
+
+	allowance := call.NewAllowance(tariff.TypeInternational, 150)
+	processor := call.NewProcessor(_user, _period, tariff.Single(perMinute), nil, call.DefaultRegistry())
+	processor.UseAllowances([]*call.Allowance{allowance})
+
+	longCall := _internationalCall
+	longCall.Duration = 61
+
+	cost, _, err := processor.Process(longCall)
+	require.NoError(t, err)
+	assert.Equal(t, money.MustParse("0", tariff.DefaultCurrency), cost.Amount)
+	assert.Equal(t, uint(30), allowance.Remaining())
+
+	// 30 of the 120 billed seconds are covered
+	cost, _, err = processor.Process(longCall)
+	require.NoError(t, err)
+	assert.Equal(t, money.MustParse("90", tariff.DefaultCurrency), cost.Amount)
+	assert.Equal(t, uint(0), allowance.Remaining())
+}
+
+func TestAllowancesAreConsumedBeforeExclusivePromotions(t *testing.T) {
+	allowance := call.NewAllowance(tariff.TypeInternational, 10)
+	promotions := []call.Promotion{
+		&stubPromotion{name: "ten_off", policy: call.PolicyExclusive, factor: "0.9"},
+	}
+
+	processor := call.NewProcessor(_user, _period, tariff.Single(tariff.Default()), promotions, call.DefaultRegistry())
+	processor.UseAllowances([]*call.Allowance{allowance})
+	processor.EnableTracing()
+
+	// The call costs $60, 10 of its 60 seconds are covered, and the discount
+	// applies to the other 50
+	cost, _, err := processor.Process(_internationalCall)
+	require.NoError(t, err)
+	assert.Equal(t, money.MustParse("45", tariff.DefaultCurrency), cost.Amount)
+	assert.Equal(t, []string{"allowance_international", "ten_off"}, cost.Promotions)
+	assert.Equal(t, uint(10), allowance.Consumed())
+
+	overage := money.MustParse("50", tariff.DefaultCurrency)
+	assert.Equal(t, []call.AllowanceTrace{{Name: "allowance_international", Covered: 10}}, cost.Trace.Allowances)
+	assert.Equal(t, &overage, cost.Trace.Overage)
+}
+
+func TestAllowancesOfOtherTypesAreNotConsumed(t *testing.T) {
+	allowance := call.NewAllowance(tariff.TypeNational, 90)
+	processor := call.NewProcessor(_user, _period, tariff.Single(tariff.Default()), nil, call.DefaultRegistry())
+	processor.UseAllowances([]*call.Allowance{allowance})
+
+	cost, _, err := processor.Process(_internationalCall)
+	require.NoError(t, err)
+	assert.Equal(t, money.MustParse("60", tariff.DefaultCurrency), cost.Amount)
+	assert.Equal(t, uint(90), allowance.Remaining())
+}
+
+func TestTraceExplainsHowTheCallWasRated(t *testing.T) {
+	promotions := []call.Promotion{
+		&stubPromotion{name: "not_for_this_call", policy: call.PolicyExclusive, factor: "0", doesntApply: true},
+		&stubPromotion{name: "half", policy: call.PolicyStackable, factor: "0.5"},
+		&stubPromotion{name: "best", policy: call.PolicyBestPrice, factor: "0.4"},
+	}
+
+	processor := call.NewProcessor(_user, _period, tariff.Single(tariff.Default()), promotions, call.DefaultRegistry())
+
+	cost, _, err := processor.Process(_internationalCall)
+	require.NoError(t, err)
+	assert.Nil(t, cost.Trace, "without tracing there is no trace")
+
+	processor.EnableTracing()
+	cost, _, err = processor.Process(_internationalCall)
+	require.NoError(t, err)
+
+	ars := func(amount string) *money.Money {
+		m := money.MustParse(amount, tariff.DefaultCurrency)
+		return &m
+	}
+
+	expected := &call.Trace{
+		Types:    []string{tariff.TypeInternational},
+		BaseCost: *ars("60"),
+		Promotions: []call.PromotionTrace{
+			{Name: "not_for_this_call", Policy: "exclusive"},
+			{Name: "half", Policy: "stackable", Applies: true, Amount: ars("30")},
+			{Name: "best", Policy: "best_price", Applies: true, Amount: ars("24"), Used: true},
+		},
+		Amount: *ars("24"),
+	}
+	assert.Equal(t, expected, cost.Trace)
+}
+
+func TestRegisteredTypesAreTotalized(t *testing.T) {
+	types := call.DefaultRegistry()
+	err := types.Register("satellite", "satellite", func(c call.Call, _ tariff.Tariff) (call.Type, bool) {
+		return satelliteCall{durationSecs: c.Duration}, c.DestinationPhone == "+8816123456"
+	})
+	require.NoError(t, err)
+	assert.Equal(t, []string{"international", "interplanetary", "national", "satellite", "friends"}, types.IDs())
+
+	processor := call.NewProcessor(_user, _period, tariff.Single(tariff.Default()), nil, types)
+
+	satellite := _internationalCall
+	satellite.DestinationPhone = "+8816123456"
+
+	cost, skip, err := processor.Process(satellite)
+	require.NoError(t, err)
+	assert.False(t, skip)
+	assert.Equal(t, money.MustParse("100", tariff.DefaultCurrency), cost.Amount)
+
+	_, skip, err = processor.Process(_internationalCall)
+	require.NoError(t, err)
+	assert.False(t, skip)
+
+	real, billed := processor.Summarize()
+	assert.Equal(t, uint(60), real.Seconds("satellite"))
+	assert.Equal(t, uint(60), real.Seconds(tariff.TypeInternational))
+	assert.Equal(t, uint(0), real.Seconds(tariff.TypeNational))
+	assert.Equal(t, uint(60), billed.Seconds("satellite"))
+	assert.Equal(t, types.IDs(), real.IDs())
+}
+
+func TestRegisteredTypesArePricedByTheirKey(t *testing.T) {
+	// Roaming calls are priced like satellite ones, which aren't built-in
+	types, err := call.NewRegistry("roaming", "satellite", func(c call.Call, _ tariff.Tariff) call.Type {
+		return call.NewPricedCall(c, "roaming", "satellite")
+	})
+	require.NoError(t, err)
+	assert.Equal(t, []string{"roaming", "friends"}, types.IDs())
+	assert.Contains(t, types.CallTypes().Names(), "roaming", "time bands, plans, taxes and promotions can refer to it")
+
+	satelliteTariff := tariff.Default()
+	satelliteTariff.Pricings = map[string]tariff.Pricing{"satellite": {PerSecond: money.MustParseDecimal("2")}}
+
+	processor := call.NewProcessor(_user, _period, tariff.Single(satelliteTariff), nil, types)
+	cost, _, err := processor.Process(_internationalCall)
+	require.NoError(t, err)
+	assert.Equal(t, "roaming", cost.Type)
+	assert.Equal(t, money.MustParse("120", tariff.DefaultCurrency), cost.Amount)
+
+	processor = call.NewProcessor(_user, _period, tariff.Single(tariff.Default()), nil, types)
+	_, _, err = processor.Process(_internationalCall)
+	assert.EqualError(t, err, "the tariff has no satellite pricing for roaming calls")
+}
+
+func TestRegisteringATypeWithAnotherPricingIsAnError(t *testing.T) {
+	types := call.DefaultRegistry()
+	err := types.Register(tariff.TypeNational, "satellite", func(c call.Call, _ tariff.Tariff) (call.Type, bool) {
+		return satelliteCall{durationSecs: c.Duration}, true
+	})
+	assert.EqualError(t, err, "call type national is already registered with pricing national")
+	assert.Equal(t, call.DefaultRegistry().IDs(), types.IDs(), "the type isn't registered")
+
+	_, err = call.NewRegistry(tariff.TypeInternational, "satellite", call.ClassifyInternational)
+	assert.EqualError(t, err, "call type international is already registered with pricing international")
+}
+
+// satelliteCall is a type of call that isn't built-in, and costs $100
+type satelliteCall struct {
+	durationSecs uint
+}
+
+func (satelliteCall) Name() string                                 { return "satellite" }
+func (satelliteCall) HasCharacteristic(_ call.Characteristic) bool { return false }
+
+func (c satelliteCall) BaseCost(t tariff.Tariff) (call.Cost, error) {
+	return call.Cost{Amount: money.MustParse("100", t.Currency), Type: "satellite", BilledDuration: c.durationSecs}, nil
+}
+
+func (satelliteCall) RegisterDuration(duration uint, registerer call.DurationRegisterer) {
+	registerer.Register("satellite", duration)
+}
+
+// stubPromotion multiplies the cost by a factor
+type stubPromotion struct {
+	name        string
+	policy      call.Policy
+	factor      string
+	doesntApply bool
+
+	used bool
+}
+
+func (s *stubPromotion) Name() string                            { return s.name }
+func (s *stubPromotion) Policy() call.Policy                     { return s.policy }
+func (s *stubPromotion) AppliesTo(_ call.Call, _ call.Type) bool { return !s.doesntApply }
+func (s *stubPromotion) Used(_ call.Call)                        { s.used = true }
+
+func (s *stubPromotion) Apply(_ call.Call, cost money.Money) (money.Money, error) {
+	return cost.Mul(money.MustParseDecimal(s.factor))
+}
@@ -0,0 +1,156 @@
+package call
+
+import (
+	"fmt"
+	"invoice-generator/pkg/platform/config"
+	"strings"
+)
+
+// Columns of the calls files
+const (
+	columnSource      = "source"
+	columnDestination = "destination"
+	columnDuration    = "duration"
+	columnDate        = "date"
+)
+
+// columnNames are the columns of the calls files, all of them required.
+var columnNames = []string{columnSource, columnDestination, columnDuration, columnDate}
+
+// Columns are the headers each column of the calls files can have (or the keys
+// of the fields of JSON files). Columns are located by their header, so they
+// can be in any order, and the ones that aren't known are ignored. Headers are
+// compared ignoring case and surrounding spaces.
+//
+// Besides the default headers (in Spanish and English), a columns file can
+// declare aliases for other ones, like the headers of the files of a vendor:
+//
+//	source: [calling_number, a_number]
+//	destination: [called_number, b_number]
+//	duration: [duration_secs]
+//	date: [start_time]
+type Columns struct {
+	byHeader map[string]string   // Column of each normalized header
+	aliases  map[string][]string // Headers of each column, for errors
+}
+
+// positions are the indexes of the columns in the records of a calls file.
+type positions struct {
+	source      int
+	destination int
+	duration    int
+	date        int
+}
+
+// fileColumns are the aliases of a columns file.
+type fileColumns struct {
+	Source      []string `yaml:"source"`
+	Destination []string `yaml:"destination"`
+	Duration    []string `yaml:"duration"`
+	Date        []string `yaml:"date"`
+}
+
+// DefaultColumns returns the columns with their default headers.
+func DefaultColumns() Columns {
+	c := Columns{byHeader: make(map[string]string), aliases: make(map[string][]string)}
+	c.add(columnSource, "numero origen", "número origen", "source")
+	c.add(columnDestination, "numero destino", "número destino", "destination")
+	c.add(columnDuration, "duracion", "duración", "duration")
+	c.add(columnDate, "fecha", "date")
+
+	return c
+}
+
+// LoadColumns loads the default columns plus the aliases of the content of a
+// columns file.
+func LoadColumns(content []byte) (Columns, error) {
+	var file fileColumns
+
+	doc, err := config.Decode(content, &file)
+	if err != nil {
+		return Columns{}, err
+	}
+
+	c := DefaultColumns()
+	aliases := map[string][]string{
+		columnSource:      file.Source,
+		columnDestination: file.Destination,
+		columnDuration:    file.Duration,
+		columnDate:        file.Date,
+	}
+
+	for _, column := range columnNames {
+		for i, alias := range aliases[column] {
+			header := normalizeHeader(alias)
+			if header == "" {
+				return Columns{}, config.Errorf(doc.Line(column, i), "alias of %s can't be empty", column)
+			}
+
+			if other, ok := c.byHeader[header]; ok && other != column {
+				return Columns{}, config.Errorf(doc.Line(column, i), "alias %q is already a header of %s", alias, other)
+			}
+
+			c.add(column, alias)
+		}
+	}
+
+	return c, nil
+}
+
+func (c Columns) add(column string, headers ...string) {
+	for _, header := range headers {
+		c.byHeader[normalizeHeader(header)] = column
+		c.aliases[column] = append(c.aliases[column], header)
+	}
+}
+
+// locate returns the positions of the columns in the names of the fields of
+// a record, like the header row of a calls file or the keys of a JSON object.
+// All the columns are required, and each one can be only once. What is the
+// kind of field, for errors.
+func (c Columns) locate(names []string, what string) (positions, error) {
+	indexes := make(map[string]int)
+	for i, name := range names {
+		if i == 0 {
+			name = strings.TrimPrefix(name, "\ufeff") // byte order mark of some editors
+		}
+
+		column, ok := c.byHeader[normalizeHeader(name)]
+		if !ok {
+			continue // extra columns are ignored
+		}
+
+		if previous, ok := indexes[column]; ok {
+			return positions{}, fmt.Errorf("%q and %q are both the %s %s", names[previous], name, column, what)
+		}
+
+		indexes[column] = i
+	}
+
+	for _, column := range columnNames {
+		if _, ok := indexes[column]; !ok {
+			return positions{}, fmt.Errorf("missing %s %s, expected one named %s", column, what, quoteAll(c.aliases[column]))
+		}
+	}
+
+	return positions{
+		source:      indexes[columnSource],
+		destination: indexes[columnDestination],
+		duration:    indexes[columnDuration],
+		date:        indexes[columnDate],
+	}, nil
+}
+
+func normalizeHeader(header string) string {
+	return strings.ToLower(strings.TrimSpace(header))
+}
+
+// quoteAll returns the quoted names separated by " or ".
+func quoteAll(names []string) string {
+	quoted := make([]string, len(names))
+	for i, name := range names {
+		quoted[i] = fmt.Sprintf("%q", name)
+	}
+
+	return strings.Join(quoted, " or ")
+}
@@ -0,0 +1,99 @@
+package call
+
+import (
+	"encoding/csv"
+	"fmt"
+	"invoice-generator/pkg/platform/timeutil"
+	"io"
+	"strconv"
+	"time"
+)
+
+// csvSource is a Source of the calls of a csv file, which are read row by row
+// as they are processed. The first row is the header, which locates the
+// columns (see Columns):
+//   - Source phone number
+//   - Destination phone number
+//   - Duration (in seconds)
+//   - Date (ISO8601 in UTC)
+//
+// Nota de diseño: Antes se leía el archivo entero con os.ReadFile y se
+// armaba un slice con todas las llamadas, lo que no escalaba para archivos
+// que no entran en memoria. Ahora se lee de a una fila, y la factura solo se
+// queda con las llamadas del usuario.
+type csvSource struct {
+	reader    *csv.Reader
+	columns   Columns
+	positions *positions // Located when the header is read
+}
+
+// Verify interface compliance
+var _ Source = &csvSource{}
+
+// FromCSV returns a source of the calls of the csv file, with the columns.
+func FromCSV(r io.Reader, cols Columns) Source {
+	reader := csv.NewReader(r)
+	reader.FieldsPerRecord = 0 // all rows have the fields of the header
+	reader.ReuseRecord = true  // each record is converted to a call right away
+
+	return &csvSource{reader: reader, columns: cols}
+}
+
+// Next implements Source.
+func (s *csvSource) Next() (Call, error) {
+	if s.positions == nil {
+		header, err := s.reader.Read()
+		if err != nil {
+			return Call{}, err
+		}
+
+		located, err := s.columns.locate(header, "column")
+		if err != nil {
+			return Call{}, fmt.Errorf("header: %s", err)
+		}
+
+		s.positions = &located
+	}
+
+	record, err := s.reader.Read()
+	if err != nil {
+		// csv reader errors already have line numbers, and io.EOF means
+		// there are no more calls
+		return Call{}, err
+	}
+
+	aCall, err := recordToCall(record, *s.positions)
+	if err != nil {
+		line, _ := s.reader.FieldPos(0)
+		return Call{}, fmt.Errorf("record on line %d: %s", line, err)
+	}
+
+	return aCall, nil
+}
+
+func recordToCall(record []string, at positions) (Call, error) {
+	sourcePhoneNumber := record[at.source]
+	destPhoneNumber := record[at.destination]
+	duration, err := parseDuration(record[at.duration])
+	if err != nil {
+		return Call{}, fmt.Errorf("parsing duration: %s", err)
+	}
+
+	date, err := time.Parse(timeutil.LayoutISO8601, record[at.date])
+	if err != nil {
+		return Call{}, fmt.Errorf("parsing date: %s", err)
+	}
+
+	return New(destPhoneNumber, sourcePhoneNumber, duration, date)
+}
+
+func parseDuration(rawDuration string) (uint, error) {
+	// Nota: con 32 bits para segundos nos alcanza para llamadas de 8100 años,
+	// así que deberíamos estar bien :P
+	duration, err := strconv.ParseUint(rawDuration, 10, 32)
+	if err != nil {
+		return 0, err
+	}
+
+	return uint(duration), nil
+}
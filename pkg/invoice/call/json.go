@@ -0,0 +1,194 @@
+package call
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"invoice-generator/pkg/platform/sliceutil"
+	"io"
+)
+
+// jsonSource is a Source of the calls of a JSON file with an array of objects,
+// which are decoded one by one as they are processed. Each object has the same
+// fields as the columns of a csv file (see Columns):
+//
+//	[
+//	  {"source": "+5491167980950", "destination": "+191167980952", "duration": 462, "date": "2020-11-10T04:02:45Z"}
+//	]
+//
+// Errors say the line the object of the call starts on, like the ones of csv
+// files.
+type jsonSource struct {
+	decoder *json.Decoder
+	lines   *lineReader
+	columns Columns
+	started bool // Whether the opening bracket of the array was read
+}
+
+// Verify interface compliance
+var _ Source = &jsonSource{}
+
+// FromJSON returns a source of the calls of the JSON array, with the columns.
+func FromJSON(r io.Reader, cols Columns) Source {
+	lines := &lineReader{reader: r, line: 1}
+	return &jsonSource{decoder: json.NewDecoder(lines), lines: lines, columns: cols}
+}
+
+// Next implements Source.
+func (s *jsonSource) Next() (Call, error) {
+	if !s.started {
+		s.started = true
+		token, err := s.decoder.Token()
+		if err == io.EOF {
+			return Call{}, io.EOF // an empty file has no calls
+		}
+
+		if err != nil {
+			return Call{}, err
+		}
+
+		if token != json.Delim('[') {
+			return Call{}, errors.New("expected an array of calls")
+		}
+	}
+
+	if !s.decoder.More() {
+		// Consume the closing bracket, so that errors after it are reported
+		if _, err := s.decoder.Token(); err != nil {
+			return Call{}, err
+		}
+
+		return Call{}, io.EOF
+	}
+
+	// Until the object is decoded (even if decoding fails), the offset of the
+	// decoder is before it, at the comma that precedes it if any.
+	var raw json.RawMessage
+	if err := s.decoder.Decode(&raw); err != nil {
+		return Call{}, fmt.Errorf("line %d: %s", s.lines.valueAt(s.decoder.InputOffset()), err)
+	}
+
+	line := s.lines.valueAt(s.decoder.InputOffset() - int64(len(raw)))
+
+	var object map[string]json.RawMessage
+	if err := json.Unmarshal(raw, &object); err != nil {
+		return Call{}, fmt.Errorf("line %d: %s", line, err)
+	}
+
+	aCall, err := objectToCall(object, s.columns)
+	if err != nil {
+		return Call{}, fmt.Errorf("line %d: %s", line, err)
+	}
+
+	return aCall, nil
+}
+
+// lineReader finds the lines of the offsets of a decoder that reads from it.
+// Decoders read ahead, so it keeps what was read after the last offset it
+// was asked for, which isn't more than what the decoder itself buffers.
+type lineReader struct {
+	reader  io.Reader
+	pending []byte // Read after offset
+	offset  int64
+	line    int // Of offset, from 1
+}
+
+func (l *lineReader) Read(p []byte) (int, error) {
+	n, err := l.reader.Read(p)
+	l.pending = append(l.pending, p[:n]...)
+	return n, err
+}
+
+// valueAt returns the line of the first value at or after the offset, skipping
+// spaces and the comma before it. Offsets can't go backwards.
+func (l *lineReader) valueAt(offset int64) int {
+	consumed := l.pending[:offset-l.offset]
+	l.line += bytes.Count(consumed, []byte("\n"))
+	l.pending = l.pending[len(consumed):]
+	l.offset = offset
+
+	separators := len(l.pending) - len(bytes.TrimLeft(l.pending, ", \t\r\n"))
+	return l.line + bytes.Count(l.pending[:separators], []byte("\n"))
+}
+
+// ndjsonSource is a Source of the calls of a newline delimited JSON file, which
+// has an object like the ones of jsonSource on each line. Empty lines are
+// ignored.
+type ndjsonSource struct {
+	reader  *bufio.Reader
+	columns Columns
+	line    int
+}
+
+// Verify interface compliance
+var _ Source = &ndjsonSource{}
+
+// FromNDJSON returns a source of the calls of the newline delimited JSON, with
+// the columns.
+func FromNDJSON(r io.Reader, cols Columns) Source {
+	return &ndjsonSource{reader: bufio.NewReader(r), columns: cols}
+}
+
+// Next implements Source.
+func (s *ndjsonSource) Next() (Call, error) {
+	for {
+		line, err := s.reader.ReadBytes('\n')
+		if err != nil && err != io.EOF {
+			return Call{}, err
+		}
+
+		if len(line) == 0 && err == io.EOF {
+			return Call{}, io.EOF
+		}
+
+		s.line++
+		line = bytes.TrimSpace(line)
+		if len(line) == 0 {
+			continue
+		}
+
+		var object map[string]json.RawMessage
+		if err := json.Unmarshal(line, &object); err != nil {
+			return Call{}, fmt.Errorf("line %d: %s", s.line, err)
+		}
+
+		aCall, err := objectToCall(object, s.columns)
+		if err != nil {
+			return Call{}, fmt.Errorf("line %d: %s", s.line, err)
+		}
+
+		return aCall, nil
+	}
+}
+
+// objectToCall returns the call of the fields of a JSON object. Values are
+// converted to text (strings without the quotes, numbers as they are) and
+// parsed like the ones of csv records.
+func objectToCall(object map[string]json.RawMessage, cols Columns) (Call, error) {
+	if object == nil {
+		return Call{}, errors.New("expected an object")
+	}
+
+	names := sliceutil.SortedKeys(object)
+	record := make([]string, 0, len(object))
+	for _, name := range names {
+		raw := object[name]
+		value := string(raw)
+		if len(raw) > 0 && raw[0] == '"' {
+			if err := json.Unmarshal(raw, &value); err != nil {
+				return Call{}, fmt.Errorf("field %q: %s", name, err)
+			}
+		}
+
+		record = append(record, value)
+	}
+
+	at, err := cols.locate(names, "field")
+	if err != nil {
+		return Call{}, err
+	}
+
+	return recordToCall(record, at)
+}
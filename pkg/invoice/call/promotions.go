@@ -1,37 +1,195 @@
 package call
 
-import "invoice-generator/pkg/user"
+import (
+	"invoice-generator/pkg/invoice/tariff"
+	"invoice-generator/pkg/platform/money"
+	"invoice-generator/pkg/platform/phone"
+)
 
 type Promotion interface {
-	// AppliesTo returns whether a promotion applies to a call
-	AppliesTo(Call) bool
+	// Name identifies the promotion on invoices
+	Name() string
 
-	// Apply applies the promotion to the call, returning the final cost
-	Apply(Call) float64
+	// Policy is how the promotion combines with others
+	Policy() Policy
+
+	// AppliesTo returns whether a promotion applies to a call of the type
+	AppliesTo(Call, Type) bool
+
+	// Apply applies the promotion to the cost of the call, returning the
+	// final cost (in the same currency). The cost is the base cost, or the
+	// result of the previous promotions if it's stacked. It returns an error
+	// if the cost is out of range.
+	//
+	// It shouldn't change the state of the promotion, since the result may
+	// not be used (e.g. if a better promotion applies). See Used.
+	Apply(call Call, cost money.Money) (money.Money, error)
+
+	// Used is called when the promotion contributed to the final cost of the
+	// call, so that promotions can keep track of how many times they were
+	// used.
+	Used(Call)
 }
 
-type promotionCallToFriends struct {
-	usr user.User
+// A Policy is how a promotion combines with the other ones that apply to the
+// same call. Promotions are always considered in the order they are
+// configured.
+//
+//   - If an exclusive promotion applies, the first one is the only one used.
+//   - Otherwise, all stackable promotions are applied one after the other, and
+//     the result competes with each best price promotion (applied to the base
+//     cost) for the lowest price. On a tie, the stacked promotions win, and
+//     then the first best price one.
+type Policy uint
+
+const (
+	PolicyExclusive Policy = iota + 1
+	PolicyStackable
+	PolicyBestPrice
+)
+
+func (p Policy) String() string {
+	switch p {
+	case PolicyExclusive:
+		return "exclusive"
+	case PolicyStackable:
+		return "stackable"
+	case PolicyBestPrice:
+		return "best_price"
+	default:
+		return "unknown"
+	}
+}
 
+type promotionCallToFriends struct {
 	currentFreeCallsToFriends uint
 }
 
-func NewPromotionFreeCallsToFriends(usr user.User) *promotionCallToFriends {
+func NewPromotionFreeCallsToFriends() *promotionCallToFriends {
 	return &promotionCallToFriends{
-		usr:                       usr,
 		currentFreeCallsToFriends: 0,
 	}
 }
 
-func (p *promotionCallToFriends) AppliesTo(call Call) bool {
+func (p *promotionCallToFriends) Name() string { return "free_calls_to_friends" }
+
+// Policy of free calls is exclusive, since other discounts wouldn't matter.
+func (p *promotionCallToFriends) Policy() Policy { return PolicyExclusive }
+
+func (p *promotionCallToFriends) AppliesTo(call Call, callType Type) bool {
 	const maxFreeCallsToFriends = 10
 	didntExceedMax := p.currentFreeCallsToFriends < maxFreeCallsToFriends
-	isCallToFriend := call.Type(p.usr.Friends).HasCharacteristic(CharacteristicToFriend)
+	isCallToFriend := callType.HasCharacteristic(CharacteristicToFriend)
 
 	return isCallToFriend && didntExceedMax
 }
 
-func (p *promotionCallToFriends) Apply(call Call) float64 {
+func (p *promotionCallToFriends) Apply(call Call, cost money.Money) (money.Money, error) {
+	return money.Zero(cost.Currency()), nil
+}
+
+func (p *promotionCallToFriends) Used(call Call) {
 	p.currentFreeCallsToFriends++
-	return 0
 }
+
+// An Allowance is a bundle of seconds of a type of call included in the plan
+// of a user (e.g. 300 national minutes). Calls consume their billed seconds
+// (see Call.BilledDuration) in order, and the ones that aren't covered (the
+// overage) are charged at the tariff. Allowances aren't promotions: the
+// processor consumes them before evaluating any promotion, which only applies
+// to the overage (see Processor.UseAllowances).
+//
+// Like the free calls to friends, it keeps track of how much of it was used,
+// so it must be constructed for each invoice.
+type Allowance struct {
+	callType      string
+	includedSecs  uint
+	remainingSecs uint
+}
+
+// NewAllowance returns an allowance of the seconds for calls of the type (by
+// name in tariffs, e.g. national).
+func NewAllowance(callType string, seconds uint) *Allowance {
+	return &Allowance{callType: callType, includedSecs: seconds, remainingSecs: seconds}
+}
+
+func (a *Allowance) Name() string { return "allowance_" + a.callType }
+
+func (a *Allowance) appliesTo(callType Type) bool {
+	return callType.Name() == a.callType && a.remainingSecs > 0
+}
+
+// consume consumes up to the seconds, returning the ones it covered.
+func (a *Allowance) consume(seconds uint) uint {
+	covered := seconds
+	if a.remainingSecs < covered {
+		covered = a.remainingSecs
+	}
+
+	a.remainingSecs -= covered
+	return covered
+}
+
+// CallType is the name of the type of calls of the allowance.
+func (a *Allowance) CallType() string { return a.callType }
+
+// Included returns the seconds included in the allowance.
+func (a *Allowance) Included() uint { return a.includedSecs }
+
+// Remaining returns the seconds that weren't consumed yet.
+func (a *Allowance) Remaining() uint { return a.remainingSecs }
+
+// Consumed returns the seconds consumed by calls.
+func (a *Allowance) Consumed() uint { return a.includedSecs - a.remainingSecs }
+
+// MercosurMembers are the full members of Mercosur, by ISO 3166-1 alpha-2 code.
+var MercosurMembers = []string{"AR", "BR", "PY", "UY"}
+
+// promotionMercosur discounts a percentage of international calls to Mercosur
+// countries.
+type promotionMercosur struct {
+	countries  []string
+	percentage money.Decimal
+	policy     Policy
+}
+
+// NewPromotionMercosur returns a promotion that takes the percentage off the
+// cost of international calls to the countries (ISO 3166-1 alpha-2 codes, e.g.
+// MercosurMembers and any associates).
+func NewPromotionMercosur(countries []string, percentage money.Decimal, policy Policy) promotionMercosur {
+	return promotionMercosur{countries: countries, percentage: percentage, policy: policy}
+}
+
+func (p promotionMercosur) Name() string { return "mercosur" }
+
+func (p promotionMercosur) Policy() Policy { return p.policy }
+
+func (p promotionMercosur) AppliesTo(call Call, callType Type) bool {
+	if callType.Name() != tariff.TypeInternational {
+		return false
+	}
+
+	destination, err := phone.Parse(call.DestinationPhone)
+	if err != nil {
+		return false
+	}
+
+	for _, country := range p.countries {
+		if destination.Region() == country {
+			return true
+		}
+	}
+
+	return false
+}
+
+func (p promotionMercosur) Apply(call Call, cost money.Money) (money.Money, error) {
+	discount, err := cost.Percent(p.percentage)
+	if err != nil {
+		return money.Money{}, err
+	}
+
+	return cost.Sub(discount), nil
+}
+
+func (p promotionMercosur) Used(call Call) {}
@@ -0,0 +1,154 @@
+package call
+
+import (
+	"invoice-generator/pkg/invoice/tariff"
+	"invoice-generator/pkg/user"
+)
+
+// TypeFriends is the ID of the total of calls to friends. Calls to friends
+// aren't a type by themselves (see FriendCall), but they have their own total.
+const TypeFriends = "friends"
+
+// A Classifier recognizes calls of a type, returning false if the call isn't
+// of that type.
+type Classifier func(c Call, t tariff.Tariff) (Type, bool)
+
+// A Fallback returns the type of the calls that no classifier recognizes.
+type Fallback func(c Call, t tariff.Tariff) Type
+
+// A Registry classifies calls with the registered classifiers, in the order
+// they were registered. Calls that no classifier recognizes are of the type of
+// its fallback.
+//
+// Each type is registered with its name, which is the ID of its duration
+// totals (see TotalCallDurations), and with the key of its pricing in tariffs.
+// New types of calls are added by registering their classifier, and their
+// durations are totalized without further changes.
+//
+// The registry also keeps the types as types of tariffs (see CallTypes), so
+// that time bands, plans, taxes and promotions loaded with them can refer to
+// the registered types.
+type Registry struct {
+	types       tariff.CallTypes
+	fallback    registeredType
+	classifiers []registeredType
+}
+
+type registeredType struct {
+	name     string
+	classify Classifier
+}
+
+// NewRegistry returns a registry without classifiers, in which every call is
+// of the fallback type, registered like the ones of Register.
+func NewRegistry(name, pricingKey string, fallback Fallback) (*Registry, error) {
+	types, err := tariff.CallTypes{}.Add(name, pricingKey)
+	if err != nil {
+		return nil, err
+	}
+
+	return &Registry{types: types, fallback: fallbackType(name, fallback)}, nil
+}
+
+// DefaultRegistry returns a registry with the built-in types: interplanetary,
+// national and international calls, which are the fallback.
+func DefaultRegistry() *Registry {
+	// The built-in types are always types of tariffs, so they can't conflict
+	return &Registry{
+		fallback: fallbackType(tariff.TypeInternational, ClassifyInternational),
+		classifiers: []registeredType{
+			{name: tariff.TypeInterplanetary, classify: ClassifyInterplanetary},
+			{name: tariff.TypeNational, classify: ClassifyNational},
+		},
+	}
+}
+
+func fallbackType(name string, fallback Fallback) registeredType {
+	classify := func(c Call, t tariff.Tariff) (Type, bool) { return fallback(c, t), true }
+	return registeredType{name: name, classify: classify}
+}
+
+// defaultRegistry classifies calls that don't have a registry (see Call.Type).
+var defaultRegistry = DefaultRegistry()
+
+// Register registers the classifier of the type with its name and the key of
+// its pricing in tariffs. It's tried after the ones already registered. It
+// returns an error if the name is already a type with another pricing.
+func (r *Registry) Register(name, pricingKey string, classifier Classifier) error {
+	types, err := r.types.Add(name, pricingKey)
+	if err != nil {
+		return err
+	}
+
+	r.types = types
+	r.classifiers = append(r.classifiers, registeredType{name: name, classify: classifier})
+	return nil
+}
+
+// CallTypes returns the types of the registry as types of tariffs, to load the
+// files that refer to them (e.g. tariff.Load).
+func (r *Registry) CallTypes() tariff.CallTypes {
+	return r.types
+}
+
+// IDs returns the IDs of the types of the registry that have duration totals,
+// including calls to friends.
+func (r *Registry) IDs() []string {
+	ids := []string{r.fallback.name}
+	for _, c := range r.classifiers {
+		ids = append(ids, c.name)
+	}
+
+	return append(ids, TypeFriends)
+}
+
+// Classify returns the type of the call. Calls to friends are FriendCall of
+// their base type.
+func (r *Registry) Classify(c Call, friends []user.PhoneNumber, t tariff.Tariff) Type {
+	if c.isFriend(friends) {
+		return FriendCall{subtype: r.baseType(c, t)}
+	}
+
+	return r.baseType(c, t)
+}
+
+func (r *Registry) baseType(c Call, t tariff.Tariff) Type {
+	for _, classifier := range r.classifiers {
+		if callType, ok := classifier.classify(c, t); ok {
+			return callType
+		}
+	}
+
+	callType, _ := r.fallback.classify(c, t)
+	return callType
+}
+
+// ClassifyInternational is the fallback of the default registry: calls that
+// aren't of any other type are international.
+func ClassifyInternational(c Call, _ tariff.Tariff) Type {
+	return InternationalCall{
+		durationSecs:     c.Duration,
+		date:             c.Date,
+		holidays:         c.Holidays(),
+		destinationPhone: c.DestinationPhone,
+	}
+}
+
+// ClassifyInterplanetary recognizes calls to the interplanetary prefix of the
+// tariff.
+func ClassifyInterplanetary(c Call, t tariff.Tariff) (Type, bool) {
+	if !t.Interplanetary.Matches(c.DestinationPhone) {
+		return nil, false
+	}
+
+	return InterplanetaryCall{durationSecs: c.Duration, date: c.Date, holidays: c.Holidays()}, true
+}
+
+// ClassifyNational recognizes calls made to the same country.
+func ClassifyNational(c Call, _ tariff.Tariff) (Type, bool) {
+	if !c.isNational() {
+		return nil, false
+	}
+
+	return NationalCall{durationSecs: c.Duration, date: c.Date, holidays: c.Holidays()}, true
+}
@@ -0,0 +1,35 @@
+package call
+
+import "io"
+
+// A Source reads calls one by one, so that they can be processed as they are
+// read instead of having all of them in memory.
+type Source interface {
+	// Next returns the next call. It returns io.EOF when there are no more
+	// calls, and any other error if the call couldn't be read.
+	Next() (Call, error)
+}
+
+// sliceSource is a Source of calls that are already in memory.
+type sliceSource struct {
+	calls []Call
+}
+
+// Verify interface compliance
+var _ Source = &sliceSource{}
+
+// FromSlice returns a source of the calls, in order.
+func FromSlice(calls []Call) Source {
+	return &sliceSource{calls: calls}
+}
+
+// Next implements Source.
+func (s *sliceSource) Next() (Call, error) {
+	if len(s.calls) == 0 {
+		return Call{}, io.EOF
+	}
+
+	next := s.calls[0]
+	s.calls = s.calls[1:]
+	return next, nil
+}
@@ -0,0 +1,233 @@
+package call_test
+
+import (
+	"invoice-generator/pkg/invoice/call"
+	"io"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestReadCalls(t *testing.T) {
+	source := call.FromCSV(strings.NewReader(`numero origen,numero destino,duracion,fecha
++5491167980950,+191167980952,462,2020-11-10T04:02:45Z
++5491167910920,+191167980952,392,2020-08-09T04:45:25Z
+`), call.DefaultColumns())
+
+	var calls []call.Call
+	for {
+		c, err := source.Next()
+		if err == io.EOF {
+			break
+		}
+
+		require.NoError(t, err)
+		calls = append(calls, c)
+	}
+
+	expectedCalls := []call.Call{
+		{
+			SourcePhone:      "+5491167980950",
+			DestinationPhone: "+191167980952",
+			Duration:         462,
+			Date:             time.Date(2020, time.November, 10, 04, 02, 45, 0, time.UTC),
+		},
+		{
+			SourcePhone:      "+5491167910920",
+			DestinationPhone: "+191167980952",
+			Duration:         392,
+			Date:             time.Date(2020, time.August, 9, 04, 45, 25, 0, time.UTC),
+		},
+	}
+	assert.Equal(t, expectedCalls, calls)
+}
+
+func TestColumnsAreLocatedByHeader(t *testing.T) {
+	// Columns in another order, an extra one, and headers in English
+	source := call.FromCSV(strings.NewReader(`Date,ID,Duration,Destination,Source
+2020-11-10T04:02:45Z,1,462,+191167980952,+5491167980950
+`), call.DefaultColumns())
+
+	c, err := source.Next()
+	require.NoError(t, err)
+	assert.Equal(t, call.Call{
+		SourcePhone:      "+5491167980950",
+		DestinationPhone: "+191167980952",
+		Duration:         462,
+		Date:             time.Date(2020, time.November, 10, 04, 02, 45, 0, time.UTC),
+	}, c)
+}
+
+func TestColumnsWithAliases(t *testing.T) {
+	cols, err := call.LoadColumns([]byte(`
+source: [calling_number]
+destination: [called_number]
+duration: [duration_secs]
+date: [start_time]
+`))
+	require.NoError(t, err)
+
+	source := call.FromCSV(strings.NewReader(`calling_number,called_number,duration_secs,start_time
++5491167980950,+191167980952,462,2020-11-10T04:02:45Z
+`), cols)
+
+	c, err := source.Next()
+	require.NoError(t, err)
+	assert.Equal(t, "+5491167980950", c.SourcePhone)
+	assert.Equal(t, uint(462), c.Duration)
+}
+
+func TestInvalidHeaders(t *testing.T) {
+	tests := []struct {
+		name   string
+		header string
+		err    string
+	}{
+		{
+			name:   "missing column",
+			header: "numero origen,numero destino,fecha",
+			err:    `header: missing duration column, expected one named "duracion" or "duración" or "duration"`,
+		},
+		{
+			name:   "repeated column",
+			header: "numero origen,source,numero destino,duracion,fecha",
+			err:    `header: "numero origen" and "source" are both the source column`,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := call.FromCSV(strings.NewReader(tt.header+"\n"), call.DefaultColumns()).Next()
+			assert.EqualError(t, err, tt.err)
+		})
+	}
+}
+
+func TestLoadColumnsErrors(t *testing.T) {
+	tests := []struct {
+		name    string
+		content string
+		err     string
+	}{
+		{
+			name:    "alias of another column",
+			content: "source: [a_number]\ndestination: [fecha]",
+			err:     `line 2: alias "fecha" is already a header of date`,
+		},
+		{
+			name:    "empty alias",
+			content: "date: [\"\"]",
+			err:     `line 1: alias of date can't be empty`,
+		},
+		{
+			name:    "unknown column",
+			content: "cost: [amount]",
+			err:     "line 1: field cost not found in type call.fileColumns",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := call.LoadColumns([]byte(tt.content))
+			assert.EqualError(t, err, tt.err)
+		})
+	}
+}
+
+func TestJSONCalls(t *testing.T) {
+	expected := call.Call{
+		SourcePhone:      "+5491167980950",
+		DestinationPhone: "+191167980952",
+		Duration:         462,
+		Date:             time.Date(2020, time.November, 10, 04, 02, 45, 0, time.UTC),
+	}
+
+	sources := map[string]call.Source{
+		"json": call.FromJSON(strings.NewReader(`[
+  {"source": "+5491167980950", "destination": "+191167980952", "duration": 462, "date": "2020-11-10T04:02:45Z", "id": 1},
+  {"numero origen": "+5491167980950", "numero destino": "+191167980952", "duracion": "462", "fecha": "2020-11-10T04:02:45Z"}
+]`), call.DefaultColumns()),
+		"ndjson": call.FromNDJSON(strings.NewReader(`{"source": "+5491167980950", "destination": "+191167980952", "duration": 462, "date": "2020-11-10T04:02:45Z", "id": 1}
+
+{"numero origen": "+5491167980950", "numero destino": "+191167980952", "duracion": "462", "fecha": "2020-11-10T04:02:45Z"}`), call.DefaultColumns()),
+	}
+
+	for name, source := range sources {
+		t.Run(name, func(t *testing.T) {
+			for i := 0; i < 2; i++ {
+				c, err := source.Next()
+				require.NoError(t, err)
+				assert.Equal(t, expected, c)
+			}
+
+			_, err := source.Next()
+			assert.Equal(t, io.EOF, err)
+		})
+	}
+}
+
+func TestInvalidJSONCalls(t *testing.T) {
+	tests := []struct {
+		name   string
+		source call.Source
+		calls  int // Valid ones before the error
+		err    string
+	}{
+		{
+			name:   "not an array",
+			source: call.FromJSON(strings.NewReader(`{"source": "+5491167980950"}`), call.DefaultColumns()),
+			err:    "expected an array of calls",
+		},
+		{
+			name:   "missing field",
+			source: call.FromJSON(strings.NewReader(`[{"source": "+5491167980950", "destination": "+191167980952", "date": "2020-11-10T04:02:45Z"}]`), call.DefaultColumns()),
+			err:    `line 1: missing duration field, expected one named "duracion" or "duración" or "duration"`,
+		},
+		{
+			name: "invalid call of an array",
+			source: call.FromJSON(strings.NewReader(`[
+  {"source": "+5491167980950", "destination": "+191167980952", "duration": 1, "date": "2020-11-10T04:02:45Z"},
+
+  {
+    "source": "+5491167980950", "destination": "+191167980952", "duration": -1, "date": "2020-11-10T04:02:45Z"
+  }
+]`), call.DefaultColumns()),
+			calls: 1,
+			err:   `line 4: parsing duration: strconv.ParseUint: parsing "-1": invalid syntax`,
+		},
+		{
+			name: "invalid json of an array",
+			source: call.FromJSON(strings.NewReader(`[
+  {"source": "+5491167980950", "destination": "+191167980952", "duration": 1, "date": "2020-11-10T04:02:45Z"},
+  {"source": "+5491167980950" "destination": "+191167980952"}
+]`), call.DefaultColumns()),
+			calls: 1,
+			err:   `line 3: invalid character '"' after object key:value pair`,
+		},
+		{
+			name:   "invalid call",
+			source: call.FromNDJSON(strings.NewReader("\n"+`{"source": "5491167980950", "destination": "+191167980952", "duration": 1, "date": "2020-11-10T04:02:45Z"}`), call.DefaultColumns()),
+			err:    `line 2: source phone: invalid number "5491167980950": must start with +`,
+		},
+		{
+			name:   "invalid json",
+			source: call.FromNDJSON(strings.NewReader(`{"source": `), call.DefaultColumns()),
+			err:    "line 1: unexpected end of JSON input",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			for i := 0; i < tt.calls; i++ {
+				_, err := tt.source.Next()
+				require.NoError(t, err)
+			}
+
+			_, err := tt.source.Next()
+			assert.EqualError(t, err, tt.err)
+		})
+	}
+}
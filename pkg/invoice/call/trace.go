@@ -0,0 +1,125 @@
+package call
+
+import (
+	"invoice-generator/pkg/invoice/tariff"
+	"invoice-generator/pkg/platform/money"
+	"invoice-generator/pkg/platform/timeutil"
+)
+
+// A Trace explains how a call was rated, so that disputed charges can be
+// audited. The processor only traces calls when tracing is enabled (see
+// Processor.EnableTracing).
+type Trace struct {
+	// Types are the IDs of the types of the call (e.g. friends, national)
+	Types []string `json:"types"`
+
+	Characteristics []string `json:"characteristics,omitempty"`
+
+	// TariffEffectiveFrom is the date (AAAA-MM-DD) since which the version of
+	// the tariff the call was rated with is effective, empty if it always was.
+	TariffEffectiveFrom string `json:"tariff_effective_from,omitempty"`
+
+	// BaseCost is the cost according to the tariff, in its time band, before
+	// any promotions.
+	BaseCost money.Money `json:"base_cost"`
+
+	// Allowances are the ones that covered billed seconds of the call, in
+	// order, before any promotion was evaluated.
+	Allowances []AllowanceTrace `json:"allowances,omitempty"`
+
+	// Overage is the cost of the billed seconds the allowances didn't cover,
+	// which is what promotions apply to. Nil if no allowance covered any.
+	Overage *money.Money `json:"overage,omitempty"`
+
+	// Promotions are the ones that were evaluated, in order. Promotions after
+	// an exclusive one that applies aren't evaluated.
+	Promotions []PromotionTrace `json:"promotions,omitempty"`
+
+	// Amount is the final cost of the call
+	Amount money.Money `json:"amount"`
+}
+
+// A PromotionTrace is how a promotion was evaluated for a call.
+type PromotionTrace struct {
+	Name    string `json:"name"`
+	Policy  string `json:"policy"`
+	Applies bool   `json:"applies"`
+
+	// Amount is the cost of the call with the promotion, nil if it doesn't
+	// apply.
+	Amount *money.Money `json:"amount,omitempty"`
+
+	// Used is whether the promotion contributed to the final cost
+	Used bool `json:"used"`
+}
+
+// An AllowanceTrace is how much of an allowance a call consumed.
+type AllowanceTrace struct {
+	Name    string `json:"name"`
+	Covered uint   `json:"covered"` // Billed seconds
+}
+
+// typeIDs collects the IDs of the types a call registers its duration with.
+type typeIDs []string
+
+func (t *typeIDs) Register(typeID string, _ uint) {
+	*t = append(*t, typeID)
+}
+
+// newTrace starts the trace of a call of the type, rated with the version of
+// the tariff.
+func newTrace(callType Type, baseCost money.Money, version tariff.Version) *Trace {
+	var ids typeIDs
+	callType.RegisterDuration(0, &ids)
+
+	trace := &Trace{Types: ids, BaseCost: baseCost}
+	if !version.EffectiveFrom.IsZero() {
+		trace.TariffEffectiveFrom = version.EffectiveFrom.Format(timeutil.LayoutDate)
+	}
+
+	for _, characteristic := range characteristics {
+		if callType.HasCharacteristic(characteristic) {
+			trace.Characteristics = append(trace.Characteristics, characteristic.String())
+		}
+	}
+
+	return trace
+}
+
+// evaluated adds the promotion to the trace, with the cost of the call with it
+// (nil if it doesn't apply). Does nothing on a nil trace, so calls don't need
+// to check whether tracing is enabled.
+func (t *Trace) evaluated(promo Promotion, amount *money.Money) {
+	if t == nil {
+		return
+	}
+
+	evaluation := PromotionTrace{Name: promo.Name(), Policy: promo.Policy().String(), Applies: amount != nil}
+	if amount != nil {
+		a := *amount
+		evaluation.Amount = &a
+	}
+
+	t.Promotions = append(t.Promotions, evaluation)
+}
+
+// covered adds the billed seconds the allowance covered to the trace. Does
+// nothing on a nil trace, like evaluated.
+func (t *Trace) covered(allowance *Allowance, seconds uint) {
+	if t == nil {
+		return
+	}
+
+	t.Allowances = append(t.Allowances, AllowanceTrace{Name: allowance.Name(), Covered: seconds})
+}
+
+// markUsed marks the promotions with the names as used.
+func (t *Trace) markUsed(names []string) {
+	for i := range t.Promotions {
+		for _, name := range names {
+			if t.Promotions[i].Name == name {
+				t.Promotions[i].Used = true
+			}
+		}
+	}
+}
@@ -0,0 +1,266 @@
+// Package charge implements the charges of users that aren't calls, like the
+// subscription fee of their plan or the replacement of a SIM. They are declared
+// in a configuration file, like tariffs.
+package charge
+
+import (
+	"fmt"
+	"invoice-generator/pkg/invoice/tariff"
+	"invoice-generator/pkg/platform/config"
+	"invoice-generator/pkg/platform/money"
+	"invoice-generator/pkg/platform/phone"
+	"invoice-generator/pkg/platform/sliceutil"
+	"invoice-generator/pkg/platform/timeutil"
+	"time"
+)
+
+// Types of charges
+const (
+	TypeRecurring = "recurring"
+	TypeOneOff    = "one_off"
+)
+
+// Charges are the charges of each user, by phone number.
+//
+// Example file:
+//
+//	currency: ARS # of the prices, defaults to ARS
+//	users:
+//	  "+5491167950940":
+//	    recurring:
+//	      - {description: Plan básico, unit_price: 1500}
+//	      - {description: Línea adicional, unit_price: 300, quantity: 2}
+//	    one_off:
+//	      - {description: Reemplazo de SIM, unit_price: 500, date: 2022-11-15}
+//
+// Recurring charges are monthly, and are charged on every invoice (prorated if
+// the billing period is shorter than a month). One-off charges are charged on
+// the invoice whose billing period contains their date.
+type Charges struct {
+	Currency string
+	byUser   map[string]userCharges
+}
+
+type userCharges struct {
+	recurring []Charge
+	oneOff    []Charge
+}
+
+// A Charge is a quantity of something at a unit price.
+type Charge struct {
+	Description string
+	UnitPrice   money.Money
+	Quantity    uint
+	Date        time.Time // Only for one-off charges
+}
+
+// A Line is a charge of a user on an invoice.
+type Line struct {
+	Type   string // TypeRecurring or TypeOneOff
+	Charge Charge
+
+	// Amount is the unit price times the quantity, prorated if needed
+	Amount money.Money
+
+	// Proration is set when a recurring charge was prorated
+	Proration *Proration
+}
+
+// Proration is the part of the month a recurring charge was charged for.
+type Proration struct {
+	Days      uint
+	MonthDays uint
+}
+
+type fileCharge struct {
+	Description string        `yaml:"description"`
+	UnitPrice   money.Decimal `yaml:"unit_price"`
+	Quantity    uint          `yaml:"quantity"` // Defaults to 1
+	Date        string        `yaml:"date"`
+}
+
+// Load loads the charges from the content of a charges file.
+func Load(content []byte) (Charges, error) {
+	var file struct {
+		Currency string `yaml:"currency"`
+		Users    map[string]struct {
+			Recurring []fileCharge `yaml:"recurring"`
+			OneOff    []fileCharge `yaml:"one_off"`
+		} `yaml:"users"`
+	}
+
+	doc, err := config.Decode(content, &file)
+	if err != nil {
+		return Charges{}, err
+	}
+
+	charges := Charges{Currency: file.Currency, byUser: make(map[string]userCharges)}
+	if charges.Currency == "" {
+		charges.Currency = tariff.DefaultCurrency
+	}
+
+	if err := money.ValidateCurrency(charges.Currency); err != nil {
+		return Charges{}, config.Errorf(doc.Line("currency"), "%s", err)
+	}
+
+	for _, phoneNumber := range sliceutil.SortedKeys(file.Users) {
+		if _, err := phone.Parse(phoneNumber); err != nil {
+			return Charges{}, config.Errorf(doc.Line("users", phoneNumber), "%s", err)
+		}
+
+		var user userCharges
+		fileUser := file.Users[phoneNumber]
+		for i, c := range fileUser.Recurring {
+			charge, err := c.load(doc, charges.Currency, TypeRecurring, "users", phoneNumber, "recurring", i)
+			if err != nil {
+				return Charges{}, err
+			}
+
+			user.recurring = append(user.recurring, charge)
+		}
+
+		for i, c := range fileUser.OneOff {
+			charge, err := c.load(doc, charges.Currency, TypeOneOff, "users", phoneNumber, "one_off", i)
+			if err != nil {
+				return Charges{}, err
+			}
+
+			user.oneOff = append(user.oneOff, charge)
+		}
+
+		charges.byUser[phoneNumber] = user
+	}
+
+	return charges, nil
+}
+
+func (c fileCharge) load(doc config.Document, currency, chargeType string, path ...interface{}) (Charge, error) {
+	line := func(elems ...interface{}) int {
+		return doc.Line(append(path, elems...)...)
+	}
+
+	if c.Description == "" {
+		return Charge{}, config.Errorf(line(), "charge must have a description")
+	}
+
+	if c.UnitPrice.Sign() <= 0 {
+		return Charge{}, config.Errorf(line("unit_price"), "charge %q must have a positive unit price", c.Description)
+	}
+
+	charge := Charge{
+		Description: c.Description,
+		UnitPrice:   money.FromDecimal(c.UnitPrice, currency),
+		Quantity:    c.Quantity,
+	}
+
+	if charge.Quantity == 0 {
+		charge.Quantity = 1
+	}
+
+	if chargeType == TypeRecurring {
+		if c.Date != "" {
+			return Charge{}, config.Errorf(line("date"), "recurring charge %q can't have a date", c.Description)
+		}
+
+		return charge, nil
+	}
+
+	date, err := time.Parse(timeutil.LayoutDate, c.Date)
+	if err != nil {
+		return Charge{}, config.Errorf(line("date"), "invalid date %q, expected AAAA-MM-DD", c.Date)
+	}
+
+	charge.Date = date
+	return charge, nil
+}
+
+// For returns the lines of the charges of the user on the invoice of the
+// billing period: recurring charges first, and then the one-off charges made
+// in the period. The dates of one-off charges are days in the location of the
+// billing period. It returns an error if the total of a charge is out of range.
+func (c Charges) For(phoneNumber string, billingPeriod timeutil.Period) ([]Line, error) {
+	user := c.byUser[phoneNumber]
+
+	var lines []Line
+	for _, charge := range user.recurring {
+		total, err := charge.total()
+		if err != nil {
+			return nil, err
+		}
+
+		amount, proration := prorate(total, billingPeriod)
+		lines = append(lines, Line{Type: TypeRecurring, Charge: charge, Amount: amount, Proration: proration})
+	}
+
+	for _, charge := range user.oneOff {
+		if !billingPeriod.Contains(timeutil.Midnight(charge.Date, billingPeriod.Location())) {
+			continue
+		}
+
+		total, err := charge.total()
+		if err != nil {
+			return nil, err
+		}
+
+		lines = append(lines, Line{Type: TypeOneOff, Charge: charge, Amount: total})
+	}
+
+	return lines, nil
+}
+
+// total returns the unit price times the quantity.
+func (c Charge) total() (money.Money, error) {
+	quantity, err := money.IntDecimal(int64(c.Quantity))
+	if err != nil {
+		return money.Money{}, fmt.Errorf("charge %q: %s", c.Description, err)
+	}
+
+	total, err := c.UnitPrice.Mul(quantity)
+	if err != nil {
+		return money.Money{}, fmt.Errorf("charge %q: %s", c.Description, err)
+	}
+
+	return total, nil
+}
+
+// prorate returns the part of the monthly amount corresponding to the days of
+// the billing period, out of the days of the month that starts with it. Periods
+// of a month or more are charged in full, so whole billing cycles never are
+// prorated.
+func prorate(monthly money.Money, billingPeriod timeutil.Period) (money.Money, *Proration) {
+	monthDays := days(billingPeriod.Start, monthAfter(billingPeriod.Start))
+	periodDays := days(billingPeriod.Start, billingPeriod.End)
+	if periodDays < 0 {
+		periodDays = 0
+	}
+
+	if periodDays >= monthDays {
+		return monthly, nil
+	}
+
+	return monthly.Prorate(periodDays, monthDays), &Proration{Days: uint(periodDays), MonthDays: uint(monthDays)}
+}
+
+// monthAfter returns the same day of the next month, or its last day if it
+// doesn't have that day (like timeutil.Cycle does), so the month after the 31st
+// of January is the 28th of February rather than the 3rd of March.
+func monthAfter(t time.Time) time.Time {
+	next := t.AddDate(0, 1, 0)
+	if next.Day() != t.Day() {
+		// Normalized into the month after, go back to the end of the previous one
+		next = next.AddDate(0, 0, -next.Day())
+	}
+
+	return next
+}
+
+// days returns the calendar days between the dates of the times, in the
+// location of from. Days are counted by date rather than by 24 hours, since
+// they can be shorter or longer when the clocks change.
+func days(from, to time.Time) int64 {
+	to = to.In(from.Location())
+	fromDate := time.Date(from.Year(), from.Month(), from.Day(), 0, 0, 0, 0, time.UTC)
+	toDate := time.Date(to.Year(), to.Month(), to.Day(), 0, 0, 0, 0, time.UTC)
+
+	return int64(toDate.Sub(fromDate) / (24 * time.Hour))
+}
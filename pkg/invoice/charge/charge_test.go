@@ -0,0 +1,208 @@
+package charge_test
+
+import (
+	"invoice-generator/pkg/invoice/charge"
+	"invoice-generator/pkg/platform/money"
+	"invoice-generator/pkg/platform/timeutil"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+const (
+	phone   = "+5491167950940"
+	charges = `
+users:
+  "+5491167950940":
+    recurring:
+      - {description: Plan básico, unit_price: 1500}
+      - {description: Línea adicional, unit_price: 300, quantity: 2}
+    one_off:
+      - {description: Reemplazo de SIM, unit_price: 500, date: 2022-11-15}
+      - {description: Cambio de número, unit_price: 200, date: 2022-12-15}
+`
+)
+
+func ars(amount string) money.Money {
+	return money.MustParse(amount, "ARS")
+}
+
+func date(year int, month time.Month, day int) time.Time {
+	return time.Date(year, month, day, 0, 0, 0, 0, time.UTC)
+}
+
+func TestChargesOfAFullMonth(t *testing.T) {
+	loaded, err := charge.Load([]byte(charges))
+	require.NoError(t, err)
+
+	november := timeutil.Period{Start: date(2022, time.November, 1), End: date(2022, time.December, 1)}
+
+	expected := []charge.Line{
+		{
+			Type:   charge.TypeRecurring,
+			Charge: charge.Charge{Description: "Plan básico", UnitPrice: ars("1500"), Quantity: 1},
+			Amount: ars("1500"),
+		},
+		{
+			Type:   charge.TypeRecurring,
+			Charge: charge.Charge{Description: "Línea adicional", UnitPrice: ars("300"), Quantity: 2},
+			Amount: ars("600"),
+		},
+		{
+			Type:   charge.TypeOneOff,
+			Charge: charge.Charge{Description: "Reemplazo de SIM", UnitPrice: ars("500"), Quantity: 1, Date: date(2022, time.November, 15)},
+			Amount: ars("500"),
+		},
+	}
+	lines, err := loaded.For(phone, november)
+	require.NoError(t, err)
+	assert.Equal(t, expected, lines)
+}
+
+func TestRecurringChargesAreProratedOnPartialPeriods(t *testing.T) {
+	loaded, err := charge.Load([]byte(charges))
+	require.NoError(t, err)
+
+	// 10 of the 30 days of November
+	partial := timeutil.Period{Start: date(2022, time.November, 20), End: date(2022, time.November, 30)}
+
+	lines, err := loaded.For(phone, partial)
+	require.NoError(t, err)
+	require.Len(t, lines, 2, "the one-off charges are outside of the period")
+
+	assert.Equal(t, ars("500"), lines[0].Amount)
+	assert.Equal(t, &charge.Proration{Days: 10, MonthDays: 30}, lines[0].Proration)
+	assert.Equal(t, ars("200"), lines[1].Amount)
+}
+
+func TestWholeCyclesAcrossFebruaryAreNotProrated(t *testing.T) {
+	loaded, err := charge.Load([]byte(charges))
+	require.NoError(t, err)
+
+	for _, year := range []int{2023, 2024} {
+		for _, anchorDay := range []int{29, 30, 31} {
+			cycle, err := timeutil.NewCycle(anchorDay, time.UTC)
+			require.NoError(t, err)
+
+			for _, month := range []time.Month{time.January, time.February} {
+				period := cycle.Starting(year, month)
+
+				lines, err := loaded.For(phone, period)
+				require.NoError(t, err)
+				require.NotEmpty(t, lines)
+				assert.Equal(t, ars("1500"), lines[0].Amount, "day %d, %s %d", anchorDay, month, year)
+				assert.Nil(t, lines[0].Proration, "day %d, %s %d", anchorDay, month, year)
+			}
+		}
+	}
+}
+
+func TestPartialPeriodsStartingOnMonthEndsAreProratedByTheNextMonth(t *testing.T) {
+	loaded, err := charge.Load([]byte(charges))
+	require.NoError(t, err)
+
+	// The month after the 31st of January ends on the 28th of February
+	partial := timeutil.Period{Start: date(2023, time.January, 31), End: date(2023, time.February, 14)}
+
+	lines, err := loaded.For(phone, partial)
+	require.NoError(t, err)
+	require.NotEmpty(t, lines)
+	assert.Equal(t, &charge.Proration{Days: 14, MonthDays: 28}, lines[0].Proration)
+	assert.Equal(t, ars("750"), lines[0].Amount)
+}
+
+func TestChargesAreInTheTimeZoneOfTheBillingPeriod(t *testing.T) {
+	loaded, err := charge.Load([]byte(charges))
+	require.NoError(t, err)
+
+	newYork, err := time.LoadLocation("America/New_York")
+	require.NoError(t, err)
+
+	// The 6th of November is 25 hours long, and the one-off charge of the 15th
+	// starts at 05:00 UTC.
+	partial := timeutil.Days(date(2022, time.November, 1), date(2022, time.November, 15), newYork)
+
+	lines, err := loaded.For(phone, partial)
+	require.NoError(t, err)
+	require.Len(t, lines, 3)
+	assert.Equal(t, &charge.Proration{Days: 15, MonthDays: 30}, lines[0].Proration)
+	assert.Equal(t, ars("750"), lines[0].Amount)
+	assert.Equal(t, "Reemplazo de SIM", lines[2].Charge.Description)
+}
+
+func TestUsersWithoutChargesHaveNoLines(t *testing.T) {
+	loaded, err := charge.Load([]byte(charges))
+	require.NoError(t, err)
+
+	november := timeutil.Period{Start: date(2022, time.November, 1), End: date(2022, time.December, 1)}
+	lines, err := loaded.For("+5491111111111", november)
+	require.NoError(t, err)
+	assert.Empty(t, lines)
+
+	lines, err = charge.Charges{}.For(phone, november)
+	require.NoError(t, err)
+	assert.Empty(t, lines)
+}
+
+func TestLoadErrors(t *testing.T) {
+	tests := []struct {
+		name    string
+		content string
+		err     string
+	}{
+		{
+			name: "invalid phone number",
+			content: `users:
+  "5491167950940":
+    recurring:
+      - {description: Plan, unit_price: 1}`,
+			err: `line 3: invalid number "5491167950940": must start with +`,
+		},
+		{
+			name: "missing description",
+			content: `users:
+  "+5491167950940":
+    recurring:
+      - {unit_price: 1}`,
+			err: `line 4: charge must have a description`,
+		},
+		{
+			name: "without price",
+			content: `users:
+  "+5491167950940":
+    recurring:
+      - description: Plan`,
+			err: `line 4: charge "Plan" must have a positive unit price`,
+		},
+		{
+			name: "recurring with date",
+			content: `users:
+  "+5491167950940":
+    recurring:
+      - {description: Plan, unit_price: 1, date: 2022-11-01}`,
+			err: `line 4: recurring charge "Plan" can't have a date`,
+		},
+		{
+			name: "one-off without date",
+			content: `users:
+  "+5491167950940":
+    one_off:
+      - {description: SIM, unit_price: 1}`,
+			err: `line 4: invalid date "", expected AAAA-MM-DD`,
+		},
+		{
+			name:    "invalid currency",
+			content: `currency: pesos`,
+			err:     `line 1: invalid currency "pesos", should be an ISO 4217 code (e.g. ARS)`,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := charge.Load([]byte(tt.content))
+			assert.EqualError(t, err, tt.err)
+		})
+	}
+}
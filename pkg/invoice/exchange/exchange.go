@@ -0,0 +1,191 @@
+// Package exchange converts amounts of money between currencies, using a table
+// of dated exchange rates declared in a configuration file.
+package exchange
+
+import (
+	"fmt"
+	"invoice-generator/pkg/platform/config"
+	"invoice-generator/pkg/platform/money"
+	"invoice-generator/pkg/platform/timeutil"
+	"sort"
+	"time"
+)
+
+// Rates is a table of exchange rates. Each rate is effective from the start of
+// its date until the date of the next rate of the same currencies. Dates are
+// days in the location of the billing period (see Rates.In).
+//
+// Example file:
+//
+//	rates:
+//	  - {date: 2022-11-01, from: USD, to: ARS, rate: 160.25}
+//	  - {date: 2022-11-15, from: USD, to: ARS, rate: 165.5}
+//
+// Rates only convert in the declared direction, to convert from ARS to USD a
+// rate from ARS to USD must be declared.
+type Rates struct {
+	byPair   map[pair][]Rate // Sorted by date
+	location *time.Location  // Of the dates, nil is UTC
+}
+
+// A Rate is how many units of To are worth one unit of From, from Date on.
+type Rate struct {
+	Date time.Time
+	From string
+	To   string
+	Rate money.Decimal
+}
+
+// A Conversion is an amount converted to another currency.
+type Conversion struct {
+	Original  money.Money
+	Converted money.Money
+	Rate      Rate
+}
+
+type pair struct {
+	from, to string
+}
+
+// New returns a table with the specified rates.
+func New(rates ...Rate) Rates {
+	byPair := make(map[pair][]Rate)
+	for _, rate := range rates {
+		key := pair{from: rate.From, to: rate.To}
+		byPair[key] = append(byPair[key], rate)
+	}
+
+	for _, pairRates := range byPair {
+		sort.Slice(pairRates, func(i, j int) bool {
+			return pairRates[i].Date.Before(pairRates[j].Date)
+		})
+	}
+
+	return Rates{byPair: byPair}
+}
+
+// Load loads the rates from the content of a rates file.
+func Load(content []byte) (Rates, error) {
+	var file struct {
+		Rates []struct {
+			Date string        `yaml:"date"`
+			From string        `yaml:"from"`
+			To   string        `yaml:"to"`
+			Rate money.Decimal `yaml:"rate"`
+		} `yaml:"rates"`
+	}
+
+	doc, err := config.Decode(content, &file)
+	if err != nil {
+		return Rates{}, err
+	}
+
+	seen := make(map[pair]map[time.Time]bool)
+	var rates []Rate
+	for i, r := range file.Rates {
+		line := func(key string) int { return doc.Line("rates", i, key) }
+
+		date, err := time.Parse(timeutil.LayoutDate, r.Date)
+		if err != nil {
+			return Rates{}, config.Errorf(line("date"), "invalid date %q, expected AAAA-MM-DD", r.Date)
+		}
+
+		if err := money.ValidateCurrency(r.From); err != nil {
+			return Rates{}, config.Errorf(line("from"), "%s", err)
+		}
+
+		if err := money.ValidateCurrency(r.To); err != nil {
+			return Rates{}, config.Errorf(line("to"), "%s", err)
+		}
+
+		if r.From == r.To {
+			return Rates{}, config.Errorf(line("to"), "can't convert %s to itself", r.From)
+		}
+
+		if r.Rate.Sign() <= 0 {
+			return Rates{}, config.Errorf(line("rate"), "rate must be positive")
+		}
+
+		key := pair{from: r.From, to: r.To}
+		if seen[key] == nil {
+			seen[key] = make(map[time.Time]bool)
+		}
+
+		if seen[key][date] {
+			return Rates{}, config.Errorf(doc.Line("rates", i), "duplicated rate from %s to %s on %s", r.From, r.To, r.Date)
+		}
+		seen[key][date] = true
+
+		rates = append(rates, Rate{Date: date, From: r.From, To: r.To, Rate: r.Rate})
+	}
+
+	return New(rates...), nil
+}
+
+// In returns the table with the rates effective from the start of their dates
+// in the location, rather than in UTC.
+func (r Rates) In(loc *time.Location) Rates {
+	byPair := make(map[pair][]Rate, len(r.byPair))
+	for key, rates := range r.byPair {
+		local := make([]Rate, len(rates))
+		for i, rate := range rates {
+			rate.Date = timeutil.Midnight(rate.Date, loc)
+			local[i] = rate
+		}
+
+		byPair[key] = local
+	}
+
+	return Rates{byPair: byPair, location: loc}
+}
+
+// Convert converts the amount to the currency using the rate effective at the
+// specified time, rounding to the minor units of the currency.
+func (r Rates) Convert(amount money.Money, currency string, at time.Time) (Conversion, error) {
+	rate, err := r.Effective(amount.Currency(), currency, at)
+	if err != nil {
+		return Conversion{}, err
+	}
+
+	original, err := amount.Decimal()
+	if err != nil {
+		return Conversion{}, err
+	}
+
+	converted, err := original.Mul(rate.Rate)
+	if err != nil {
+		return Conversion{}, fmt.Errorf("converting %s to %s: %s", amount, currency, err)
+	}
+
+	return Conversion{
+		Original:  amount,
+		Converted: money.FromDecimal(converted, currency),
+		Rate:      rate,
+	}, nil
+}
+
+// Effective returns the rate from one currency to the other that is effective
+// at the specified time: the latest one whose date isn't after it.
+func (r Rates) Effective(from, to string, at time.Time) (Rate, error) {
+	rates := r.byPair[pair{from: from, to: to}]
+
+	// First rate that isn't effective yet, the previous one is the effective
+	i := sort.Search(len(rates), func(i int) bool {
+		return rates[i].Date.After(at)
+	})
+
+	if i == 0 {
+		return Rate{}, fmt.Errorf("no exchange rate from %s to %s on %s", from, to, at.In(r.loc()).Format(timeutil.LayoutDate))
+	}
+
+	return rates[i-1], nil
+}
+
+// loc returns the location of the dates of the rates.
+func (r Rates) loc() *time.Location {
+	if r.location == nil {
+		return time.UTC
+	}
+
+	return r.location
+}
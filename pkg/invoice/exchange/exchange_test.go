@@ -0,0 +1,131 @@
+package exchange_test
+
+import (
+	"invoice-generator/pkg/invoice/exchange"
+	"invoice-generator/pkg/platform/money"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+const rates = `
+rates:
+  - {date: 2022-11-15, from: USD, to: ARS, rate: 165.5}
+  - {date: 2022-11-01, from: USD, to: ARS, rate: 160.25}
+  - {date: 2022-11-01, from: ARS, to: USD, rate: 0.00625}
+`
+
+func TestConvertUsesTheRateEffectiveOnTheDate(t *testing.T) {
+	loaded, err := exchange.Load([]byte(rates))
+	require.NoError(t, err)
+
+	tests := []struct {
+		name     string
+		at       time.Time
+		expected string
+	}{
+		{
+			name:     "first day of a rate",
+			at:       time.Date(2022, time.November, 1, 0, 0, 0, 0, time.UTC),
+			expected: "160.25",
+		},
+		{
+			name:     "last moment before the next rate",
+			at:       time.Date(2022, time.November, 14, 23, 59, 59, 0, time.UTC),
+			expected: "160.25",
+		},
+		{
+			name:     "latest rate",
+			at:       time.Date(2023, time.January, 1, 0, 0, 0, 0, time.UTC),
+			expected: "165.5",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			conversion, err := loaded.Convert(money.MustParse("1", "USD"), "ARS", tt.at)
+			require.NoError(t, err)
+
+			assert.Equal(t, money.MustParse(tt.expected, "ARS"), conversion.Converted)
+			assert.Equal(t, money.MustParse("1", "USD"), conversion.Original)
+			assert.Equal(t, money.MustParseDecimal(tt.expected), conversion.Rate.Rate)
+		})
+	}
+}
+
+func TestConvertRoundsToTheCurrency(t *testing.T) {
+	loaded, err := exchange.Load([]byte(rates))
+	require.NoError(t, err)
+
+	at := time.Date(2022, time.November, 2, 0, 0, 0, 0, time.UTC)
+	conversion, err := loaded.Convert(money.MustParse("2.50", "ARS"), "USD", at)
+	require.NoError(t, err)
+
+	// 2.50 * 0.00625 = 0.015625
+	assert.Equal(t, money.MustParse("0.02", "USD"), conversion.Converted)
+}
+
+func TestConvertWithoutEffectiveRateFails(t *testing.T) {
+	loaded, err := exchange.Load([]byte(rates))
+	require.NoError(t, err)
+
+	before := time.Date(2022, time.October, 31, 23, 0, 0, 0, time.UTC)
+	_, err = loaded.Convert(money.MustParse("1", "USD"), "ARS", before)
+	assert.EqualError(t, err, "no exchange rate from USD to ARS on 2022-10-31")
+
+	after := time.Date(2022, time.November, 20, 0, 0, 0, 0, time.UTC)
+	_, err = loaded.Convert(money.MustParse("1", "EUR"), "ARS", after)
+	assert.EqualError(t, err, "no exchange rate from EUR to ARS on 2022-11-20")
+}
+
+func TestLoadErrors(t *testing.T) {
+	tests := []struct {
+		name    string
+		content string
+		err     string
+	}{
+		{
+			name: "invalid date",
+			content: `rates:
+  - {date: 01/11/2022, from: USD, to: ARS, rate: 160}`,
+			err: `line 2: invalid date "01/11/2022", expected AAAA-MM-DD`,
+		},
+		{
+			name: "invalid currency",
+			content: `rates:
+  - date: 2022-11-01
+    from: USD
+    to: pesos
+    rate: 160`,
+			err: `line 4: invalid currency "pesos", should be an ISO 4217 code (e.g. ARS)`,
+		},
+		{
+			name: "same currency",
+			content: `rates:
+  - {date: 2022-11-01, from: USD, to: USD, rate: 1}`,
+			err: `line 2: can't convert USD to itself`,
+		},
+		{
+			name: "negative rate",
+			content: `rates:
+  - {date: 2022-11-01, from: USD, to: ARS, rate: -160}`,
+			err: `line 2: rate must be positive`,
+		},
+		{
+			name: "duplicated rate",
+			content: `rates:
+  - {date: 2022-11-01, from: USD, to: ARS, rate: 160}
+  - {date: 2022-11-01, from: USD, to: ARS, rate: 161}`,
+			err: `line 3: duplicated rate from USD to ARS on 2022-11-01`,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := exchange.Load([]byte(tt.content))
+			assert.EqualError(t, err, tt.err)
+		})
+	}
+}
@@ -0,0 +1,100 @@
+// Package holiday implements the calendar of holidays of each country, which
+// change the cost of calls made on them. It's declared in a configuration file,
+// like tariffs.
+package holiday
+
+import (
+	"invoice-generator/pkg/platform/config"
+	"invoice-generator/pkg/platform/sliceutil"
+	"invoice-generator/pkg/platform/timeutil"
+	"regexp"
+	"time"
+)
+
+// A Calendar has the holidays of each country, by ISO 3166-1 alpha-2 code.
+//
+// Example file:
+//
+//	countries:
+//	  AR:
+//	    - {date: 2022-11-20, name: Día de la Soberanía Nacional}
+//	    - {date: 2022-12-25, name: Navidad}
+//
+// Holidays are whole days, so whether a call was made on one depends on the
+// time zone it's resolved in (see On).
+type Calendar struct {
+	byCountry map[string]map[date]string // Names of the holidays by date
+}
+
+// A date is a day, without a time zone.
+type date struct {
+	year  int
+	month time.Month
+	day   int
+}
+
+var regionFormat = regexp.MustCompile(`^[A-Z]{2}$`)
+
+// Load loads the calendar from the content of a holidays file.
+func Load(content []byte) (Calendar, error) {
+	var file struct {
+		Countries map[string][]struct {
+			Date string `yaml:"date"`
+			Name string `yaml:"name"`
+		} `yaml:"countries"`
+	}
+
+	doc, err := config.Decode(content, &file)
+	if err != nil {
+		return Calendar{}, err
+	}
+
+	calendar := Calendar{byCountry: make(map[string]map[date]string)}
+	for _, country := range sliceutil.SortedKeys(file.Countries) {
+		if !regionFormat.MatchString(country) {
+			return Calendar{}, config.Errorf(
+				doc.Line("countries", country),
+				"invalid country %q, should be an ISO 3166-1 alpha-2 code (e.g. AR)", country,
+			)
+		}
+
+		holidays := make(map[date]string)
+		for i, h := range file.Countries[country] {
+			line := func(elems ...interface{}) int {
+				return doc.Line(append([]interface{}{"countries", country, i}, elems...)...)
+			}
+
+			day, err := time.Parse(timeutil.LayoutDate, h.Date)
+			if err != nil {
+				return Calendar{}, config.Errorf(line("date"), "invalid date %q, expected AAAA-MM-DD", h.Date)
+			}
+
+			if h.Name == "" {
+				return Calendar{}, config.Errorf(line(), "holiday on %s must have a name", h.Date)
+			}
+
+			d := dateOf(day)
+			if _, ok := holidays[d]; ok {
+				return Calendar{}, config.Errorf(line(), "duplicated holiday of %s on %s", country, h.Date)
+			}
+
+			holidays[d] = h.Name
+		}
+
+		calendar.byCountry[country] = holidays
+	}
+
+	return calendar, nil
+}
+
+// On returns the name of the holiday of the country on the date of the
+// specified time, in its location. For example, 2022-12-25T01:00:00Z is a
+// holiday in Spain (UTC+1) but not in Argentina (UTC-3).
+func (c Calendar) On(country string, t time.Time) (name string, ok bool) {
+	name, ok = c.byCountry[country][dateOf(t)]
+	return name, ok
+}
+
+func dateOf(t time.Time) date {
+	return date{year: t.Year(), month: t.Month(), day: t.Day()}
+}
@@ -0,0 +1,102 @@
+package holiday_test
+
+import (
+	"invoice-generator/pkg/invoice/holiday"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+const holidays = `
+countries:
+  AR:
+    - {date: 2022-11-20, name: Día de la Soberanía Nacional}
+    - {date: 2022-12-25, name: Navidad}
+  ES:
+    - {date: 2022-12-25, name: Navidad}
+`
+
+func TestHolidaysOfEachCountry(t *testing.T) {
+	loaded, err := holiday.Load([]byte(holidays))
+	require.NoError(t, err)
+
+	name, ok := loaded.On("AR", time.Date(2022, time.November, 20, 15, 0, 0, 0, time.UTC))
+	assert.True(t, ok)
+	assert.Equal(t, "Día de la Soberanía Nacional", name)
+
+	_, ok = loaded.On("ES", time.Date(2022, time.November, 20, 15, 0, 0, 0, time.UTC))
+	assert.False(t, ok, "holidays are of a country")
+
+	_, ok = loaded.On("UY", time.Date(2022, time.December, 25, 15, 0, 0, 0, time.UTC))
+	assert.False(t, ok, "countries without holidays")
+
+	_, ok = holiday.Calendar{}.On("AR", time.Date(2022, time.December, 25, 15, 0, 0, 0, time.UTC))
+	assert.False(t, ok, "empty calendar")
+}
+
+func TestHolidaysAreResolvedInTheLocationOfTheTime(t *testing.T) {
+	loaded, err := holiday.Load([]byte(holidays))
+	require.NoError(t, err)
+
+	buenosAires, err := time.LoadLocation("America/Argentina/Buenos_Aires")
+	require.NoError(t, err)
+
+	madrid, err := time.LoadLocation("Europe/Madrid")
+	require.NoError(t, err)
+
+	// Christmas has already started in Madrid, but not in Buenos Aires
+	christmasEve := time.Date(2022, time.December, 24, 23, 30, 0, 0, time.UTC)
+
+	_, ok := loaded.On("ES", christmasEve.In(madrid))
+	assert.True(t, ok)
+
+	_, ok = loaded.On("AR", christmasEve.In(buenosAires))
+	assert.False(t, ok)
+}
+
+func TestLoadErrors(t *testing.T) {
+	tests := []struct {
+		name    string
+		content string
+		err     string
+	}{
+		{
+			name: "invalid country",
+			content: `countries:
+  Argentina:
+    - {date: 2022-12-25, name: Navidad}`,
+			err: `line 3: invalid country "Argentina", should be an ISO 3166-1 alpha-2 code (e.g. AR)`,
+		},
+		{
+			name: "invalid date",
+			content: `countries:
+  AR:
+    - {date: 25/12/2022, name: Navidad}`,
+			err: `line 3: invalid date "25/12/2022", expected AAAA-MM-DD`,
+		},
+		{
+			name: "without name",
+			content: `countries:
+  AR:
+    - date: 2022-12-25`,
+			err: `line 3: holiday on 2022-12-25 must have a name`,
+		},
+		{
+			name: "duplicated holiday",
+			content: `countries:
+  AR:
+    - {date: 2022-12-25, name: Navidad}
+    - {date: 2022-12-25, name: Otra Navidad}`,
+			err: `line 4: duplicated holiday of AR on 2022-12-25`,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := holiday.Load([]byte(tt.content))
+			assert.EqualError(t, err, tt.err)
+		})
+	}
+}
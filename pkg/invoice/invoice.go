@@ -1,19 +1,183 @@
 package invoice
 
 import (
+	"bytes"
+	"encoding/json"
 	"fmt"
 	"invoice-generator/pkg/invoice/call"
+	"invoice-generator/pkg/invoice/charge"
+	"invoice-generator/pkg/invoice/exchange"
+	"invoice-generator/pkg/invoice/holiday"
+	"invoice-generator/pkg/invoice/plan"
+	"invoice-generator/pkg/invoice/promotion"
+	"invoice-generator/pkg/invoice/tariff"
+	"invoice-generator/pkg/invoice/tax"
+	"invoice-generator/pkg/platform/money"
 	"invoice-generator/pkg/platform/timeutil"
 	"invoice-generator/pkg/user"
+	"io"
+	"time"
 )
 
+// Invoice is the invoice of a user. The totals of each type of call are
+// rendered as total_<type>_seconds and total_<type>_billed_seconds (see
+// MarshalJSON).
 type Invoice struct {
-	User                      InvoiceUser   `json:"user"`
-	Calls                     []InvoiceCall `json:"calls"`
-	TotalInternationalSeconds uint          `json:"total_international_seconds"`
-	TotalNationalSeconds      uint          `json:"total_national_seconds"`
-	TotalFriendsSeconds       uint          `json:"total_friends_seconds"`
-	InvoiceTotal              float64       `json:"total"`
+	User          InvoiceUser   `json:"user"`
+	BillingPeriod InvoicePeriod `json:"billing_period"`
+	Currency      string        `json:"currency"` // of all the amounts
+	Calls         []InvoiceCall `json:"calls"`
+
+	// Totals of the real and billed durations, by type of call
+	TotalSeconds       call.TotalCallDurations `json:"-"`
+	TotalBilledSeconds call.TotalCallDurations `json:"-"`
+
+	// Allowances of the plan of the user, and how much of them was consumed
+	Allowances []InvoiceAllowance `json:"allowances,omitempty"`
+
+	// Items are what the user is charged for: the calls of each type, and
+	// their recurring and one-off charges.
+	Items []InvoiceItem `json:"items"`
+
+	// Subtotal is the exact sum of the amounts of the items
+	Subtotal money.Money  `json:"subtotal"`
+	Taxes    []InvoiceTax `json:"taxes,omitempty"`
+
+	// InvoiceTotal is the subtotal plus all the taxes
+	InvoiceTotal money.Money `json:"total"`
+}
+
+// MarshalJSON renders the invoice with a total_<type>_seconds and
+// total_<type>_billed_seconds field for each type of call, after the calls.
+//
+// Nota de diseño: Los totales antes eran un atributo del struct por tipo, y
+// había que tocar Invoice para agregar uno. Ahora se arma el JSON a mano para
+// poder generar los nombres de los campos, y mantener los que ya existían.
+func (i Invoice) MarshalJSON() ([]byte, error) {
+	fields := []jsonField{
+		{key: "user", value: i.User},
+		{key: "billing_period", value: i.BillingPeriod},
+		{key: "currency", value: i.Currency},
+		{key: "calls", value: i.Calls},
+	}
+
+	for _, id := range i.TotalSeconds.IDs() {
+		fields = append(fields, jsonField{key: "total_" + id + "_seconds", value: i.TotalSeconds.Seconds(id)})
+	}
+
+	for _, id := range i.TotalBilledSeconds.IDs() {
+		fields = append(fields, jsonField{key: "total_" + id + "_billed_seconds", value: i.TotalBilledSeconds.Seconds(id)})
+	}
+
+	if len(i.Allowances) > 0 {
+		fields = append(fields, jsonField{key: "allowances", value: i.Allowances})
+	}
+
+	fields = append(fields, jsonField{key: "items", value: i.Items})
+
+	fields = append(fields, jsonField{key: "subtotal", value: i.Subtotal})
+	if len(i.Taxes) > 0 {
+		fields = append(fields, jsonField{key: "taxes", value: i.Taxes})
+	}
+
+	fields = append(fields, jsonField{key: "total", value: i.InvoiceTotal})
+
+	return marshalObject(fields)
+}
+
+// A jsonField is a field of a JSON object.
+type jsonField struct {
+	key   string
+	value interface{}
+}
+
+// marshalObject marshals the fields as a JSON object, in order.
+func marshalObject(fields []jsonField) ([]byte, error) {
+	var buf bytes.Buffer
+	buf.WriteByte('{')
+
+	for n, field := range fields {
+		if n > 0 {
+			buf.WriteByte(',')
+		}
+
+		key, err := json.Marshal(field.key)
+		if err != nil {
+			return nil, err
+		}
+
+		value, err := json.Marshal(field.value)
+		if err != nil {
+			return nil, fmt.Errorf("%s: %w", field.key, err)
+		}
+
+		buf.Write(key)
+		buf.WriteByte(':')
+		buf.Write(value)
+	}
+
+	buf.WriteByte('}')
+	return buf.Bytes(), nil
+}
+
+// ItemTypeCalls is the type of the items of calls. Charges have the types of
+// the charge package (e.g. charge.TypeRecurring).
+const ItemTypeCalls = "calls"
+
+// InvoiceItem is a line of the invoice.
+type InvoiceItem struct {
+	Type        string `json:"type"`
+	Description string `json:"description"`
+	Quantity    uint   `json:"quantity"`
+
+	// UnitPrice is not set for calls, since each one has its own price
+	UnitPrice *money.Money `json:"unit_price,omitempty"`
+	Amount    money.Money  `json:"amount"`
+
+	// Proration is set when a recurring charge was prorated because the
+	// billing period is shorter than a month.
+	Proration *InvoiceProration `json:"proration,omitempty"`
+
+	// Conversion is set when the charge is in a different currency than the
+	// invoice, and UnitPrice and Amount are the converted ones.
+	Conversion *InvoiceConversion `json:"conversion,omitempty"`
+}
+
+// InvoiceProration is the part of the month a recurring charge was charged
+// for.
+type InvoiceProration struct {
+	Days      uint `json:"days"`
+	MonthDays uint `json:"month_days"`
+}
+
+// InvoiceAllowance is an allowance of the plan of the user, in seconds.
+type InvoiceAllowance struct {
+	CallType  string `json:"call_type"`
+	Included  uint   `json:"included_seconds"`
+	Consumed  uint   `json:"consumed_seconds"`
+	Remaining uint   `json:"remaining_seconds"`
+}
+
+// InvoiceTax is a tax charged on the invoice.
+type InvoiceTax struct {
+	Name string `json:"name"`
+
+	// Base is the amount of the calls the tax applies to
+	Base money.Money `json:"base"`
+
+	// Percentage of the base that is charged, nil for fixed taxes
+	Percentage *money.Decimal `json:"percentage,omitempty"`
+
+	Amount money.Money `json:"amount"`
+}
+
+// InvoicePeriod is the billing period of the invoice, with its boundaries
+// resolved in its time zone. Calls made from Start on and before End are
+// invoiced.
+type InvoicePeriod struct {
+	Start    string `json:"start"` // RFC 3339, e.g. 2022-11-01T00:00:00-03:00
+	End      string `json:"end"`
+	TimeZone string `json:"time_zone"` // IANA name, e.g. America/Argentina/Buenos_Aires
 }
 
 type InvoiceUser struct {
@@ -23,20 +187,105 @@ type InvoiceUser struct {
 }
 
 type InvoiceCall struct {
-	DestinationPhone string  `json:"phone_number"` // numero destino
-	Duration         uint    `json:"duration"`     // duracion
-	Timestamp        string  `json:"timestamp"`    // fecha y hora
-	Amount           float64 `json:"amount"`       // costo
+	DestinationPhone string      `json:"phone_number"`    // numero destino
+	Duration         uint        `json:"duration"`        // duracion
+	BilledDuration   uint        `json:"billed_duration"` // duracion facturada
+	Timestamp        string      `json:"timestamp"`       // fecha y hora
+	Amount           money.Money `json:"amount"`          // costo
+
+	// DefaultRate is set when the destination wasn't in the tariff rate table
+	DefaultRate bool `json:"default_rate,omitempty"`
+
+	// Band is the time band the call was rated in (e.g. peak, off_peak), if the
+	// tariff has time bands
+	Band string `json:"band,omitempty"`
+
+	// Holiday is the name of the holiday the call was made on, if any
+	Holiday string `json:"holiday,omitempty"`
+
+	// Promotions that contributed to the amount
+	Promotions []string `json:"promotions,omitempty"`
+
+	// Conversion is set when the tariff is in a different currency than the
+	// invoice, and Amount is the converted one.
+	Conversion *InvoiceConversion `json:"conversion,omitempty"`
+
+	// Trace explains how the call was rated (in the currency of the tariff),
+	// only if Config.Trace is set.
+	Trace *call.Trace `json:"trace,omitempty"`
+}
+
+// InvoiceConversion is how the amount of a call was converted from the currency
+// of the tariff to the one of the invoice.
+type InvoiceConversion struct {
+	OriginalAmount   money.Money   `json:"original_amount"`
+	OriginalCurrency string        `json:"original_currency"`
+	Rate             money.Decimal `json:"rate"`
+	RateDate         string        `json:"rate_date"` // AAAA-MM-DD, since when the rate is effective
+}
+
+// Config is how the calls of invoices are rated.
+type Config struct {
+	Tariff tariff.Tariff
+
+	// TariffHistory has the versions of the tariff, to rate each call with the
+	// one effective when it was made. If it's empty, Tariff is always effective.
+	TariffHistory tariff.History
+
+	// Rates convert the cost of calls from the currency of the tariff to the
+	// one of the user, when they differ.
+	Rates exchange.Rates
+
+	// Taxes charged on top of the cost of calls
+	Taxes tax.Taxes
+
+	// Promotions applied after the built-in ones
+	Promotions promotion.Config
+
+	// Plans users can be subscribed to
+	Plans plan.Plans
+
+	// Charges of users that aren't calls (e.g. the fee of their plan)
+	Charges charge.Charges
+
+	// Holidays of each country. Calls made on holidays of the country of the
+	// user are rated like on Sundays, and can have promotions of their own.
+	Holidays holiday.Calendar
+
+	// Types classify calls, defaults to call.DefaultRegistry. Tariffs, plans,
+	// taxes and promotions are loaded with its types (see Registry.CallTypes).
+	Types *call.Registry
+
+	// Trace makes each call of the invoice explain how it was rated
+	Trace bool
 }
 
 // Generate generates an invoice for a given user with calls.
 // It finds the user with the specified number (returning an error if it fails)
-// and calculates the cost for each call.
+// and calculates the cost for each call according to the tariff effective on
+// its date, converted to the currency of the user at the rate effective on the
+// date of the call.
 func Generate(
 	userFinder user.Finder,
 	userPhoneNumber string,
 	billingPeriod timeutil.Period,
+	config Config,
 	calls []call.Call,
+) (Invoice, error) {
+	return GenerateFrom(userFinder, userPhoneNumber, billingPeriod, config, call.FromSlice(calls))
+}
+
+// GenerateFrom generates an invoice like Generate, reading the calls from the
+// source as they are rated. Only the calls of the invoice are kept, so the
+// memory used doesn't depend on the amount of calls of the source. Errors of the
+// source are returned as they are, since it knows better where its calls come
+// from.
+func GenerateFrom(
+	userFinder user.Finder,
+	userPhoneNumber string,
+	billingPeriod timeutil.Period,
+	config Config,
+	calls call.Source,
 ) (Invoice, error) {
 	if err := call.ValidatePhoneNumber(userPhoneNumber); err != nil {
 		return Invoice{}, fmt.Errorf("user phone number: %s", err)
@@ -47,26 +296,157 @@ func Generate(
 		return Invoice{}, fmt.Errorf("finding user: %s", err)
 	}
 
-	callProcessor := call.NewProcessor(usr, billingPeriod, []call.Promotion{
-		call.NewPromotionFreeCallsToFriends(usr),
-	})
+	// Dates of configuration files (like the ones tariff versions and exchange
+	// rates are effective from) are days in the location of the billing period.
+	config.Rates = config.Rates.In(billingPeriod.Location())
+
+	tariffs := config.TariffHistory.In(billingPeriod.Location())
+	if tariffs.IsEmpty() {
+		tariffs = tariff.Single(config.Tariff)
+	}
+
+	if err := tariffs.Covers(billingPeriod.Start); err != nil {
+		return Invoice{}, fmt.Errorf("tariff: %s", err)
+	}
+
+	currency := usr.Currency
+	if currency == "" {
+		currency = tariffs.Currency()
+	}
+
+	if err := money.ValidateCurrency(currency); err != nil {
+		return Invoice{}, fmt.Errorf("user currency: %s", err)
+	}
+
+	// Holidays are resolved in the time zone of the user, or the one of the
+	// billing period if they don't have one.
+	location := billingPeriod.Location()
+	if usr.TimeZone != "" {
+		location, err = time.LoadLocation(usr.TimeZone)
+		if err != nil {
+			return Invoice{}, fmt.Errorf("user time zone: invalid time zone %q", usr.TimeZone)
+		}
+	}
+
+	userPlan, err := config.Plans.Find(usr.Plan)
+	if err != nil {
+		return Invoice{}, fmt.Errorf("user plan: %s", err)
+	}
+
+	types := config.Types
+	if types == nil {
+		types = call.DefaultRegistry()
+	}
+
+	allowances := userPlan.Build(types.CallTypes())
+	promotions := []call.Promotion{call.NewPromotionFreeCallsToFriends()}
+	promotions = append(promotions, config.Promotions.Build(billingPeriod.Location())...)
+
+	callProcessor := call.NewProcessor(usr, billingPeriod, tariffs, promotions, types)
+	if config.Trace {
+		callProcessor.EnableTracing()
+	}
+
+	// Allowances are consumed before the promotions, which apply to the
+	// overage.
+	callProcessor.UseAllowances(allowances)
+
+	callProcessor.UseHolidays(config.Holidays, location)
 
 	var invoiceCalls []InvoiceCall
-	for _, aCall := range calls {
-		callCost, skip := callProcessor.Process(aCall)
+	var callTypes []string // In order of appearance
+	callsByType := make(map[string]uint)
+	subtotal := money.Zero(currency)
+	subtotalsByCallType := make(map[string]money.Money)
+	for {
+		aCall, err := calls.Next()
+		if err == io.EOF {
+			break // read all the calls
+		}
+
+		if err != nil {
+			return Invoice{}, err
+		}
+
+		callCost, skip, err := callProcessor.Process(aCall)
+		if err != nil {
+			return Invoice{}, fmt.Errorf("call at %s: %s", aCall.Date.Format(timeutil.LayoutISO8601), err)
+		}
+
 		if skip {
 			continue
 		}
 
-		invoiceCalls = append(invoiceCalls, InvoiceCall{
+		invoiceCall := InvoiceCall{
 			DestinationPhone: aCall.DestinationPhone,
 			Duration:         aCall.Duration,
+			BilledDuration:   callCost.BilledDuration,
 			Timestamp:        aCall.Date.Format(timeutil.LayoutISO8601),
-			Amount:           callCost,
+			Amount:           callCost.Amount,
+			DefaultRate:      callCost.DefaultRate,
+			Band:             callCost.Band,
+			Holiday:          callCost.Holiday,
+			Promotions:       callCost.Promotions,
+			Trace:            callCost.Trace,
+		}
+
+		invoiceCall.Amount, invoiceCall.Conversion, err = convert(config.Rates, callCost.Amount, currency, aCall.Date)
+		if err != nil {
+			return Invoice{}, fmt.Errorf("call at %s: %s", invoiceCall.Timestamp, err)
+		}
+
+		invoiceCalls = append(invoiceCalls, invoiceCall)
+		if callsByType[callCost.Type] == 0 {
+			callTypes = append(callTypes, callCost.Type)
+		}
+
+		callsByType[callCost.Type]++
+		subtotal = subtotal.Add(invoiceCall.Amount)
+		subtotalsByCallType[callCost.Type] = subtotalsByCallType[callCost.Type].Add(invoiceCall.Amount)
+	}
+
+	// The calls of each type are an item
+	var items []InvoiceItem
+	for _, callType := range callTypes {
+		items = append(items, InvoiceItem{
+			Type:        ItemTypeCalls,
+			Description: callType + " calls",
+			Quantity:    callsByType[callType],
+			Amount:      subtotalsByCallType[callType],
 		})
 	}
 
-	totalAmount, totalSeconds := callProcessor.Summarize()
+	chargeItems, err := chargeItems(config, usr, currency, billingPeriod)
+	if err != nil {
+		return Invoice{}, err
+	}
+
+	for _, item := range chargeItems {
+		items = append(items, item)
+		subtotal = subtotal.Add(item.Amount)
+	}
+
+	taxes, err := chargeTaxes(config, usr, currency, billingPeriod, subtotalsByCallType, chargeItems)
+	if err != nil {
+		return Invoice{}, err
+	}
+
+	totalAmount := subtotal
+	for _, t := range taxes {
+		totalAmount = totalAmount.Add(t.Amount)
+	}
+
+	totalSeconds, billedSeconds := callProcessor.Summarize()
+
+	var invoiceAllowances []InvoiceAllowance
+	for _, allowance := range allowances {
+		invoiceAllowances = append(invoiceAllowances, InvoiceAllowance{
+			CallType:  allowance.CallType(),
+			Included:  allowance.Included(),
+			Consumed:  allowance.Consumed(),
+			Remaining: allowance.Remaining(),
+		})
+	}
 
 	return Invoice{
 		User: InvoiceUser{
@@ -74,10 +454,165 @@ func Generate(
 			Name:    usr.Name,
 			Phone:   string(usr.Phone),
 		},
-		Calls:                     invoiceCalls,
-		TotalFriendsSeconds:       totalSeconds.TotalFriendsSeconds,
-		TotalNationalSeconds:      totalSeconds.TotalNationalSeconds,
-		TotalInternationalSeconds: totalSeconds.TotalInternationalSeconds,
-		InvoiceTotal:              totalAmount,
+		BillingPeriod: InvoicePeriod{
+			Start:    billingPeriod.Start.Format(time.RFC3339),
+			End:      billingPeriod.End.Format(time.RFC3339),
+			TimeZone: billingPeriod.Location().String(),
+		},
+		Currency:           currency,
+		Calls:              invoiceCalls,
+		TotalSeconds:       totalSeconds,
+		TotalBilledSeconds: billedSeconds,
+		Allowances:         invoiceAllowances,
+		Items:              items,
+
+		Subtotal:     subtotal,
+		Taxes:        taxes,
+		InvoiceTotal: totalAmount,
 	}, nil
 }
+
+// chargeItems returns the items of the charges of the user in the billing
+// period. Recurring charges in another currency are converted at the rate
+// effective at the end of the billing period (its last instant), and one-off
+// charges at the one effective on their date, in the location of the period.
+func chargeItems(config Config, usr user.User, currency string, billingPeriod timeutil.Period) ([]InvoiceItem, error) {
+	lines, err := config.Charges.For(string(usr.Phone), billingPeriod)
+	if err != nil {
+		return nil, err
+	}
+
+	var items []InvoiceItem
+	for _, line := range lines {
+		at := billingPeriod.Last()
+		if line.Type == charge.TypeOneOff {
+			at = timeutil.Midnight(line.Charge.Date, billingPeriod.Location())
+		}
+
+		unitPrice, _, err := convert(config.Rates, line.Charge.UnitPrice, currency, at)
+		if err != nil {
+			return nil, fmt.Errorf("charge %s: %s", line.Charge.Description, err)
+		}
+
+		item := InvoiceItem{
+			Type:        line.Type,
+			Description: line.Charge.Description,
+			Quantity:    line.Charge.Quantity,
+			UnitPrice:   &unitPrice,
+		}
+
+		item.Amount, item.Conversion, err = convert(config.Rates, line.Amount, currency, at)
+		if err != nil {
+			return nil, fmt.Errorf("charge %s: %s", line.Charge.Description, err)
+		}
+
+		if line.Proration != nil {
+			item.Proration = &InvoiceProration{Days: line.Proration.Days, MonthDays: line.Proration.MonthDays}
+		}
+
+		items = append(items, item)
+	}
+
+	return items, nil
+}
+
+// convert converts the amount to the currency at the rate effective at the
+// specified time, returning how it was converted. Amounts already in the
+// currency aren't converted.
+func convert(rates exchange.Rates, amount money.Money, currency string, at time.Time) (money.Money, *InvoiceConversion, error) {
+	if amount.Currency() == currency {
+		return amount, nil, nil
+	}
+
+	conversion, err := rates.Convert(amount, currency, at)
+	if err != nil {
+		return money.Money{}, nil, err
+	}
+
+	return conversion.Converted, &InvoiceConversion{
+		OriginalAmount:   conversion.Original,
+		OriginalCurrency: conversion.Original.Currency(),
+		Rate:             conversion.Rate.Rate,
+		RateDate:         conversion.Rate.Date.Format(timeutil.LayoutDate),
+	}, nil
+}
+
+// chargeTaxes returns the taxes the user has to pay given the subtotals of each
+// type of call and the items of the charges. The base of a tax is the sum of
+// the subtotals of the types it applies to, plus the charges if it applies to
+// them, and it's only charged if there were calls or charges it applies to.
+// Charges are kept apart from calls, since a type of call could have the same
+// name as a type of charge (e.g. recurring).
+//
+// Fixed amounts in another currency are converted at the rate effective at the
+// end of the billing period (its last instant).
+func chargeTaxes(
+	config Config,
+	usr user.User,
+	currency string,
+	billingPeriod timeutil.Period,
+	subtotalsByCallType map[string]money.Money,
+	chargeItems []InvoiceItem,
+) ([]InvoiceTax, error) {
+	var taxes []InvoiceTax
+	for _, t := range config.Taxes.Taxes {
+		if isExempt(usr, t.Name) {
+			continue
+		}
+
+		base, hasItems := money.Zero(currency), false
+		for callType, subtotal := range subtotalsByCallType {
+			if t.AppliesTo(callType) {
+				base, hasItems = base.Add(subtotal), true
+			}
+		}
+
+		if t.AppliesToCharges() {
+			for _, item := range chargeItems {
+				base, hasItems = base.Add(item.Amount), true
+			}
+		}
+
+		if !hasItems {
+			continue
+		}
+
+		line := InvoiceTax{Name: t.Name, Base: base}
+		if t.IsFixed() {
+			amount := config.Taxes.FixedAmount(t)
+			if amount.Currency() != currency {
+				conversion, err := config.Rates.Convert(amount, currency, billingPeriod.Last())
+				if err != nil {
+					return nil, fmt.Errorf("tax %s: %s", t.Name, err)
+				}
+
+				amount = conversion.Converted
+			}
+
+			line.Amount = amount
+		} else {
+			percentage := t.Percentage
+			line.Percentage = &percentage
+			amount, err := t.Charge(base)
+			if err != nil {
+				return nil, fmt.Errorf("tax %s: %s", t.Name, err)
+			}
+
+			line.Amount = amount
+		}
+
+		taxes = append(taxes, line)
+	}
+
+	return taxes, nil
+}
+
+func isExempt(usr user.User, taxName string) bool {
+	for _, exemption := range usr.TaxExemptions {
+		if exemption == taxName {
+			return true
+		}
+	}
+
+	return false
+}
@@ -1,9 +1,18 @@
 package invoice_test
 
 import (
+	"encoding/json"
 	"fmt"
 	"invoice-generator/pkg/invoice"
 	"invoice-generator/pkg/invoice/call"
+	"invoice-generator/pkg/invoice/charge"
+	"invoice-generator/pkg/invoice/exchange"
+	"invoice-generator/pkg/invoice/holiday"
+	"invoice-generator/pkg/invoice/plan"
+	"invoice-generator/pkg/invoice/promotion"
+	"invoice-generator/pkg/invoice/tariff"
+	"invoice-generator/pkg/invoice/tax"
+	"invoice-generator/pkg/platform/money"
 	"invoice-generator/pkg/platform/timeutil"
 	"invoice-generator/pkg/user"
 	"testing"
@@ -54,14 +63,15 @@ func TestCanGenerateInvoiceForInternationalCallsToStrangers(t *testing.T) {
 		user.NewMockFinderForUser(testUser),
 		string(testUser.Phone),
 		_timePeriod,
+		invoice.Config{Tariff: tariff.Default()},
 		[]call.Call{firstInternationalCall, secondInternationalCall},
 	)
 	require.NoError(t, err)
 
 	assertInvoiceIsExpected(t, result, testUser,
 		[]expectedCall{
-			{call: firstInternationalCall, cost: float64(firstInternationalCall.Duration)},
-			{call: secondInternationalCall, cost: float64(secondInternationalCall.Duration)},
+			{call: firstInternationalCall, cost: pesos(firstInternationalCall.Duration)},
+			{call: secondInternationalCall, cost: pesos(secondInternationalCall.Duration)},
 		},
 		expectedTotalSeconds{
 			international: firstInternationalCall.Duration + secondInternationalCall.Duration,
@@ -123,6 +133,7 @@ func TestCallsToFriends(t *testing.T) {
 		user.NewMockFinderForUser(testUser),
 		string(testUser.Phone),
 		_timePeriod,
+		invoice.Config{Tariff: tariff.Default()},
 		[]call.Call{
 			nationalCall,
 			internationalFriendCall,
@@ -134,10 +145,10 @@ func TestCallsToFriends(t *testing.T) {
 
 	assertInvoiceIsExpected(t, result, testUser,
 		[]expectedCall{
-			{call: nationalCall, cost: 2.5},
-			{call: internationalFriendCall, cost: 0},
-			{call: nationalFriendCall, cost: 0},
-			{call: internationalCall, cost: float64(internationalCall.Duration)},
+			{call: nationalCall, cost: ars("2.5")},
+			{call: internationalFriendCall, cost: ars("0"), promotions: []string{"free_calls_to_friends"}},
+			{call: nationalFriendCall, cost: ars("0"), promotions: []string{"free_calls_to_friends"}},
+			{call: internationalCall, cost: pesos(internationalCall.Duration)},
 		},
 		// Friend call seconds are counted double: as national/international and
 		// friends
@@ -192,6 +203,7 @@ func TestFriendCallsAreFreeUpToTen(t *testing.T) {
 		user.NewMockFinderForUser(testUser),
 		string(testUser.Phone),
 		_timePeriod,
+		invoice.Config{Tariff: tariff.Default()},
 		calls,
 	)
 	require.NoError(t, err)
@@ -199,13 +211,17 @@ func TestFriendCallsAreFreeUpToTen(t *testing.T) {
 	var expectedCalls []expectedCall
 	// First ten are free
 	for i := 0; i < maxFreeFriendCalls; i++ {
-		expectedCalls = append(expectedCalls, expectedCall{call: nationalFriendCall, cost: 0})
+		expectedCalls = append(expectedCalls, expectedCall{
+			call:       nationalFriendCall,
+			cost:       ars("0"),
+			promotions: []string{"free_calls_to_friends"},
+		})
 	}
 
 	// Last ones are not
 	expectedCalls = append(expectedCalls,
-		expectedCall{call: nationalFriendCall, cost: 2.5},
-		expectedCall{call: internationalFriendCall, cost: float64(internationalFriendCall.Duration)},
+		expectedCall{call: nationalFriendCall, cost: ars("2.5")},
+		expectedCall{call: internationalFriendCall, cost: pesos(internationalFriendCall.Duration)},
 	)
 
 	assertInvoiceIsExpected(t, result, testUser, expectedCalls, expectedTotalSeconds{
@@ -245,6 +261,7 @@ func TestCallsOutsideBillingPeriodAreIgnored(t *testing.T) {
 		user.NewMockFinderForUser(testUser),
 		string(testUser.Phone),
 		_timePeriod,
+		invoice.Config{Tariff: tariff.Default()},
 		[]call.Call{callOutsidePeriod, nationalCallInsidePeriod},
 	)
 	require.NoError(t, err)
@@ -252,7 +269,7 @@ func TestCallsOutsideBillingPeriodAreIgnored(t *testing.T) {
 	assertInvoiceIsExpected(t, result, testUser,
 		[]expectedCall{
 			// shouldn't contain the call outside of the period
-			{call: nationalCallInsidePeriod, cost: 2.5},
+			{call: nationalCallInsidePeriod, cost: ars("2.5")},
 		},
 		expectedTotalSeconds{
 			international: 0, // shouldn't be counted for seconds either
@@ -289,6 +306,7 @@ func TestCallsFromDifferentUserAreIgnored(t *testing.T) {
 		user.NewMockFinderForUser(testUser),
 		string(testUser.Phone),
 		_timePeriod,
+		invoice.Config{Tariff: tariff.Default()},
 		[]call.Call{callFromOtherUser, nationalCallFromUser},
 	)
 	require.NoError(t, err)
@@ -296,7 +314,7 @@ func TestCallsFromDifferentUserAreIgnored(t *testing.T) {
 	assertInvoiceIsExpected(t, result, testUser,
 		[]expectedCall{
 			// shouldn't contain the call from other user
-			{call: nationalCallFromUser, cost: 2.5},
+			{call: nationalCallFromUser, cost: ars("2.5")},
 		},
 		expectedTotalSeconds{
 			international: 0, // shouldn't be counted for seconds either
@@ -307,6 +325,1041 @@ func TestCallsFromDifferentUserAreIgnored(t *testing.T) {
 
 }
 
+func TestInternationalCallsArePricedByDestination(t *testing.T) {
+	// International calls are priced with the rate of the longest matching
+	// prefix, and the ones to unknown destinations use the default rate and are
+	// reported.
+	testUser := user.User{
+		Name:    "Antonio Banderas",
+		Address: "Calle Falsa 123",
+		Phone:   "+5491111111111",
+	}
+
+	callTariff := tariff.Default()
+	callTariff.International.Destinations = tariff.RateTable{
+		"+1":    {PerSecond: dec("0.5")},
+		"+1876": {PerSecond: dec("2")},
+	}
+
+	usCall := call.Call{
+		DestinationPhone: "+12125551234",
+		SourcePhone:      string(testUser.Phone),
+		Duration:         60,
+		Date:             _timeInPeriod,
+	}
+
+	jamaicaCall := call.Call{
+		DestinationPhone: "+18765551234",
+		SourcePhone:      string(testUser.Phone),
+		Duration:         60,
+		Date:             _timeInPeriod,
+	}
+
+	unknownCall := call.Call{
+		DestinationPhone: "+34911111111",
+		SourcePhone:      string(testUser.Phone),
+		Duration:         60,
+		Date:             _timeInPeriod,
+	}
+
+	result, err := invoice.Generate(
+		user.NewMockFinderForUser(testUser),
+		string(testUser.Phone),
+		_timePeriod,
+		invoice.Config{Tariff: callTariff},
+		[]call.Call{usCall, jamaicaCall, unknownCall},
+	)
+	require.NoError(t, err)
+
+	require.Len(t, result.Calls, 3)
+	assert.Equal(t, ars("30"), result.Calls[0].Amount)
+	assert.False(t, result.Calls[0].DefaultRate)
+	assert.Equal(t, ars("120"), result.Calls[1].Amount)
+	assert.False(t, result.Calls[1].DefaultRate)
+	assert.Equal(t, ars("60"), result.Calls[2].Amount)
+	assert.True(t, result.Calls[2].DefaultRate)
+	assert.Equal(t, ars("210"), result.InvoiceTotal)
+}
+
+func TestCallsAreRatedInTheirTimeBand(t *testing.T) {
+	// Calls made in a time band have their base cost changed by its
+	// multiplier, and the invoice shows the band each call was rated in.
+	testUser := user.User{
+		Name:    "Antonio Banderas",
+		Address: "Calle Falsa 123",
+		Phone:   "+5491111111111",
+	}
+
+	callTariff := tariff.Default()
+	callTariff.TimeBands = tariff.TimeBands{
+		Bands: []tariff.Band{
+			{
+				Name:        "weekend",
+				Windows:     []tariff.Window{{Days: []tariff.Weekday{tariff.Weekday(time.Saturday), tariff.Weekday(time.Sunday)}}},
+				Multipliers: map[string]money.Decimal{tariff.TypeNational: dec("0.5")},
+			},
+		},
+	}
+
+	weekendCall := call.Call{
+		DestinationPhone: "+5491111111112",
+		SourcePhone:      string(testUser.Phone),
+		Duration:         60,
+		Date:             time.Date(2022, time.September, 3, 12, 0, 0, 0, time.UTC), // Saturday
+	}
+
+	weekdayCall := call.Call{
+		DestinationPhone: "+5491111111112",
+		SourcePhone:      string(testUser.Phone),
+		Duration:         60,
+		Date:             time.Date(2022, time.September, 5, 12, 0, 0, 0, time.UTC), // Monday
+	}
+
+	result, err := invoice.Generate(
+		user.NewMockFinderForUser(testUser),
+		string(testUser.Phone),
+		_timePeriod,
+		invoice.Config{Tariff: callTariff},
+		[]call.Call{weekendCall, weekdayCall},
+	)
+	require.NoError(t, err)
+
+	require.Len(t, result.Calls, 2)
+	assert.Equal(t, ars("1.25"), result.Calls[0].Amount)
+	assert.Equal(t, "weekend", result.Calls[0].Band)
+	assert.Equal(t, ars("2.5"), result.Calls[1].Amount)
+	assert.Equal(t, tariff.PeakBand, result.Calls[1].Band)
+}
+
+func TestDurationsAreBilledInIncrements(t *testing.T) {
+	// Calls are charged by their billed duration, and the invoice has totals
+	// for both the real and billed durations.
+	const friendPhone = "+5491111111113"
+
+	testUser := user.User{
+		Name:    "Antonio Banderas",
+		Address: "Calle Falsa 123",
+		Phone:   "+5491111111111",
+		Friends: []user.PhoneNumber{friendPhone},
+	}
+
+	callTariff := tariff.Default()
+	callTariff.National = tariff.Pricing{
+		PerMinute:  dec("1"),
+		Increments: tariff.Increments{Initial: 60, Subsequent: 60},
+	}
+
+	nationalCall := call.Call{
+		DestinationPhone: "+5491111111112",
+		SourcePhone:      string(testUser.Phone),
+		Duration:         61,
+		Date:             _timeInPeriod,
+	}
+
+	friendCall := call.Call{
+		DestinationPhone: friendPhone,
+		SourcePhone:      string(testUser.Phone),
+		Duration:         10,
+		Date:             _timeInPeriod,
+	}
+
+	result, err := invoice.Generate(
+		user.NewMockFinderForUser(testUser),
+		string(testUser.Phone),
+		_timePeriod,
+		invoice.Config{Tariff: callTariff},
+		[]call.Call{nationalCall, friendCall},
+	)
+	require.NoError(t, err)
+
+	require.Len(t, result.Calls, 2)
+	assert.Equal(t, uint(61), result.Calls[0].Duration)
+	assert.Equal(t, uint(120), result.Calls[0].BilledDuration)
+	assert.Equal(t, ars("2"), result.Calls[0].Amount)
+	assert.Equal(t, uint(10), result.Calls[1].Duration)
+	assert.Equal(t, uint(60), result.Calls[1].BilledDuration)
+	assert.Equal(t, ars("0"), result.Calls[1].Amount) // free call to a friend
+
+	assert.Equal(t, uint(71), result.TotalSeconds.Seconds(tariff.TypeNational))
+	assert.Equal(t, uint(180), result.TotalBilledSeconds.Seconds(tariff.TypeNational))
+	assert.Equal(t, uint(10), result.TotalSeconds.Seconds(call.TypeFriends))
+	assert.Equal(t, uint(60), result.TotalBilledSeconds.Seconds(call.TypeFriends))
+}
+
+func TestCallsAreRatedWithTheTariffEffectiveOnTheirDate(t *testing.T) {
+	// The price of national calls changes on the 15th of November, in the
+	// middle of the billing period.
+	testUser := user.User{Phone: "+5491111111111"}
+
+	newTariff := tariff.Default()
+	newTariff.National = tariff.Pricing{PerCall: dec("3")}
+
+	history := tariff.NewHistory(
+		tariff.Version{EffectiveFrom: time.Date(2022, time.January, 1, 0, 0, 0, 0, time.UTC), Tariff: tariff.Default()},
+		tariff.Version{EffectiveFrom: time.Date(2022, time.November, 15, 0, 0, 0, 0, time.UTC), Tariff: newTariff},
+	)
+
+	nationalCall := func(date string) call.Call {
+		return call.Call{
+			DestinationPhone: "+5491111111112",
+			SourcePhone:      string(testUser.Phone),
+			Duration:         60,
+			Date:             mustParse(time.RFC3339, date),
+		}
+	}
+
+	result, err := invoice.Generate(
+		user.NewMockFinderForUser(testUser),
+		string(testUser.Phone),
+		_timePeriod,
+		invoice.Config{TariffHistory: history, Trace: true},
+		[]call.Call{nationalCall("2022-11-14T23:59:59Z"), nationalCall("2022-11-15T00:00:00Z")},
+	)
+	require.NoError(t, err)
+
+	require.Len(t, result.Calls, 2)
+	assert.Equal(t, ars("2.50"), result.Calls[0].Amount)
+	assert.Equal(t, "2022-01-01", result.Calls[0].Trace.TariffEffectiveFrom)
+	assert.Equal(t, ars("3"), result.Calls[1].Amount)
+	assert.Equal(t, "2022-11-15", result.Calls[1].Trace.TariffEffectiveFrom)
+	assert.Equal(t, ars("5.50"), result.InvoiceTotal)
+}
+
+func TestDatesOfConfigurationAreDaysInTheLocationOfTheBillingPeriod(t *testing.T) {
+	// In Buenos Aires (UTC-3), the 15th of November starts at 03:00 UTC. The
+	// tariff, the exchange rate and the promotion of that date apply from then
+	// on, not from 21:00 of the 14th.
+	testUser := user.User{Phone: "+5491111111111", Currency: "USD"}
+
+	buenosAires, err := time.LoadLocation("America/Argentina/Buenos_Aires")
+	require.NoError(t, err)
+
+	november := timeutil.Days(
+		time.Date(2022, time.November, 1, 0, 0, 0, 0, time.UTC),
+		time.Date(2022, time.November, 30, 0, 0, 0, 0, time.UTC),
+		buenosAires,
+	)
+	fifteenth := time.Date(2022, time.November, 15, 0, 0, 0, 0, time.UTC)
+
+	newTariff := tariff.Default()
+	newTariff.National = tariff.Pricing{PerCall: dec("3")}
+	history := tariff.NewHistory(
+		tariff.Version{EffectiveFrom: time.Date(2022, time.November, 1, 0, 0, 0, 0, time.UTC), Tariff: tariff.Default()},
+		tariff.Version{EffectiveFrom: fifteenth, Tariff: newTariff},
+	)
+
+	rates := exchange.New(
+		exchange.Rate{Date: time.Date(2022, time.November, 1, 0, 0, 0, 0, time.UTC), From: "ARS", To: "USD", Rate: dec("0.01")},
+		exchange.Rate{Date: fifteenth, From: "ARS", To: "USD", Rate: dec("0.02")},
+	)
+
+	promotions := promotion.Config{Discounts: []promotion.Discount{
+		{Name: "launch", AmountOff: dec("1"), Valid: promotion.Validity{From: promotion.Date{Time: fifteenth}}},
+	}}
+
+	nationalCall := func(date string) call.Call {
+		return call.Call{
+			DestinationPhone: "+5491111111112",
+			SourcePhone:      string(testUser.Phone),
+			Duration:         60,
+			Date:             mustParse(time.RFC3339, date),
+		}
+	}
+
+	result, err := invoice.Generate(
+		user.NewMockFinderForUser(testUser),
+		string(testUser.Phone),
+		november,
+		invoice.Config{TariffHistory: history, Rates: rates, Promotions: promotions, Trace: true},
+		[]call.Call{nationalCall("2022-11-15T02:59:59Z"), nationalCall("2022-11-15T03:00:00Z")},
+	)
+	require.NoError(t, err)
+
+	require.Len(t, result.Calls, 2)
+	assert.Equal(t, "2022-11-01", result.Calls[0].Trace.TariffEffectiveFrom)
+	assert.Equal(t, "2022-11-01", result.Calls[0].Conversion.RateDate)
+	assert.Empty(t, result.Calls[0].Promotions)
+	assert.Equal(t, money.MustParse("0.03", "USD"), result.Calls[0].Amount) // ARS 2.50 at 0.01
+
+	assert.Equal(t, "2022-11-15", result.Calls[1].Trace.TariffEffectiveFrom)
+	assert.Equal(t, "2022-11-15", result.Calls[1].Conversion.RateDate)
+	assert.Equal(t, []string{"launch"}, result.Calls[1].Promotions)
+	assert.Equal(t, money.MustParse("0.04", "USD"), result.Calls[1].Amount) // ARS 2 at 0.02
+}
+
+func TestCallsWithCostsOutOfRangeShouldReturnAnError(t *testing.T) {
+	testUser := user.User{Phone: "+5491111111111"}
+
+	callTariff := tariff.Default()
+	callTariff.International.Default = tariff.Pricing{PerSecond: dec("3")}
+
+	_, err := invoice.Generate(
+		user.NewMockFinderForUser(testUser),
+		string(testUser.Phone),
+		_timePeriod,
+		invoice.Config{Tariff: callTariff},
+		[]call.Call{{
+			DestinationPhone: "+191167980952",
+			SourcePhone:      string(testUser.Phone),
+			Duration:         4_000_000_000, // The longest call of a file
+			Date:             mustParse(time.RFC3339, "2022-12-08T15:00:00Z"),
+		}},
+	)
+	assert.EqualError(t, err, "call at 2022-12-08T15:00:00Z: multiplying 3 by 4000000000: out of range")
+}
+
+func TestTariffHistoryMustCoverTheBillingPeriod(t *testing.T) {
+	testUser := user.User{Phone: "+5491111111111"}
+	history := tariff.NewHistory(
+		tariff.Version{EffectiveFrom: time.Date(2022, time.November, 15, 0, 0, 0, 0, time.UTC), Tariff: tariff.Default()},
+	)
+
+	_, err := invoice.Generate(
+		user.NewMockFinderForUser(testUser),
+		string(testUser.Phone),
+		_timePeriod,
+		invoice.Config{TariffHistory: history},
+		nil,
+	)
+	assert.EqualError(t, err, "tariff: no tariff effective on 2022-01-01")
+}
+
+func TestHolidaysInTheTimeZoneOfTheUserAreLikeSundays(t *testing.T) {
+	testUser := user.User{Phone: "+5491111111111", TimeZone: "America/Argentina/Buenos_Aires"}
+
+	callTariff, err := tariff.Load([]byte(`
+national: {per_call: 2}
+international: {per_second: 1}
+time_bands:
+  bands:
+    - name: off_peak
+      windows:
+        - days: [saturday, sunday]
+      multipliers: {national: 0.5}
+`), tariff.CallTypes{})
+	require.NoError(t, err)
+
+	promotions, err := promotion.Load([]byte(`
+discounts:
+  - name: feriados
+    match: {holidays: true}
+    percentage: 50
+`), tariff.CallTypes{})
+	require.NoError(t, err)
+
+	holidays, err := holiday.Load([]byte(`
+countries:
+  AR:
+    - {date: 2022-12-08, name: Inmaculada Concepción}
+`))
+	require.NoError(t, err)
+
+	nationalCall := func(date string) call.Call {
+		return call.Call{
+			DestinationPhone: "+5491111111112",
+			SourcePhone:      string(testUser.Phone),
+			Duration:         60,
+			Date:             mustParse(time.RFC3339, date),
+		}
+	}
+
+	result, err := invoice.Generate(
+		user.NewMockFinderForUser(testUser),
+		string(testUser.Phone),
+		_timePeriod,
+		invoice.Config{Tariff: callTariff, Promotions: promotions, Holidays: holidays},
+		[]call.Call{
+			nationalCall("2022-12-08T02:00:00Z"), // Wednesday 23:00 in Buenos Aires
+			nationalCall("2022-12-08T15:00:00Z"), // Thursday 12:00, a holiday
+			nationalCall("2022-12-09T02:00:00Z"), // Thursday 23:00, still the holiday
+		},
+	)
+	require.NoError(t, err)
+
+	require.Len(t, result.Calls, 3)
+
+	// Already the holiday in the time zone of the bands (UTC), but holidays
+	// are resolved in the time zone of the user, so it's a peak Wednesday
+	assert.Equal(t, ars("2"), result.Calls[0].Amount)
+	assert.Equal(t, tariff.PeakBand, result.Calls[0].Band)
+	assert.Empty(t, result.Calls[0].Holiday)
+	assert.Empty(t, result.Calls[0].Promotions)
+
+	// Off-peak like on Sundays, and with the discount of holidays
+	assert.Equal(t, ars("0.50"), result.Calls[1].Amount)
+	assert.Equal(t, "off_peak", result.Calls[1].Band)
+	assert.Equal(t, "Inmaculada Concepción", result.Calls[1].Holiday)
+	assert.Equal(t, []string{"feriados"}, result.Calls[1].Promotions)
+
+	// Friday in the time zone of the bands, but the holiday for the user
+	assert.Equal(t, ars("0.50"), result.Calls[2].Amount)
+	assert.Equal(t, "off_peak", result.Calls[2].Band)
+	assert.Equal(t, "Inmaculada Concepción", result.Calls[2].Holiday)
+}
+
+func TestInvalidTimeZoneOfTheUserShouldReturnAnError(t *testing.T) {
+	testUser := user.User{Phone: "+5491111111111", TimeZone: "Mars/Olympus_Mons"}
+
+	_, err := invoice.Generate(
+		user.NewMockFinderForUser(testUser),
+		string(testUser.Phone),
+		_timePeriod,
+		invoice.Config{Tariff: tariff.Default()},
+		nil,
+	)
+	assert.EqualError(t, err, `user time zone: invalid time zone "Mars/Olympus_Mons"`)
+}
+
+func TestInterplanetaryCallsArePricedWithSurcharge(t *testing.T) {
+	testUser := user.User{
+		Name:    "Antonio Banderas",
+		Address: "Calle Falsa 123",
+		Phone:   "+5491111111111",
+	}
+
+	callTariff := tariff.Default()
+	callTariff.Interplanetary = tariff.InterplanetaryPricing{
+		Prefix:  "+881",
+		Pricing: tariff.Pricing{PerSecond: dec("10"), PerCall: dec("50")},
+	}
+
+	interplanetaryCall := call.Call{
+		DestinationPhone: "+881612345678",
+		SourcePhone:      string(testUser.Phone),
+		Duration:         30,
+		Date:             _timeInPeriod,
+	}
+
+	internationalCall := call.Call{
+		DestinationPhone: "+1991111111112",
+		SourcePhone:      string(testUser.Phone),
+		Duration:         20,
+		Date:             _timeInPeriod,
+	}
+
+	result, err := invoice.Generate(
+		user.NewMockFinderForUser(testUser),
+		string(testUser.Phone),
+		_timePeriod,
+		invoice.Config{Tariff: callTariff},
+		[]call.Call{interplanetaryCall, internationalCall},
+	)
+	require.NoError(t, err)
+
+	require.Len(t, result.Calls, 2)
+	assert.Equal(t, ars("350"), result.Calls[0].Amount) // 30 * $10 + $50
+	assert.Equal(t, ars("20"), result.Calls[1].Amount)
+
+	assert.Equal(t, uint(30), result.TotalSeconds.Seconds(tariff.TypeInterplanetary))
+	assert.Equal(t, uint(30), result.TotalBilledSeconds.Seconds(tariff.TypeInterplanetary))
+	assert.Equal(t, uint(20), result.TotalSeconds.Seconds(tariff.TypeInternational))
+	assert.Equal(t, ars("370"), result.InvoiceTotal)
+}
+
+func TestConvertsCallsToTheCurrencyOfTheUser(t *testing.T) {
+	// When the user is invoiced in a different currency than the tariff, each
+	// call is converted at the rate effective on its date, and the invoice
+	// shows how.
+	const friendPhone = "+5491111111113"
+
+	testUser := user.User{
+		Name:     "Antonio Banderas",
+		Address:  "Calle Falsa 123",
+		Phone:    "+5491111111111",
+		Friends:  []user.PhoneNumber{friendPhone},
+		Currency: "ARS",
+	}
+
+	callTariff := tariff.Default()
+	callTariff.Currency = "USD"
+	callTariff.International.Default = tariff.Pricing{PerSecond: dec("0.01")}
+
+	rates := exchange.New(
+		exchange.Rate{Date: _timeInPeriod.AddDate(0, -1, 0), From: "USD", To: "ARS", Rate: dec("150")},
+		exchange.Rate{Date: _timeInPeriod.AddDate(0, 0, 1), From: "USD", To: "ARS", Rate: dec("200")},
+	)
+
+	internationalCall := call.Call{
+		DestinationPhone: "+1991111111112",
+		SourcePhone:      string(testUser.Phone),
+		Duration:         61,
+		Date:             _timeInPeriod,
+	}
+
+	friendCall := call.Call{
+		DestinationPhone: friendPhone,
+		SourcePhone:      string(testUser.Phone),
+		Duration:         10,
+		Date:             _timeInPeriod.AddDate(0, 0, 2),
+	}
+
+	result, err := invoice.Generate(
+		user.NewMockFinderForUser(testUser),
+		string(testUser.Phone),
+		_timePeriod,
+		invoice.Config{Tariff: callTariff, Rates: rates},
+		[]call.Call{internationalCall, friendCall},
+	)
+	require.NoError(t, err)
+
+	require.Len(t, result.Calls, 2)
+	assert.Equal(t, "ARS", result.Currency)
+	assert.Equal(t, ars("91.50"), result.Calls[0].Amount)
+	assert.Equal(t, &invoice.InvoiceConversion{
+		OriginalAmount:   money.MustParse("0.61", "USD"),
+		OriginalCurrency: "USD",
+		Rate:             dec("150"),
+		RateDate:         "2022-08-05",
+	}, result.Calls[0].Conversion)
+
+	assert.Equal(t, ars("0"), result.Calls[1].Amount) // free call to a friend
+	assert.Equal(t, dec("200"), result.Calls[1].Conversion.Rate)
+
+	assert.Equal(t, ars("91.50"), result.InvoiceTotal)
+}
+
+func TestCallTypesCanBePricedInDifferentCurrencies(t *testing.T) {
+	// International calls are priced in USD and national ones in ARS, and the
+	// user is invoiced in ARS, so only international calls are converted.
+	testUser := user.User{
+		Name:    "Antonio Banderas",
+		Address: "Calle Falsa 123",
+		Phone:   "+5491111111111",
+	}
+
+	callTariff := tariff.Default()
+	callTariff.International.Default = tariff.Pricing{Currency: "USD", PerSecond: dec("0.01")}
+
+	rates := exchange.New(exchange.Rate{Date: _timeInPeriod.AddDate(0, -1, 0), From: "USD", To: "ARS", Rate: dec("150")})
+
+	calls := []call.Call{
+		{DestinationPhone: "+1991111111112", SourcePhone: string(testUser.Phone), Duration: 60, Date: _timeInPeriod},
+		{DestinationPhone: "+5491111111112", SourcePhone: string(testUser.Phone), Duration: 60, Date: _timeInPeriod},
+	}
+
+	result, err := invoice.Generate(
+		user.NewMockFinderForUser(testUser),
+		string(testUser.Phone),
+		_timePeriod,
+		invoice.Config{Tariff: callTariff, Rates: rates},
+		calls,
+	)
+	require.NoError(t, err)
+
+	require.Len(t, result.Calls, 2)
+	assert.Equal(t, tariff.DefaultCurrency, result.Currency)
+	assert.Equal(t, ars("90"), result.Calls[0].Amount)
+	assert.Equal(t, money.MustParse("0.60", "USD"), result.Calls[0].Conversion.OriginalAmount)
+	assert.Equal(t, ars("2.50"), result.Calls[1].Amount)
+	assert.Nil(t, result.Calls[1].Conversion)
+	assert.Equal(t, ars("92.50"), result.InvoiceTotal)
+}
+
+func TestConversionWithoutRateShouldReturnAnError(t *testing.T) {
+	testUser := user.User{
+		Name:     "Antonio Banderas",
+		Address:  "Calle Falsa 123",
+		Phone:    "+5491111111111",
+		Currency: "EUR",
+	}
+
+	aCall := call.Call{
+		DestinationPhone: "+5491111111112",
+		SourcePhone:      string(testUser.Phone),
+		Duration:         10,
+		Date:             _timeInPeriod,
+	}
+
+	_, err := invoice.Generate(user.NewMockFinderForUser(testUser), string(testUser.Phone), _timePeriod, invoice.Config{Tariff: tariff.Default()}, []call.Call{aCall})
+	assert.EqualError(t, err, "call at 2022-09-05T20:52:44Z: no exchange rate from ARS to EUR on 2022-09-05")
+}
+
+func TestChargesTaxesOnTopOfTheCalls(t *testing.T) {
+	// Taxes are charged on the subtotal of the calls they apply to, and the
+	// total is the subtotal plus all of them.
+	testUser := user.User{
+		Name:          "Antonio Banderas",
+		Address:       "Calle Falsa 123",
+		Phone:         "+5491111111111",
+		TaxExemptions: []string{"Exenta"},
+	}
+
+	nationalCall := call.Call{
+		DestinationPhone: "+5491111111112",
+		SourcePhone:      string(testUser.Phone),
+		Duration:         30,
+		Date:             _timeInPeriod,
+	}
+
+	internationalCall := call.Call{
+		DestinationPhone: "+1991111111112",
+		SourcePhone:      string(testUser.Phone),
+		Duration:         10,
+		Date:             _timeInPeriod,
+	}
+
+	taxes := tax.Taxes{
+		Currency: tariff.DefaultCurrency,
+		Taxes: []tax.Tax{
+			{Name: "IVA", Percentage: dec("21")},
+			{Name: "Tasa internacional", Percentage: dec("4"), CallTypes: []string{tariff.TypeInternational}},
+			{Name: "Cargo fijo", Fixed: dec("5")},
+			{Name: "Exenta", Percentage: dec("50")},
+		},
+	}
+
+	result, err := invoice.Generate(
+		user.NewMockFinderForUser(testUser),
+		string(testUser.Phone),
+		_timePeriod,
+		invoice.Config{Tariff: tariff.Default(), Taxes: taxes},
+		[]call.Call{nationalCall, internationalCall},
+	)
+	require.NoError(t, err)
+
+	// 2.50 of the national call and 10 of the international one
+	assert.Equal(t, ars("12.50"), result.Subtotal)
+	assert.Equal(t, []invoice.InvoiceTax{
+		{Name: "IVA", Base: ars("12.50"), Percentage: ptr(dec("21")), Amount: ars("2.63")},
+		{Name: "Tasa internacional", Base: ars("10"), Percentage: ptr(dec("4")), Amount: ars("0.40")},
+		{Name: "Cargo fijo", Base: ars("12.50"), Amount: ars("5")},
+	}, result.Taxes)
+	assert.Equal(t, ars("20.53"), result.InvoiceTotal)
+}
+
+func TestTaxesOfOtherCallTypesAreNotCharged(t *testing.T) {
+	testUser := user.User{
+		Name:    "Antonio Banderas",
+		Address: "Calle Falsa 123",
+		Phone:   "+5491111111111",
+	}
+
+	nationalCall := call.Call{
+		DestinationPhone: "+5491111111112",
+		SourcePhone:      string(testUser.Phone),
+		Duration:         30,
+		Date:             _timeInPeriod,
+	}
+
+	taxes := tax.Taxes{
+		Currency: tariff.DefaultCurrency,
+		Taxes:    []tax.Tax{{Name: "Cargo internacional", Fixed: dec("5"), CallTypes: []string{tariff.TypeInternational}}},
+	}
+
+	result, err := invoice.Generate(
+		user.NewMockFinderForUser(testUser),
+		string(testUser.Phone),
+		_timePeriod,
+		invoice.Config{Tariff: tariff.Default(), Taxes: taxes},
+		[]call.Call{nationalCall},
+	)
+	require.NoError(t, err)
+
+	assert.Empty(t, result.Taxes)
+	assert.Equal(t, ars("2.50"), result.InvoiceTotal)
+}
+
+func TestAppliesConfiguredDiscounts(t *testing.T) {
+	// Configured discounts are applied after the free calls to friends, which
+	// are exclusive, so friends don't get a discount on top.
+	const friendPhone = "+5491111111113"
+
+	testUser := user.User{
+		Name:    "Antonio Banderas",
+		Address: "Calle Falsa 123",
+		Phone:   "+5491111111111",
+		Friends: []user.PhoneNumber{friendPhone},
+	}
+
+	nationalCall := call.Call{
+		DestinationPhone: "+5491111111112",
+		SourcePhone:      string(testUser.Phone),
+		Duration:         30,
+		Date:             _timeInPeriod,
+	}
+
+	friendCall := call.Call{
+		DestinationPhone: friendPhone,
+		SourcePhone:      string(testUser.Phone),
+		Duration:         30,
+		Date:             _timeInPeriod,
+	}
+
+	promotions := promotion.Config{
+		Discounts: []promotion.Discount{
+			{Name: "national_discount", Match: promotion.Matcher{CallTypes: []string{tariff.TypeNational}}, AmountOff: dec("0.5")},
+			{Name: "everything_off", Match: promotion.Matcher{}, Percentage: dec("10")},
+		},
+	}
+
+	result, err := invoice.Generate(
+		user.NewMockFinderForUser(testUser),
+		string(testUser.Phone),
+		_timePeriod,
+		invoice.Config{Tariff: tariff.Default(), Promotions: promotions},
+		[]call.Call{nationalCall, friendCall},
+	)
+	require.NoError(t, err)
+
+	require.Len(t, result.Calls, 2)
+	// (2.50 - 0.50) * 0.9
+	assert.Equal(t, ars("1.80"), result.Calls[0].Amount)
+	assert.Equal(t, []string{"national_discount", "everything_off"}, result.Calls[0].Promotions)
+	assert.Equal(t, ars("0"), result.Calls[1].Amount)
+	assert.Equal(t, []string{"free_calls_to_friends"}, result.Calls[1].Promotions)
+}
+
+func TestMercosurPromotionIsEnabledByConfig(t *testing.T) {
+	testUser := user.User{
+		Name:    "Antonio Banderas",
+		Address: "Calle Falsa 123",
+		Phone:   "+5491111111111",
+	}
+
+	callToBrazil := call.Call{
+		DestinationPhone: "+5511912345678",
+		SourcePhone:      string(testUser.Phone),
+		Duration:         60,
+		Date:             _timeInPeriod,
+	}
+
+	callToUSA := call.Call{
+		DestinationPhone: "+1991111111112",
+		SourcePhone:      string(testUser.Phone),
+		Duration:         60,
+		Date:             _timeInPeriod,
+	}
+
+	result, err := invoice.Generate(
+		user.NewMockFinderForUser(testUser),
+		string(testUser.Phone),
+		_timePeriod,
+		invoice.Config{
+			Tariff:     tariff.Default(),
+			Promotions: promotion.Config{Mercosur: &promotion.Mercosur{Percentage: dec("25")}},
+		},
+		[]call.Call{callToBrazil, callToUSA},
+	)
+	require.NoError(t, err)
+
+	require.Len(t, result.Calls, 2)
+	assert.Equal(t, ars("45"), result.Calls[0].Amount)
+	assert.Equal(t, []string{"mercosur"}, result.Calls[0].Promotions)
+	assert.Equal(t, pesos(60), result.Calls[1].Amount)
+	assert.Empty(t, result.Calls[1].Promotions)
+}
+
+func TestPlanAllowancesAreConsumedBeforeCharging(t *testing.T) {
+	const friendPhone = "+5491111111113"
+
+	testUser := user.User{
+		Name:    "Antonio Banderas",
+		Address: "Calle Falsa 123",
+		Phone:   "+5491111111111",
+		Friends: []user.PhoneNumber{friendPhone},
+		Plan:    "basico",
+	}
+
+	callTariff := tariff.Default()
+	callTariff.National = tariff.Pricing{PerSecond: dec("0.1")}
+
+	plans := plan.Plans{Plans: map[string]plan.Plan{
+		"basico": {Allowances: map[string]uint{tariff.TypeNational: 1}},
+	}}
+
+	nationalCall := call.Call{
+		DestinationPhone: "+5491111111112",
+		SourcePhone:      string(testUser.Phone),
+		Duration:         40,
+		Date:             _timeInPeriod,
+	}
+
+	friendCall := nationalCall
+	friendCall.DestinationPhone = friendPhone
+
+	result, err := invoice.Generate(
+		user.NewMockFinderForUser(testUser),
+		string(testUser.Phone),
+		_timePeriod,
+		invoice.Config{Tariff: callTariff, Plans: plans},
+		[]call.Call{nationalCall, friendCall, nationalCall},
+	)
+	require.NoError(t, err)
+
+	require.Len(t, result.Calls, 3)
+	assert.Equal(t, ars("0"), result.Calls[0].Amount)
+	assert.Equal(t, []string{"allowance_national"}, result.Calls[0].Promotions)
+
+	// Calls to friends consume the allowance too, and are free after it
+	assert.Equal(t, ars("0"), result.Calls[1].Amount)
+	assert.Equal(t, []string{"allowance_national", "free_calls_to_friends"}, result.Calls[1].Promotions)
+
+	assert.Equal(t, ars("4"), result.Calls[2].Amount) // 40 seconds of overage
+	assert.Empty(t, result.Calls[2].Promotions)
+
+	expectedAllowances := []invoice.InvoiceAllowance{
+		{CallType: tariff.TypeNational, Included: 60, Consumed: 60, Remaining: 0},
+	}
+	assert.Equal(t, expectedAllowances, result.Allowances)
+	assert.Equal(t, ars("4"), result.InvoiceTotal)
+}
+
+func TestPlanAllowancesAreConsumedBeforeExclusivePromotions(t *testing.T) {
+	testUser := user.User{Phone: "+5491111111111", Plan: "basico"}
+
+	plans := plan.Plans{Plans: map[string]plan.Plan{
+		"basico": {Allowances: map[string]uint{tariff.TypeInternational: 1}},
+	}}
+
+	promotions, err := promotion.Load([]byte(`
+discounts:
+  - name: ten_off
+    policy: exclusive
+    percentage: 10
+`), tariff.CallTypes{})
+	require.NoError(t, err)
+
+	internationalCall := call.Call{
+		DestinationPhone: "+191167980952",
+		SourcePhone:      string(testUser.Phone),
+		Duration:         90,
+		Date:             _timeInPeriod,
+	}
+
+	result, err := invoice.Generate(
+		user.NewMockFinderForUser(testUser),
+		string(testUser.Phone),
+		_timePeriod,
+		invoice.Config{Tariff: tariff.Default(), Plans: plans, Promotions: promotions},
+		[]call.Call{internationalCall, internationalCall},
+	)
+	require.NoError(t, err)
+
+	// 60 of the 90 seconds are covered, and the discount applies to the other
+	// 30, which cost $30
+	require.Len(t, result.Calls, 2)
+	assert.Equal(t, ars("27"), result.Calls[0].Amount)
+	assert.Equal(t, []string{"allowance_international", "ten_off"}, result.Calls[0].Promotions)
+	assert.Equal(t, ars("81"), result.Calls[1].Amount)
+	assert.Equal(t, []string{"ten_off"}, result.Calls[1].Promotions)
+
+	expectedAllowances := []invoice.InvoiceAllowance{
+		{CallType: tariff.TypeInternational, Included: 60, Consumed: 60, Remaining: 0},
+	}
+	assert.Equal(t, expectedAllowances, result.Allowances)
+}
+
+func TestUnknownPlanShouldReturnAnError(t *testing.T) {
+	testUser := user.User{Phone: "+5491111111111", Plan: "premium"}
+
+	_, err := invoice.Generate(
+		user.NewMockFinderForUser(testUser),
+		string(testUser.Phone),
+		_timePeriod,
+		invoice.Config{Tariff: tariff.Default()},
+		nil,
+	)
+	assert.EqualError(t, err, `user plan: unknown plan "premium"`)
+}
+
+func TestChargesOfTheUserAreItemsOfTheInvoice(t *testing.T) {
+	// Recurring and one-off charges are items along with the calls, and taxes
+	// that don't have call types apply to them too.
+	testUser := user.User{Name: "Antonio Banderas", Phone: "+5491111111111"}
+
+	charges, err := charge.Load([]byte(`
+users:
+  "+5491111111111":
+    recurring:
+      - {description: Plan básico, unit_price: 1500}
+    one_off:
+      - {description: Reemplazo de SIM, unit_price: 100, quantity: 2, date: 2022-11-15}
+`))
+	require.NoError(t, err)
+
+	taxes := tax.Taxes{
+		Currency: tariff.DefaultCurrency,
+		Taxes: []tax.Tax{
+			{Name: "IVA", Percentage: dec("10")},
+			{Name: "Tasa nacional", Percentage: dec("10"), CallTypes: []string{tariff.TypeNational}},
+		},
+	}
+
+	// November 1st to 16th, 15 of its 30 days
+	period := timeutil.Period{
+		Start: time.Date(2022, time.November, 1, 0, 0, 0, 0, time.UTC),
+		End:   time.Date(2022, time.November, 16, 0, 0, 0, 0, time.UTC),
+	}
+
+	nationalCall := call.Call{
+		DestinationPhone: "+5491111111112",
+		SourcePhone:      string(testUser.Phone),
+		Duration:         30,
+		Date:             time.Date(2022, time.November, 2, 10, 0, 0, 0, time.UTC),
+	}
+
+	result, err := invoice.Generate(
+		user.NewMockFinderForUser(testUser),
+		string(testUser.Phone),
+		period,
+		invoice.Config{Tariff: tariff.Default(), Taxes: taxes, Charges: charges},
+		[]call.Call{nationalCall},
+	)
+	require.NoError(t, err)
+
+	expectedItems := []invoice.InvoiceItem{
+		{Type: invoice.ItemTypeCalls, Description: "national calls", Quantity: 1, Amount: ars("2.50")},
+		{
+			Type:        charge.TypeRecurring,
+			Description: "Plan básico",
+			Quantity:    1,
+			UnitPrice:   ptr(ars("1500")),
+			Amount:      ars("750"),
+			Proration:   &invoice.InvoiceProration{Days: 15, MonthDays: 30},
+		},
+		{Type: charge.TypeOneOff, Description: "Reemplazo de SIM", Quantity: 2, UnitPrice: ptr(ars("100")), Amount: ars("200")},
+	}
+	assert.Equal(t, expectedItems, result.Items)
+	assert.Equal(t, ars("952.50"), result.Subtotal)
+
+	assert.Equal(t, []invoice.InvoiceTax{
+		{Name: "IVA", Base: ars("952.50"), Percentage: ptr(dec("10")), Amount: ars("95.25")},
+		{Name: "Tasa nacional", Base: ars("2.50"), Percentage: ptr(dec("10")), Amount: ars("0.25")},
+	}, result.Taxes)
+	assert.Equal(t, ars("1048"), result.InvoiceTotal)
+}
+
+func TestTaxesOfCallTypesNamedLikeChargesDoNotApplyToCharges(t *testing.T) {
+	testUser := user.User{Name: "Antonio Banderas", Phone: "+5491111111111"}
+
+	// Calls to the voicemail are a type of call with the name of a type of charge,
+	// priced like national ones
+	types := call.DefaultRegistry()
+	err := types.Register(charge.TypeRecurring, tariff.TypeNational, func(c call.Call, _ tariff.Tariff) (call.Type, bool) {
+		return call.NewPricedCall(c, charge.TypeRecurring, tariff.TypeNational), c.DestinationPhone == "+8816123456"
+	})
+	require.NoError(t, err)
+
+	charges, err := charge.Load([]byte(`
+users:
+  "+5491111111111":
+    recurring:
+      - {description: Plan básico, unit_price: 1500}
+`))
+	require.NoError(t, err)
+
+	taxes, err := tax.Load([]byte(`
+taxes:
+  - name: Tasa de buzón
+    percentage: 10
+    call_types: [recurring]
+`), types.CallTypes())
+	require.NoError(t, err)
+
+	voicemail := call.Call{
+		DestinationPhone: "+8816123456",
+		SourcePhone:      string(testUser.Phone),
+		Duration:         30,
+		Date:             _timeInPeriod,
+	}
+
+	result, err := invoice.Generate(
+		user.NewMockFinderForUser(testUser),
+		string(testUser.Phone),
+		_timePeriod,
+		invoice.Config{Tariff: tariff.Default(), Taxes: taxes, Charges: charges, Types: types},
+		[]call.Call{voicemail},
+	)
+	require.NoError(t, err)
+
+	require.Len(t, result.Items, 2)
+	assert.Equal(t, "recurring calls", result.Items[0].Description)
+	assert.Equal(t, charge.TypeRecurring, result.Items[1].Type)
+	assert.Equal(t, []invoice.InvoiceTax{
+		{Name: "Tasa de buzón", Base: ars("2.50"), Percentage: ptr(dec("10")), Amount: ars("0.25")},
+	}, result.Taxes, "only the calls are the base of the tax")
+}
+
+func TestChargesAndFixedTaxesAreConvertedAtTheEndOfThePeriod(t *testing.T) {
+	// The end of November is the last instant of it, so the rate of December
+	// 1st isn't effective yet.
+	testUser := user.User{Name: "Antonio Banderas", Phone: "+5491111111111", Currency: "ARS"}
+
+	charges, err := charge.Load([]byte(`
+currency: USD
+users:
+  "+5491111111111":
+    recurring:
+      - {description: Plan básico, unit_price: 10}
+`))
+	require.NoError(t, err)
+
+	taxes := tax.Taxes{Currency: "USD", Taxes: []tax.Tax{{Name: "Cargo fijo", Fixed: dec("1")}}}
+
+	rates := exchange.New(
+		exchange.Rate{Date: time.Date(2022, time.November, 1, 0, 0, 0, 0, time.UTC), From: "USD", To: "ARS", Rate: dec("150")},
+		exchange.Rate{Date: time.Date(2022, time.December, 1, 0, 0, 0, 0, time.UTC), From: "USD", To: "ARS", Rate: dec("200")},
+	)
+
+	november := timeutil.Period{
+		Start: time.Date(2022, time.November, 1, 0, 0, 0, 0, time.UTC),
+		End:   time.Date(2022, time.December, 1, 0, 0, 0, 0, time.UTC),
+	}
+
+	result, err := invoice.Generate(
+		user.NewMockFinderForUser(testUser),
+		string(testUser.Phone),
+		november,
+		invoice.Config{Tariff: tariff.Default(), Taxes: taxes, Charges: charges, Rates: rates},
+		nil,
+	)
+	require.NoError(t, err)
+
+	require.Len(t, result.Items, 1)
+	assert.Equal(t, ars("1500"), result.Items[0].Amount)
+	assert.Equal(t, "2022-11-01", result.Items[0].Conversion.RateDate)
+
+	require.Len(t, result.Taxes, 1)
+	assert.Equal(t, ars("150"), result.Taxes[0].Amount)
+}
+
+func TestInvoiceJSONHasTotalsOfEachType(t *testing.T) {
+	// Types that aren't built-in are rendered like the others, so adding one
+	// doesn't require changing the invoice.
+	totals := call.NewTotalCallDurations(call.DefaultRegistry().IDs()...)
+	totals.Register(tariff.TypeNational, 60)
+	totals.Register("satellite", 30)
+
+	billed := call.NewTotalCallDurations()
+	billed.Register("satellite", 60)
+
+	result, err := json.Marshal(invoice.Invoice{
+		User:               invoice.InvoiceUser{Phone: "+5491111111111"},
+		Currency:           tariff.DefaultCurrency,
+		TotalSeconds:       totals,
+		TotalBilledSeconds: billed,
+		Subtotal:           ars("1"),
+		InvoiceTotal:       ars("1"),
+	})
+	require.NoError(t, err)
+
+	assert.JSONEq(t, `{
+		"user": {"address": "", "name": "", "phone_number": "+5491111111111"},
+		"billing_period": {"start": "", "end": "", "time_zone": ""},
+		"currency": "ARS",
+		"calls": null,
+		"total_international_seconds": 0,
+		"total_interplanetary_seconds": 0,
+		"total_national_seconds": 60,
+		"total_friends_seconds": 0,
+		"total_satellite_seconds": 30,
+		"total_satellite_billed_seconds": 60,
+		"items": null,
+		"subtotal": "1.00",
+		"total": "1.00"
+	}`, string(result))
+}
+
 func TestInvalidPhoneNumberShouldReturnAnError(t *testing.T) {
 	testUser := user.User{
 		Name:    "Antonio Banderas",
@@ -314,8 +1367,8 @@ func TestInvalidPhoneNumberShouldReturnAnError(t *testing.T) {
 		Phone:   "+5491111111111",
 	}
 
-	_, err := invoice.Generate(user.NewMockFinderForUser(testUser), "invalido", _timePeriod, []call.Call{})
-	assert.EqualError(t, err, "user phone number: invalid format, should match \\+[0-9]{12,13}")
+	_, err := invoice.Generate(user.NewMockFinderForUser(testUser), "invalido", _timePeriod, invoice.Config{Tariff: tariff.Default()}, []call.Call{})
+	assert.EqualError(t, err, "user phone number: invalid number \"invalido\": must start with +")
 }
 
 func TestUserNotFoundShouldReturnAnError(t *testing.T) {
@@ -326,13 +1379,14 @@ func TestUserNotFoundShouldReturnAnError(t *testing.T) {
 	}
 
 	// Different phone number than configured
-	_, err := invoice.Generate(user.NewMockFinderForUser(testUser), "+5491111111112", _timePeriod, []call.Call{})
+	_, err := invoice.Generate(user.NewMockFinderForUser(testUser), "+5491111111112", _timePeriod, invoice.Config{Tariff: tariff.Default()}, []call.Call{})
 	assert.EqualError(t, err, "finding user: user not found")
 }
 
 type expectedCall struct {
-	call call.Call
-	cost float64
+	call       call.Call
+	cost       money.Money
+	promotions []string
 }
 
 type expectedTotalSeconds struct {
@@ -349,35 +1403,77 @@ type expectedTotalSeconds struct {
 // struct de Invoice en todos los tests, que terminaba repitiendo mucho código.
 func assertInvoiceIsExpected(t *testing.T, actualInvoice invoice.Invoice, expectedUser user.User, expectedCalls []expectedCall, expectedSeconds expectedTotalSeconds) {
 	var expectedInvoiceCalls []invoice.InvoiceCall
-	var expectedTotal float64
+	var expectedItems []invoice.InvoiceItem
+	expectedTotal := money.Zero(tariff.DefaultCurrency)
 
 	for _, expectedCall := range expectedCalls {
+		expectedItems = addExpectedCallItem(
+			expectedItems,
+			expectedCall.call.Type(expectedUser.Friends, tariff.Default()).Name(),
+			expectedCall.cost,
+		)
+
 		expectedInvoiceCalls = append(expectedInvoiceCalls, invoice.InvoiceCall{
 			DestinationPhone: expectedCall.call.DestinationPhone,
 			Duration:         expectedCall.call.Duration,
+			BilledDuration:   expectedCall.call.Duration,
 			Timestamp:        expectedCall.call.Date.Format(timeutil.LayoutISO8601),
 			Amount:           expectedCall.cost,
+			Promotions:       expectedCall.promotions,
 		})
 
-		expectedTotal += expectedCall.cost
+		expectedTotal = expectedTotal.Add(expectedCall.cost)
 	}
 
+	expectedDurations := call.NewTotalCallDurations(call.DefaultRegistry().IDs()...)
+	expectedDurations.Register(tariff.TypeInternational, expectedSeconds.international)
+	expectedDurations.Register(tariff.TypeNational, expectedSeconds.national)
+	expectedDurations.Register(call.TypeFriends, expectedSeconds.friends)
+
 	expectedInvoice := invoice.Invoice{
 		User: invoice.InvoiceUser{
 			Address: expectedUser.Address,
 			Name:    expectedUser.Name,
 			Phone:   string(expectedUser.Phone),
 		},
-		Calls:                     expectedInvoiceCalls,
-		TotalInternationalSeconds: expectedSeconds.international,
-		TotalNationalSeconds:      expectedSeconds.national,
-		TotalFriendsSeconds:       expectedSeconds.friends,
-		InvoiceTotal:              expectedTotal,
+		BillingPeriod: invoice.InvoicePeriod{
+			Start:    "2022-01-01T00:00:00Z",
+			End:      "2022-12-31T00:00:00Z",
+			TimeZone: "UTC",
+		},
+		Currency:     tariff.DefaultCurrency,
+		Calls:        expectedInvoiceCalls,
+		TotalSeconds: expectedDurations,
+
+		// The default tariff bills the exact duration of calls
+		TotalBilledSeconds: expectedDurations,
+
+		Items:        expectedItems,
+		Subtotal:     expectedTotal,
+		InvoiceTotal: expectedTotal,
 	}
 
 	assert.Equal(t, expectedInvoice, actualInvoice)
 }
 
+// addExpectedCallItem adds the cost of a call to the item of its type.
+func addExpectedCallItem(items []invoice.InvoiceItem, callType string, cost money.Money) []invoice.InvoiceItem {
+	for i := range items {
+		if items[i].Description == callType+" calls" {
+			items[i].Quantity++
+			items[i].Amount = items[i].Amount.Add(cost)
+			return items
+		}
+	}
+
+	return append(items, invoice.InvoiceItem{
+		Type:        invoice.ItemTypeCalls,
+		Description: callType + " calls",
+		Quantity:    1,
+		Amount:      cost,
+	})
+}
+
 func mustParse(layout string, value string) time.Time {
 	t, err := time.Parse(layout, value)
 	if err != nil {
@@ -386,3 +1482,22 @@ func mustParse(layout string, value string) time.Time {
 
 	return t
 }
+
+// ars returns an amount of money in the currency of the tariff
+func ars(amount string) money.Money {
+	return money.MustParse(amount, tariff.DefaultCurrency)
+}
+
+// pesos returns an amount of whole pesos. With the default tariff international
+// calls cost one per second.
+func pesos(seconds uint) money.Money {
+	return money.New(int64(seconds)*100, tariff.DefaultCurrency)
+}
+
+func dec(value string) money.Decimal {
+	return money.MustParseDecimal(value)
+}
+
+func ptr[T any](value T) *T {
+	return &value
+}
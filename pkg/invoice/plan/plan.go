@@ -0,0 +1,92 @@
+// Package plan implements the plans users subscribe to, which include
+// allowances of minutes of calls. They are declared in a configuration file,
+// like tariffs.
+package plan
+
+import (
+	"fmt"
+	"invoice-generator/pkg/invoice/call"
+	"invoice-generator/pkg/invoice/tariff"
+	"invoice-generator/pkg/platform/config"
+	"invoice-generator/pkg/platform/sliceutil"
+	"strings"
+)
+
+// Plans are the plans users can subscribe to, by name. Users without a plan
+// pay for every call.
+//
+// Example file:
+//
+//	plans:
+//	  basico:
+//	    allowances:
+//	      national: 300 # minutes per invoice
+//	      international: 60
+type Plans struct {
+	Plans map[string]Plan `yaml:"plans"`
+}
+
+// A Plan includes allowances of minutes for types of calls, by their name in
+// tariffs (e.g. national).
+type Plan struct {
+	Allowances map[string]uint `yaml:"allowances"`
+}
+
+// Load loads the plans from the content of a plans file, which can only have
+// allowances of the types of calls.
+func Load(content []byte, types tariff.CallTypes) (Plans, error) {
+	var plans Plans
+	doc, err := config.Decode(content, &plans)
+	if err != nil {
+		return Plans{}, err
+	}
+
+	for _, name := range sliceutil.SortedKeys(plans.Plans) {
+		plan := plans.Plans[name]
+		for _, callType := range sliceutil.SortedKeys(plan.Allowances) {
+			minutes := plan.Allowances[callType]
+			line := doc.Line("plans", name, "allowances", callType)
+			if !sliceutil.Contains(types.Names(), callType) {
+				return Plans{}, config.Errorf(
+					line,
+					"unknown call type %q, should be one of %s", callType, strings.Join(types.Names(), ", "),
+				)
+			}
+
+			if minutes == 0 {
+				return Plans{}, config.Errorf(line, "allowance of %s calls must be positive", callType)
+			}
+		}
+	}
+
+	return plans, nil
+}
+
+// Find returns the plan with the name. An empty name is no plan, which has no
+// allowances.
+func (p Plans) Find(name string) (Plan, error) {
+	if name == "" {
+		return Plan{}, nil
+	}
+
+	plan, ok := p.Plans[name]
+	if !ok {
+		return Plan{}, fmt.Errorf("unknown plan %q", name)
+	}
+
+	return plan, nil
+}
+
+// Build returns the allowances of the plan, in the order of the types of calls.
+// Allowances keep track of how much of them was consumed, so they must be
+// built for each invoice.
+func (p Plan) Build(types tariff.CallTypes) []*call.Allowance {
+	var allowances []*call.Allowance
+	for _, callType := range types.Names() {
+		if minutes, ok := p.Allowances[callType]; ok {
+			allowances = append(allowances, call.NewAllowance(callType, minutes*60))
+		}
+	}
+
+	return allowances
+}
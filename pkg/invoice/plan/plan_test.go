@@ -0,0 +1,86 @@
+package plan_test
+
+import (
+	"invoice-generator/pkg/invoice/plan"
+	"invoice-generator/pkg/invoice/tariff"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestLoad(t *testing.T) {
+	loaded, err := plan.Load([]byte(`
+plans:
+  basico:
+    allowances:
+      international: 60
+      national: 300
+  sin_minutos: {}
+`), tariff.CallTypes{})
+	require.NoError(t, err)
+
+	basico, err := loaded.Find("basico")
+	require.NoError(t, err)
+	assert.Equal(t, map[string]uint{tariff.TypeNational: 300, tariff.TypeInternational: 60}, basico.Allowances)
+
+	allowances := basico.Build(tariff.CallTypes{})
+	require.Len(t, allowances, 2)
+	assert.Equal(t, tariff.TypeNational, allowances[0].CallType(), "allowances are in the order of the call types")
+	assert.Equal(t, uint(300*60), allowances[0].Included())
+	assert.Equal(t, uint(300*60), allowances[0].Remaining())
+	assert.Equal(t, tariff.TypeInternational, allowances[1].CallType())
+
+	sinMinutos, err := loaded.Find("sin_minutos")
+	require.NoError(t, err)
+	assert.Empty(t, sinMinutos.Build(tariff.CallTypes{}))
+}
+
+func TestFind(t *testing.T) {
+	noPlan, err := plan.Plans{}.Find("")
+	require.NoError(t, err)
+	assert.Empty(t, noPlan.Build(tariff.CallTypes{}), "users without a plan have no allowances")
+
+	_, err = plan.Plans{}.Find("premium")
+	assert.EqualError(t, err, `unknown plan "premium"`)
+}
+
+func TestLoadErrors(t *testing.T) {
+	tests := []struct {
+		name    string
+		content string
+		err     string
+	}{
+		{
+			name: "unknown call type",
+			content: `plans:
+  basico:
+    allowances:
+      satellite: 10`,
+			err: `line 4: unknown call type "satellite", should be one of national, international, interplanetary`,
+		},
+		{
+			name: "empty allowance",
+			content: `plans:
+  basico:
+    allowances:
+      national: 0`,
+			err: `line 4: allowance of national calls must be positive`,
+		},
+		{
+			name: "negative allowance",
+			content: `plans:
+  basico:
+    allowances:
+      national: -10`,
+			err: "line 4: cannot unmarshal !!int `-10` into uint",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := plan.Load([]byte(tt.content), tariff.CallTypes{})
+			assert.EqualError(t, err, tt.err)
+		})
+	}
+}
@@ -0,0 +1,435 @@
+// Package promotion implements configurable promotions, so that a new marketing
+// campaign doesn't require a new call.Promotion. They are declared in a
+// configuration file, like tariffs.
+package promotion
+
+import (
+	"invoice-generator/pkg/invoice/call"
+	"invoice-generator/pkg/invoice/tariff"
+	"invoice-generator/pkg/platform/config"
+	"invoice-generator/pkg/platform/money"
+	"invoice-generator/pkg/platform/sliceutil"
+	"invoice-generator/pkg/platform/timeutil"
+	"regexp"
+	"strings"
+	"time"
+
+	"gopkg.in/yaml.v3"
+)
+
+// Config are the configured promotions.
+//
+// Example file:
+//
+//	mercosur:
+//	  percentage: 20
+//	  associates: [CL, BO]
+//	discounts:
+//	  - name: weekend_international
+//	    policy: best_price
+//	    match:
+//	      call_types: [international]
+//	      destinations: ["+1", "+44"]
+//	      time_zone: America/Argentina/Buenos_Aires
+//	      windows:
+//	        - days: [saturday, sunday]
+//	    percentage: 30
+//	  - name: national_discount
+//	    match: {call_types: [national]}
+//	    amount_off: 0.5
+//	    valid: {from: 2022-11-01, until: 2022-12-01}
+//	  - name: holidays
+//	    match: {holidays: true}
+//	    percentage: 50
+type Config struct {
+	Mercosur  *Mercosur  `yaml:"mercosur"` // Disabled if not configured
+	Discounts []Discount `yaml:"discounts"`
+}
+
+// Mercosur configures the built-in discount on international calls to Mercosur
+// countries. See call.NewPromotionMercosur.
+type Mercosur struct {
+	// Members are ISO 3166-1 alpha-2 codes, defaults to call.MercosurMembers
+	Members    []string      `yaml:"members"`
+	Associates []string      `yaml:"associates"`
+	Percentage money.Decimal `yaml:"percentage"`
+	Policy     string        `yaml:"policy"` // See Policies, defaults to stackable
+	Valid      Validity      `yaml:"valid"`
+}
+
+// A Discount takes either a percentage or a fixed amount off the cost of the
+// calls it matches. Calls never cost less than zero.
+type Discount struct {
+	Name       string        `yaml:"name"`
+	Policy     string        `yaml:"policy"` // See Policies, defaults to stackable
+	Match      Matcher       `yaml:"match"`
+	Percentage money.Decimal `yaml:"percentage"`
+	AmountOff  money.Decimal `yaml:"amount_off"` // In the currency of the cost of the call
+	Valid      Validity      `yaml:"valid"`
+}
+
+// Validity is when a promotion is valid: calls made from the start of From
+// until the start of Until get it. Dates are days in the location of the
+// billing period (see Validity.In). Dates that aren't set don't limit it, so by
+// default promotions are always valid.
+type Validity struct {
+	From  Date `yaml:"from"`
+	Until Date `yaml:"until"`
+}
+
+// A Date is a day written as AAAA-MM-DD, at its start in UTC until it's
+// resolved in a location.
+type Date struct {
+	time.Time
+}
+
+// A Matcher matches calls by type, destination and time. Empty criteria match
+// all calls.
+type Matcher struct {
+	CallTypes []string `yaml:"call_types"`
+
+	// Destinations are E.164 prefixes (e.g. +1, +5511)
+	Destinations []string `yaml:"destinations"`
+
+	// Windows in which calls are made, in the time zone (UTC by default).
+	// Holidays are considered Sundays, like in time bands.
+	TimeZone tariff.Location `yaml:"time_zone"`
+	Windows  []tariff.Window `yaml:"windows"`
+
+	// Holidays makes only calls made on holidays match
+	Holidays bool `yaml:"holidays"`
+}
+
+// Policies are the names of the policies in files.
+var Policies = map[string]call.Policy{
+	"exclusive":  call.PolicyExclusive,
+	"stackable":  call.PolicyStackable,
+	"best_price": call.PolicyBestPrice,
+}
+
+var (
+	prefixFormat = regexp.MustCompile(`^\+[0-9]+$`)
+	regionFormat = regexp.MustCompile(`^[A-Z]{2}$`)
+	hundred      = money.MustParseDecimal("100")
+)
+
+// Load loads the promotions from the content of a promotions file, which can
+// only refer to the types of calls.
+func Load(content []byte, types tariff.CallTypes) (Config, error) {
+	var cfg Config
+	doc, err := config.Decode(content, &cfg)
+	if err != nil {
+		return Config{}, err
+	}
+
+	names := make(map[string]bool)
+	if cfg.Mercosur != nil {
+		if err := cfg.Mercosur.validate(doc); err != nil {
+			return Config{}, err
+		}
+
+		names[mercosurName] = true
+	}
+
+	for i, discount := range cfg.Discounts {
+		path := []interface{}{"discounts", i}
+		line := func(elems ...interface{}) int {
+			return doc.Line(append(path, elems...)...)
+		}
+
+		if discount.Name == "" {
+			return Config{}, config.Errorf(line(), "discount must have a name")
+		}
+
+		if names[discount.Name] {
+			return Config{}, config.Errorf(line("name"), "duplicated promotion %q", discount.Name)
+		}
+		names[discount.Name] = true
+
+		if err := validatePolicy(doc, discount.Policy, append(path, "policy")...); err != nil {
+			return Config{}, err
+		}
+
+		if discount.Percentage.IsZero() == discount.AmountOff.IsZero() {
+			return Config{}, config.Errorf(line(), "discount %q must have either a percentage or an amount off", discount.Name)
+		}
+
+		if discount.Percentage.Sign() < 0 || discount.AmountOff.Sign() < 0 {
+			return Config{}, config.Errorf(line(), "discount %q can't be negative", discount.Name)
+		}
+
+		if discount.Percentage.Cmp(hundred) > 0 {
+			return Config{}, config.Errorf(line("percentage"), "percentage can't be more than 100")
+		}
+
+		if err := discount.Match.validate(doc, types, append(path, "match")...); err != nil {
+			return Config{}, err
+		}
+
+		if err := discount.Valid.validate(doc, append(path, "valid")...); err != nil {
+			return Config{}, err
+		}
+	}
+
+	return cfg, nil
+}
+
+// mercosurName is the name of the Mercosur promotion, so discounts can't use it
+const mercosurName = "mercosur"
+
+func (m Mercosur) validate(doc config.Document) error {
+	if err := validatePolicy(doc, m.Policy, "mercosur", "policy"); err != nil {
+		return err
+	}
+
+	if m.Percentage.Sign() <= 0 || m.Percentage.Cmp(hundred) > 0 {
+		return config.Errorf(doc.Line("mercosur", "percentage"), "percentage must be more than 0 and at most 100")
+	}
+
+	if err := validateCountries(doc, m.Members, "mercosur", "members"); err != nil {
+		return err
+	}
+
+	if err := validateCountries(doc, m.Associates, "mercosur", "associates"); err != nil {
+		return err
+	}
+
+	return m.Valid.validate(doc, "mercosur", "valid")
+}
+
+func (v Validity) validate(doc config.Document, path ...interface{}) error {
+	if !v.From.IsZero() && !v.Until.IsZero() && !v.Until.After(v.From.Time) {
+		return config.Errorf(doc.Line(append(path, "until")...), "valid until must be after valid from")
+	}
+
+	return nil
+}
+
+func validateCountries(doc config.Document, countries []string, path ...interface{}) error {
+	for i, country := range countries {
+		if !regionFormat.MatchString(country) {
+			return config.Errorf(
+				doc.Line(append(path, i)...),
+				"invalid country %q, should be an ISO 3166-1 alpha-2 code (e.g. AR)", country,
+			)
+		}
+	}
+
+	return nil
+}
+
+func validatePolicy(doc config.Document, policy string, path ...interface{}) error {
+	if _, ok := Policies[policy]; policy != "" && !ok {
+		return config.Errorf(
+			doc.Line(path...),
+			"invalid policy %q, should be one of %s", policy, strings.Join(policyNames(), ", "),
+		)
+	}
+
+	return nil
+}
+
+func (m Matcher) validate(doc config.Document, types tariff.CallTypes, path ...interface{}) error {
+	line := func(elems ...interface{}) int {
+		return doc.Line(append(path, elems...)...)
+	}
+
+	for i, callType := range m.CallTypes {
+		if !sliceutil.Contains(types.Names(), callType) {
+			return config.Errorf(
+				line("call_types", i),
+				"unknown call type %q, should be one of %s", callType, strings.Join(types.Names(), ", "),
+			)
+		}
+	}
+
+	for i, prefix := range m.Destinations {
+		if !prefixFormat.MatchString(prefix) {
+			return config.Errorf(line("destinations", i), "invalid destination prefix %q, should match %s", prefix, prefixFormat.String())
+		}
+	}
+
+	for i, window := range m.Windows {
+		if err := window.Validate(); err != nil {
+			return config.Errorf(line("windows", i), "%s", err)
+		}
+	}
+
+	return nil
+}
+
+// Build returns the configured promotions, in order, with their validity in
+// the location of the billing period. Promotions may have state, so they must
+// be built for each invoice.
+func (c Config) Build(loc *time.Location) []call.Promotion {
+	var promotions []call.Promotion
+	if c.Mercosur != nil {
+		promotions = append(promotions, valid(c.Mercosur.promotion(), c.Mercosur.Valid.In(loc)))
+	}
+
+	for _, discount := range c.Discounts {
+		promotions = append(promotions, valid(discountPromotion{discount: discount}, discount.Valid.In(loc)))
+	}
+
+	return promotions
+}
+
+func (m Mercosur) promotion() call.Promotion {
+	members := m.Members
+	if len(members) == 0 {
+		members = call.MercosurMembers
+	}
+
+	countries := append(append([]string{}, members...), m.Associates...)
+	return call.NewPromotionMercosur(countries, m.Percentage, policy(m.Policy))
+}
+
+// Matches returns whether the call, of the specified type, matches all the
+// criteria.
+func (m Matcher) Matches(c call.Call, callType string) bool {
+	return m.matchesType(callType) &&
+		m.matchesDestination(c.DestinationPhone) &&
+		m.matchesTime(c.Date, c.Holidays()) &&
+		(!m.Holidays || c.Holiday != "")
+}
+
+func (m Matcher) matchesType(callType string) bool {
+	return len(m.CallTypes) == 0 || sliceutil.Contains(m.CallTypes, callType)
+}
+
+func (m Matcher) matchesDestination(destinationPhone string) bool {
+	if len(m.Destinations) == 0 {
+		return true
+	}
+
+	for _, prefix := range m.Destinations {
+		if strings.HasPrefix(destinationPhone, prefix) {
+			return true
+		}
+	}
+
+	return false
+}
+
+func (m Matcher) matchesTime(date time.Time, holidays tariff.Holidays) bool {
+	if len(m.Windows) == 0 {
+		return true
+	}
+
+	local := m.TimeZone.In(date)
+	for _, window := range m.Windows {
+		if window.Contains(local, holidays) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// In returns the validity with its dates starting at midnight in the location,
+// rather than in UTC.
+func (v Validity) In(loc *time.Location) Validity {
+	return Validity{From: v.From.in(loc), Until: v.Until.in(loc)}
+}
+
+func (d Date) in(loc *time.Location) Date {
+	if d.IsZero() {
+		return d
+	}
+
+	return Date{Time: timeutil.Midnight(d.Time, loc)}
+}
+
+// Contains returns whether the promotion is valid at the specified time.
+func (v Validity) Contains(t time.Time) bool {
+	afterFrom := v.From.IsZero() || !t.Before(v.From.Time)
+	beforeUntil := v.Until.IsZero() || t.Before(v.Until.Time)
+
+	return afterFrom && beforeUntil
+}
+
+// Apply returns the cost with the discount taken off, never less than zero. It
+// returns an error if the cost is out of range.
+func (d Discount) Apply(cost money.Money) (money.Money, error) {
+	discount := money.FromDecimal(d.AmountOff, cost.Currency())
+	if !d.Percentage.IsZero() {
+		var err error
+		discount, err = cost.Percent(d.Percentage)
+		if err != nil {
+			return money.Money{}, err
+		}
+	}
+
+	discounted := cost.Sub(discount)
+	if discounted.Sign() < 0 {
+		return money.Zero(cost.Currency()), nil
+	}
+
+	return discounted, nil
+}
+
+// discountPromotion is a call.Promotion for a Discount. Discounts don't have
+// state, so using them doesn't change anything.
+type discountPromotion struct {
+	discount Discount
+}
+
+// Verify interface compliance
+var _ call.Promotion = discountPromotion{}
+
+func (p discountPromotion) Name() string { return p.discount.Name }
+
+func (p discountPromotion) Policy() call.Policy { return policy(p.discount.Policy) }
+
+func (p discountPromotion) AppliesTo(c call.Call, callType call.Type) bool {
+	return p.discount.Match.Matches(c, callType.Name())
+}
+
+func (p discountPromotion) Apply(_ call.Call, cost money.Money) (money.Money, error) {
+	return p.discount.Apply(cost)
+}
+
+func (p discountPromotion) Used(_ call.Call) {}
+
+// validPromotion is a promotion that only applies to calls made while it's
+// valid.
+type validPromotion struct {
+	call.Promotion
+	validity Validity
+}
+
+// valid limits the promotion to its validity, if it has one.
+func valid(promo call.Promotion, validity Validity) call.Promotion {
+	if validity == (Validity{}) {
+		return promo
+	}
+
+	return validPromotion{Promotion: promo, validity: validity}
+}
+
+func (p validPromotion) AppliesTo(c call.Call, callType call.Type) bool {
+	return p.validity.Contains(c.Date) && p.Promotion.AppliesTo(c, callType)
+}
+
+func (d *Date) UnmarshalYAML(node *yaml.Node) error {
+	date, err := time.Parse(timeutil.LayoutDate, node.Value)
+	if err != nil {
+		return config.Errorf(node.Line, "invalid date %q, expected AAAA-MM-DD", node.Value)
+	}
+
+	d.Time = date
+	return nil
+}
+
+// policy returns the policy of the name, stackable by default.
+func policy(name string) call.Policy {
+	if policy, ok := Policies[name]; ok {
+		return policy
+	}
+
+	return call.PolicyStackable
+}
+
+func policyNames() []string {
+	return sliceutil.SortedKeys(Policies)
+}
@@ -0,0 +1,352 @@
+package promotion_test
+
+import (
+	"invoice-generator/pkg/invoice/call"
+	"invoice-generator/pkg/invoice/promotion"
+	"invoice-generator/pkg/invoice/tariff"
+	"invoice-generator/pkg/platform/money"
+	"invoice-generator/pkg/user"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+const promotions = `
+discounts:
+  - name: weekend_usa
+    policy: best_price
+    match:
+      call_types: [international]
+      destinations: ["+1"]
+      time_zone: America/Argentina/Buenos_Aires
+      windows:
+        - days: [saturday, sunday]
+    percentage: 30
+  - name: national_discount
+    match: {call_types: [national]}
+    amount_off: 0.5
+`
+
+var _user = user.User{Phone: "+5491111111111"}
+
+func TestDiscountsMatchCalls(t *testing.T) {
+	loaded, err := promotion.Load([]byte(promotions), tariff.CallTypes{})
+	require.NoError(t, err)
+
+	built := loaded.Build(time.UTC)
+	require.Len(t, built, 2)
+
+	weekendUSA, national := built[0], built[1]
+	assert.Equal(t, "weekend_usa", weekendUSA.Name())
+	assert.Equal(t, call.PolicyBestPrice, weekendUSA.Policy())
+	assert.Equal(t, call.PolicyStackable, national.Policy())
+
+	// Times are in UTC, Buenos Aires is UTC-3
+	saturday := time.Date(2022, time.November, 12, 15, 0, 0, 0, time.UTC)
+	fridayNight := time.Date(2022, time.November, 12, 2, 0, 0, 0, time.UTC) // Fri 23:00
+
+	tests := []struct {
+		name        string
+		promotion   call.Promotion
+		destination string
+		date        time.Time
+		applies     bool
+	}{
+		{name: "matching call", promotion: weekendUSA, destination: "+12125551234", date: saturday, applies: true},
+		{name: "other destination", promotion: weekendUSA, destination: "+442071234567", date: saturday, applies: false},
+		{name: "outside of window in local time", promotion: weekendUSA, destination: "+12125551234", date: fridayNight, applies: false},
+		{name: "other call type", promotion: weekendUSA, destination: "+5491111111112", date: saturday, applies: false},
+		{name: "any destination and time", promotion: national, destination: "+5491111111112", date: fridayNight, applies: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			aCall := call.Call{
+				DestinationPhone: tt.destination,
+				SourcePhone:      string(_user.Phone),
+				Duration:         60,
+				Date:             tt.date,
+			}
+
+			assert.Equal(t, tt.applies, tt.promotion.AppliesTo(aCall, aCall.Type(nil, tariff.Default())))
+		})
+	}
+}
+
+func TestMercosurDiscountsInternationalCallsToMembersAndAssociates(t *testing.T) {
+	loaded, err := promotion.Load([]byte(`
+mercosur:
+  percentage: 20
+  associates: [CL]
+`), tariff.CallTypes{})
+	require.NoError(t, err)
+
+	built := loaded.Build(time.UTC)
+	require.Len(t, built, 1)
+
+	mercosur := built[0]
+	assert.Equal(t, "mercosur", mercosur.Name())
+	assert.Equal(t, call.PolicyStackable, mercosur.Policy())
+
+	tests := []struct {
+		name        string
+		destination string
+		applies     bool
+	}{
+		{name: "member", destination: "+5511912345678", applies: true},     // BR
+		{name: "other member", destination: "+59899123456", applies: true}, // UY
+		{name: "associate", destination: "+56912345678", applies: true},    // CL
+		{name: "not a member", destination: "+12125551234", applies: false},
+		{name: "national calls aren't international", destination: "+5491111111112", applies: false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			aCall := call.Call{
+				DestinationPhone: tt.destination,
+				SourcePhone:      string(_user.Phone),
+				Duration:         60,
+				Date:             time.Date(2022, time.November, 12, 15, 0, 0, 0, time.UTC),
+			}
+
+			assert.Equal(t, tt.applies, mercosur.AppliesTo(aCall, aCall.Type(nil, tariff.Default())))
+		})
+	}
+
+	ars := money.MustParse("10", tariff.DefaultCurrency)
+	discounted, err := mercosur.Apply(call.Call{}, ars)
+	require.NoError(t, err)
+	assert.Equal(t, money.MustParse("8", tariff.DefaultCurrency), discounted)
+}
+
+func TestMercosurMembersAreConfigurable(t *testing.T) {
+	loaded, err := promotion.Load([]byte(`
+mercosur:
+  percentage: 20
+  members: [BR]
+  policy: exclusive
+`), tariff.CallTypes{})
+	require.NoError(t, err)
+
+	mercosur := loaded.Build(time.UTC)[0]
+	assert.Equal(t, call.PolicyExclusive, mercosur.Policy())
+
+	toUruguay := call.Call{DestinationPhone: "+59899123456", SourcePhone: string(_user.Phone)}
+	assert.False(t, mercosur.AppliesTo(toUruguay, toUruguay.Type(nil, tariff.Default())))
+}
+
+func TestPromotionsOnlyApplyWhileValid(t *testing.T) {
+	loaded, err := promotion.Load([]byte(`
+mercosur:
+  percentage: 20
+  valid: {until: 2022-11-15}
+discounts:
+  - name: black_friday
+    percentage: 50
+    valid: {from: 2022-11-25, until: 2022-11-28}
+`), tariff.CallTypes{})
+	require.NoError(t, err)
+
+	built := loaded.Build(time.UTC)
+	require.Len(t, built, 2)
+	mercosur, blackFriday := built[0], built[1]
+	assert.Equal(t, "black_friday", blackFriday.Name())
+
+	tests := []struct {
+		name      string
+		promotion call.Promotion
+		date      time.Time
+		applies   bool
+	}{
+		{name: "before the start", promotion: blackFriday, date: time.Date(2022, time.November, 24, 23, 59, 59, 0, time.UTC), applies: false},
+		{name: "on the start", promotion: blackFriday, date: time.Date(2022, time.November, 25, 0, 0, 0, 0, time.UTC), applies: true},
+		{name: "on the end", promotion: blackFriday, date: time.Date(2022, time.November, 28, 0, 0, 0, 0, time.UTC), applies: false},
+		{name: "without start", promotion: mercosur, date: time.Date(2020, time.January, 1, 0, 0, 0, 0, time.UTC), applies: true},
+		{name: "after the end", promotion: mercosur, date: time.Date(2022, time.November, 15, 0, 0, 0, 0, time.UTC), applies: false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			aCall := call.Call{
+				DestinationPhone: "+59821234567", // Uruguay
+				SourcePhone:      string(_user.Phone),
+				Duration:         60,
+				Date:             tt.date,
+			}
+
+			assert.Equal(t, tt.applies, tt.promotion.AppliesTo(aCall, aCall.Type(nil, tariff.Default())))
+		})
+	}
+}
+
+func TestHolidayDiscounts(t *testing.T) {
+	loaded, err := promotion.Load([]byte(`
+discounts:
+  - name: feriados
+    match: {holidays: true}
+    percentage: 50
+  - name: fin_de_semana
+    match:
+      windows:
+        - days: [sunday]
+    percentage: 10
+`), tariff.CallTypes{})
+	require.NoError(t, err)
+
+	built := loaded.Build(time.UTC)
+	require.Len(t, built, 2)
+	holidays, sundays := built[0], built[1]
+
+	wednesday := call.Call{
+		DestinationPhone: "+5491111111112",
+		SourcePhone:      string(_user.Phone),
+		Duration:         60,
+		Date:             time.Date(2022, time.December, 7, 15, 0, 0, 0, time.UTC),
+	}
+
+	onHoliday := wednesday
+	onHoliday.Holiday = "Inmaculada Concepción"
+
+	callType := wednesday.Type(nil, tariff.Default())
+	assert.False(t, holidays.AppliesTo(wednesday, callType))
+	assert.True(t, holidays.AppliesTo(onHoliday, callType))
+	assert.False(t, sundays.AppliesTo(wednesday, callType))
+	assert.True(t, sundays.AppliesTo(onHoliday, callType), "holidays are like sundays")
+}
+
+func TestDiscountApply(t *testing.T) {
+	ars := func(amount string) money.Money { return money.MustParse(amount, tariff.DefaultCurrency) }
+
+	apply := func(d promotion.Discount, cost money.Money) money.Money {
+		discounted, err := d.Apply(cost)
+		require.NoError(t, err)
+		return discounted
+	}
+
+	percentage := promotion.Discount{Percentage: money.MustParseDecimal("30")}
+	assert.Equal(t, ars("7"), apply(percentage, ars("10")))
+	assert.Equal(t, ars("0.71"), apply(percentage, ars("1.01"))) // 0.303 off is rounded to 0.30
+
+	amountOff := promotion.Discount{AmountOff: money.MustParseDecimal("0.5")}
+	assert.Equal(t, ars("2"), apply(amountOff, ars("2.5")))
+	assert.Equal(t, ars("0"), apply(amountOff, ars("0.3")), "calls never cost less than zero")
+}
+
+func TestLoadErrors(t *testing.T) {
+	tests := []struct {
+		name    string
+		content string
+		err     string
+	}{
+		{
+			name: "missing name",
+			content: `discounts:
+  - percentage: 10`,
+			err: `line 2: discount must have a name`,
+		},
+		{
+			name: "duplicated name",
+			content: `discounts:
+  - {name: promo, percentage: 10}
+  - {name: promo, percentage: 20}`,
+			err: `line 3: duplicated promotion "promo"`,
+		},
+		{
+			name: "invalid policy",
+			content: `discounts:
+  - name: promo
+    policy: greedy
+    percentage: 10`,
+			err: `line 3: invalid policy "greedy", should be one of best_price, exclusive, stackable`,
+		},
+		{
+			name: "without discount",
+			content: `discounts:
+  - name: promo`,
+			err: `line 2: discount "promo" must have either a percentage or an amount off`,
+		},
+		{
+			name: "more than 100%",
+			content: `discounts:
+  - name: promo
+    percentage: 110`,
+			err: `line 3: percentage can't be more than 100`,
+		},
+		{
+			name: "unknown call type",
+			content: `discounts:
+  - name: promo
+    percentage: 10
+    match:
+      call_types: [satellite]`,
+			err: `line 5: unknown call type "satellite", should be one of national, international, interplanetary`,
+		},
+		{
+			name: "invalid destination",
+			content: `discounts:
+  - name: promo
+    percentage: 10
+    match:
+      destinations: ["1"]`,
+			err: `line 5: invalid destination prefix "1", should match ^\+[0-9]+$`,
+		},
+		{
+			name: "mercosur without percentage",
+			content: `mercosur:
+  associates: [CL]`,
+			err: `line 2: percentage must be more than 0 and at most 100`,
+		},
+		{
+			name: "mercosur invalid country",
+			content: `mercosur:
+  percentage: 10
+  associates: [CL, Bolivia]`,
+			err: `line 3: invalid country "Bolivia", should be an ISO 3166-1 alpha-2 code (e.g. AR)`,
+		},
+		{
+			name: "discount named like mercosur",
+			content: `mercosur:
+  percentage: 10
+discounts:
+  - {name: mercosur, percentage: 20}`,
+			err: `line 4: duplicated promotion "mercosur"`,
+		},
+		{
+			name: "window without days",
+			content: `discounts:
+  - name: promo
+    percentage: 10
+    match:
+      windows:
+        - from: "20:00"`,
+			err: `line 6: window must have at least one day`,
+		},
+		{
+			name: "invalid validity date",
+			content: `discounts:
+  - name: promo
+    percentage: 10
+    valid: {from: 25/11/2022}`,
+			err: `line 4: invalid date "25/11/2022", expected AAAA-MM-DD`,
+		},
+		{
+			name: "valid until before from",
+			content: `discounts:
+  - name: promo
+    percentage: 10
+    valid:
+      from: 2022-11-28
+      until: 2022-11-25`,
+			err: `line 6: valid until must be after valid from`,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := promotion.Load([]byte(tt.content), tariff.CallTypes{})
+			assert.EqualError(t, err, tt.err)
+		})
+	}
+}
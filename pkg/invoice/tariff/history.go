@@ -0,0 +1,179 @@
+package tariff
+
+import (
+	"fmt"
+	"invoice-generator/pkg/platform/config"
+	"invoice-generator/pkg/platform/timeutil"
+	"sort"
+	"time"
+)
+
+// A History has the versions of a tariff. Each version is effective from the
+// start of its date until the date of the next one, so calls are rated with the
+// prices that were effective when they were made, and past billing periods can
+// be rated again exactly as they were billed. Dates are days in the location of
+// the billing period (see History.In).
+//
+// Example file:
+//
+//	versions:
+//	  - effective_from: 2022-01-01
+//	    national: {per_call: 2.5}
+//	    international: {per_second: 1}
+//	  - effective_from: 2022-11-15
+//	    national: {per_call: 3}
+//	    international: {per_second: 1}
+//
+// Each version is a whole tariff, declared like a tariff file. All of them must
+// be in the same currency.
+type History struct {
+	versions []Version      // Sorted by date
+	location *time.Location // Of the dates, nil is UTC
+}
+
+// A Version is a tariff effective from a date on.
+type Version struct {
+	EffectiveFrom time.Time // The zero time means it was always effective
+	Tariff        Tariff
+}
+
+// NewHistory returns a history with the specified versions.
+func NewHistory(versions ...Version) History {
+	sorted := append([]Version{}, versions...)
+	sort.SliceStable(sorted, func(i, j int) bool {
+		return sorted[i].EffectiveFrom.Before(sorted[j].EffectiveFrom)
+	})
+
+	return History{versions: sorted}
+}
+
+// Single returns a history where the tariff was always effective.
+func Single(t Tariff) History {
+	return NewHistory(Version{Tariff: t})
+}
+
+// LoadHistory loads a history from the content of a tariff history file. Its
+// versions are loaded like tariffs (see Load), for the types of calls.
+func LoadHistory(content []byte, types CallTypes) (History, error) {
+	var file struct {
+		Versions []struct {
+			EffectiveFrom string `yaml:"effective_from"`
+			fileTariff    `yaml:",inline"`
+
+			// The inline map of fileTariff isn't decoded when it's inlined
+			Pricings map[string]Pricing `yaml:",inline"`
+		} `yaml:"versions"`
+	}
+
+	doc, err := config.Decode(content, &file)
+	if err != nil {
+		return History{}, err
+	}
+
+	if len(file.Versions) == 0 {
+		return History{}, config.Errorf(doc.Line(), "tariff history must have at least one version")
+	}
+
+	seen := make(map[time.Time]bool)
+	var versions []Version
+	for i, v := range file.Versions {
+		date, err := time.Parse(timeutil.LayoutDate, v.EffectiveFrom)
+		if err != nil {
+			return History{}, config.Errorf(
+				doc.Line("versions", i, "effective_from"),
+				"invalid date %q, expected AAAA-MM-DD", v.EffectiveFrom,
+			)
+		}
+
+		if seen[date] {
+			return History{}, config.Errorf(doc.Line("versions", i), "duplicated version effective from %s", v.EffectiveFrom)
+		}
+		seen[date] = true
+
+		v.fileTariff.Pricings = v.Pricings
+		t, err := v.build(doc.Sub("versions", i), types)
+		if err != nil {
+			return History{}, err
+		}
+
+		if i > 0 && t.Currency != versions[0].Tariff.Currency {
+			return History{}, config.Errorf(
+				doc.Line("versions", i, "currency"),
+				"all versions must be in the same currency, expected %s", versions[0].Tariff.Currency,
+			)
+		}
+
+		versions = append(versions, Version{EffectiveFrom: date, Tariff: t})
+	}
+
+	return NewHistory(versions...), nil
+}
+
+// In returns the history with the versions effective from the start of their
+// dates in the location, rather than in UTC.
+func (h History) In(loc *time.Location) History {
+	versions := make([]Version, len(h.versions))
+	for i, v := range h.versions {
+		if !v.EffectiveFrom.IsZero() {
+			v.EffectiveFrom = timeutil.Midnight(v.EffectiveFrom, loc)
+		}
+
+		versions[i] = v
+	}
+
+	return History{versions: versions, location: loc}
+}
+
+// IsEmpty returns whether the history has no versions.
+func (h History) IsEmpty() bool {
+	return len(h.versions) == 0
+}
+
+// Versions returns the versions of the history, sorted by date.
+func (h History) Versions() []Version {
+	return h.versions
+}
+
+// Currency returns the currency of the prices of all the versions.
+func (h History) Currency() string {
+	if h.IsEmpty() {
+		return ""
+	}
+
+	return h.versions[0].Tariff.Currency
+}
+
+// At returns the version effective at the specified time: the latest one
+// that isn't effective from after it. It isn't ok if the time is before the
+// first version.
+func (h History) At(t time.Time) (version Version, ok bool) {
+	// First version that isn't effective yet, the previous one is the effective
+	i := sort.Search(len(h.versions), func(i int) bool {
+		return h.versions[i].EffectiveFrom.After(t)
+	})
+
+	if i == 0 {
+		return Version{}, false
+	}
+
+	return h.versions[i-1], true
+}
+
+// Covers returns an error if the tariff isn't effective at the specified
+// time, or any time after it.
+func (h History) Covers(t time.Time) error {
+	if _, ok := h.At(t); !ok {
+		return fmt.Errorf("no tariff effective on %s", t.In(h.loc()).Format(timeutil.LayoutDate))
+	}
+
+	return nil
+}
+
+// loc returns the location of the dates of the versions.
+func (h History) loc() *time.Location {
+	if h.location == nil {
+		return time.UTC
+	}
+
+	return h.location
+}
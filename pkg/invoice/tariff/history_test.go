@@ -0,0 +1,146 @@
+package tariff_test
+
+import (
+	"invoice-generator/pkg/invoice/tariff"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+const history = `
+versions:
+  - effective_from: 2022-11-15
+    national: {per_call: 3}
+    international: {per_second: 1}
+  - effective_from: 2022-01-01
+    national: {per_call: 2.5}
+    international: {per_second: 1}
+`
+
+func TestHistoryUsesTheVersionEffectiveOnTheDate(t *testing.T) {
+	loaded, err := tariff.LoadHistory([]byte(history), callTypes)
+	require.NoError(t, err)
+	assert.Equal(t, tariff.DefaultCurrency, loaded.Currency())
+
+	tests := []struct {
+		name     string
+		at       time.Time
+		expected string
+	}{
+		{
+			name:     "first day of a version",
+			at:       time.Date(2022, time.January, 1, 0, 0, 0, 0, time.UTC),
+			expected: "2.5",
+		},
+		{
+			name:     "last moment before the next version",
+			at:       time.Date(2022, time.November, 14, 23, 59, 59, 0, time.UTC),
+			expected: "2.5",
+		},
+		{
+			name:     "latest version",
+			at:       time.Date(2023, time.January, 1, 0, 0, 0, 0, time.UTC),
+			expected: "3",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			version, ok := loaded.At(tt.at)
+			require.True(t, ok)
+			assert.Equal(t, dec(tt.expected), version.Tariff.National.PerCall)
+		})
+	}
+}
+
+func TestHistoryBeforeTheFirstVersion(t *testing.T) {
+	loaded, err := tariff.LoadHistory([]byte(history), callTypes)
+	require.NoError(t, err)
+
+	before := time.Date(2021, time.December, 31, 0, 0, 0, 0, time.UTC)
+	_, ok := loaded.At(before)
+	assert.False(t, ok)
+	assert.EqualError(t, loaded.Covers(before), "no tariff effective on 2021-12-31")
+}
+
+func TestSingleTariffIsAlwaysEffective(t *testing.T) {
+	single := tariff.Single(tariff.Default())
+
+	version, ok := single.At(time.Date(1970, time.January, 1, 0, 0, 0, 0, time.UTC))
+	require.True(t, ok)
+	assert.True(t, version.EffectiveFrom.IsZero())
+	assert.Equal(t, tariff.Default(), version.Tariff)
+	assert.True(t, tariff.History{}.IsEmpty())
+}
+
+func TestLoadHistoryErrors(t *testing.T) {
+	tests := []struct {
+		name    string
+		content string
+		err     string
+	}{
+		{
+			name:    "without versions",
+			content: `versions: []`,
+			err:     `line 1: tariff history must have at least one version`,
+		},
+		{
+			name: "invalid date",
+			content: `versions:
+  - effective_from: 01/11/2022
+    national: {per_call: 1}
+    international: {per_second: 1}`,
+			err: `line 2: invalid date "01/11/2022", expected AAAA-MM-DD`,
+		},
+		{
+			name: "duplicated version",
+			content: `versions:
+  - effective_from: 2022-11-01
+    national: {per_call: 1}
+    international: {per_second: 1}
+  - effective_from: 2022-11-01
+    national: {per_call: 2}
+    international: {per_second: 1}`,
+			err: `line 5: duplicated version effective from 2022-11-01`,
+		},
+		{
+			name: "invalid tariff of a version",
+			content: `versions:
+  - effective_from: 2022-11-01
+    national: {per_call: 1}
+    international: {per_second: 1}
+  - effective_from: 2022-11-15
+    national: {per_call: -1}
+    international: {per_second: 1}`,
+			err: `line 6: national.per_call can't be negative`,
+		},
+		{
+			name: "version without national pricing",
+			content: `versions:
+  - effective_from: 2022-11-01
+    international: {per_second: 1}`,
+			err: `line 2: missing pricing for national calls`,
+		},
+		{
+			name: "versions in different currencies",
+			content: `versions:
+  - effective_from: 2022-11-01
+    national: {per_call: 1}
+    international: {per_second: 1}
+  - effective_from: 2022-11-15
+    currency: USD
+    national: {per_call: 1}
+    international: {per_second: 1}`,
+			err: `line 6: all versions must be in the same currency, expected ARS`,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := tariff.LoadHistory([]byte(tt.content), callTypes)
+			assert.EqualError(t, err, tt.err)
+		})
+	}
+}
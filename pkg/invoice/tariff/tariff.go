@@ -0,0 +1,411 @@
+// Package tariff implements the prices charged for each type of call. Tariffs
+// are declared in a configuration file so that changing a price doesn't require
+// changing code.
+package tariff
+
+import (
+	"fmt"
+	"invoice-generator/pkg/platform/config"
+	"invoice-generator/pkg/platform/money"
+	"invoice-generator/pkg/platform/sliceutil"
+	"regexp"
+	"strings"
+)
+
+// DefaultCurrency is the currency of tariffs that don't declare one.
+const DefaultCurrency = "ARS"
+
+// Names of the built-in types of calls, which are also the keys of their
+// pricing in tariff files. Other types can be added (see CallTypes).
+const (
+	TypeNational       = "national"
+	TypeInternational  = "international"
+	TypeInterplanetary = "interplanetary"
+)
+
+// A Tariff has the pricing of each type of call.
+//
+// Example file:
+//
+//	currency: ARS # ISO 4217 code of the prices, defaults to ARS
+//	national:
+//	  per_call: 2.5
+//	international:
+//	  per_second: 1 # default rate
+//	  destinations:
+//	    "+1": {per_second: 0.5}
+//	    "+1876": {per_second: 2}
+//	  increments: {initial: 30, subsequent: 6}
+//
+// Optionally, it can have interplanetary calls (see InterplanetaryPricing) and
+// time bands (see TimeBands).
+//
+// The currency of the tariff is the one of all the prices, unless a pricing
+// declares its own (see Pricing), e.g. to charge international calls in USD
+// and national ones in ARS.
+//
+// Types of calls that aren't built-in are priced with the pricing of their key
+// (see CallTypes), declared like the national one:
+//
+//	satellite: {per_second: 5}
+type Tariff struct {
+	Currency       string                `yaml:"currency"`
+	National       Pricing               `yaml:"national"`
+	International  InternationalPricing  `yaml:"international"`
+	Interplanetary InterplanetaryPricing `yaml:"interplanetary"`
+	TimeBands      TimeBands             `yaml:"time_bands"`
+
+	// Pricings of the types that aren't built-in, by key
+	Pricings map[string]Pricing `yaml:",inline"`
+}
+
+// Pricing is how a type of call is priced. The cost of a call is the sum of all
+// of its components, charged over its billed duration. Prices are exact
+// decimals, so they can be more precise than the currency (e.g. 0.0125 per
+// second).
+//
+// Prices are in the currency of the tariff unless the pricing has its own. The
+// destinations of international calls without one are in the currency of the
+// default rate.
+type Pricing struct {
+	Currency  string        `yaml:"currency"`   // ISO 4217 code, empty for the one of the tariff
+	PerCall   money.Decimal `yaml:"per_call"`   // Flat amount charged once per call
+	PerSecond money.Decimal `yaml:"per_second"` // Charged for every billed second
+	PerMinute money.Decimal `yaml:"per_minute"` // Charged for every started billed minute
+
+	Increments Increments `yaml:"increments"`
+}
+
+// Increments are the blocks in which the duration of calls is billed. The first
+// Initial seconds are billed as a whole, then every started block of Subsequent
+// seconds, and calls are billed at least Minimum seconds. For example, with
+// 30/6 increments a call of 31 seconds is billed as 36.
+//
+// Unset blocks are of 1 second, so by default calls are billed by their exact
+// duration.
+type Increments struct {
+	Initial    uint `yaml:"initial"`
+	Subsequent uint `yaml:"subsequent"`
+	Minimum    uint `yaml:"minimum"`
+}
+
+// InternationalPricing prices international calls according to their
+// destination. Calls to destinations that aren't in the rate table are priced
+// with the default rate, and destinations without increments use the default
+// ones.
+type InternationalPricing struct {
+	Default      Pricing   `yaml:",inline"`
+	Destinations RateTable `yaml:"destinations"`
+}
+
+// InterplanetaryPricing prices calls to other planets, which are recognized by
+// the prefix of their destination. They're usually priced per second with a
+// surcharge (per_call). For example:
+//
+//	interplanetary:
+//	  prefix: "+881"
+//	  per_second: 10
+//	  per_call: 50 # surcharge
+//
+// Destination numbers must still be valid E.164 numbers, so the prefix should
+// be of a non geographic country code (like +881, for satellite phones). If
+// the prefix is empty there are no interplanetary calls.
+type InterplanetaryPricing struct {
+	Prefix  string  `yaml:"prefix"`
+	Pricing Pricing `yaml:",inline"`
+}
+
+// A RateTable has the pricing for each destination, keyed by E.164 prefix
+// (e.g. +1, +1876, +5511).
+type RateTable map[string]Pricing
+
+var prefixFormat = regexp.MustCompile(`^\+[0-9]+$`)
+
+// Default returns the tariff used when no tariff file is specified: national
+// calls cost $2.5 each, and international calls $1 per second.
+func Default() Tariff {
+	return Tariff{
+		Currency:      DefaultCurrency,
+		National:      Pricing{PerCall: money.MustParseDecimal("2.5")},
+		International: InternationalPricing{Default: Pricing{PerSecond: money.MustParseDecimal("1")}},
+	}
+}
+
+// Load loads a tariff from the content of a tariff file. The pricing of the
+// built-in types of calls must be declared, and the ones of the rest of the
+// types are optional. Time bands can only refer to the types.
+func Load(content []byte, types CallTypes) (Tariff, error) {
+	var file fileTariff
+	doc, err := config.Decode(content, &file)
+	if err != nil {
+		return Tariff{}, err
+	}
+
+	return file.build(doc, types)
+}
+
+// fileTariff is a tariff as declared in files, where the pricing of national
+// and international calls is required.
+type fileTariff struct {
+	Currency       string                `yaml:"currency"`
+	National       *Pricing              `yaml:"national"`
+	International  *InternationalPricing `yaml:"international"`
+	Interplanetary InterplanetaryPricing `yaml:"interplanetary"`
+	TimeBands      TimeBands             `yaml:"time_bands"`
+	Pricings       map[string]Pricing    `yaml:",inline"`
+}
+
+// build validates the tariff, declared in the document, for the types of calls.
+func (f fileTariff) build(doc config.Document, types CallTypes) (Tariff, error) {
+	if f.Currency == "" {
+		f.Currency = DefaultCurrency
+	}
+
+	if err := money.ValidateCurrency(f.Currency); err != nil {
+		return Tariff{}, config.Errorf(doc.Line("currency"), "%s", err)
+	}
+
+	if f.National == nil {
+		return Tariff{}, config.Errorf(doc.Line(), "missing pricing for national calls")
+	}
+
+	if f.International == nil {
+		return Tariff{}, config.Errorf(doc.Line(), "missing pricing for international calls")
+	}
+
+	if err := f.National.validate(doc, TypeNational); err != nil {
+		return Tariff{}, err
+	}
+
+	if err := f.International.validate(doc, TypeInternational); err != nil {
+		return Tariff{}, err
+	}
+
+	if err := f.Interplanetary.validate(doc, TypeInterplanetary); err != nil {
+		return Tariff{}, err
+	}
+
+	keys := types.pricingKeys()
+	for _, key := range sliceutil.SortedKeys(f.Pricings) {
+		if !sliceutil.Contains(keys, key) {
+			all := append([]string{TypeNational, TypeInternational, TypeInterplanetary}, keys...)
+			return Tariff{}, config.Errorf(doc.Line(key), "unknown pricing %q, should be one of %s", key, strings.Join(all, ", "))
+		}
+
+		if err := f.Pricings[key].validate(doc, key); err != nil {
+			return Tariff{}, err
+		}
+	}
+
+	if err := f.TimeBands.validate(doc, types.Names()); err != nil {
+		return Tariff{}, err
+	}
+
+	return Tariff{
+		Currency:       f.Currency,
+		National:       *f.National,
+		International:  *f.International,
+		Interplanetary: f.Interplanetary,
+		TimeBands:      f.TimeBands,
+		Pricings:       f.Pricings,
+	}, nil
+}
+
+func (p InternationalPricing) validate(doc config.Document, key string) error {
+	if err := p.Default.validate(doc, key); err != nil {
+		return err
+	}
+
+	for _, prefix := range sliceutil.SortedKeys(p.Destinations) {
+		if !prefixFormat.MatchString(prefix) {
+			return config.Errorf(
+				doc.Line(key, "destinations", prefix),
+				"invalid destination prefix %q, should match %s", prefix, prefixFormat.String(),
+			)
+		}
+
+		if err := p.Destinations[prefix].validate(doc, key, "destinations", prefix); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+func (p InterplanetaryPricing) validate(doc config.Document, key string) error {
+	if p.Prefix != "" && !prefixFormat.MatchString(p.Prefix) {
+		return config.Errorf(
+			doc.Line(key, "prefix"),
+			"invalid prefix %q, should match %s", p.Prefix, prefixFormat.String(),
+		)
+	}
+
+	return p.Pricing.validate(doc, key)
+}
+
+// PricingOf returns the pricing of the key (see CallTypes). The key of
+// international calls is their default rate. It isn't ok if the tariff doesn't
+// price the key.
+func (t Tariff) PricingOf(key string) (Pricing, bool) {
+	switch key {
+	case TypeNational:
+		return t.National, true
+	case TypeInternational:
+		return t.International.Default, true
+	case TypeInterplanetary:
+		return t.Interplanetary.Pricing, true
+	}
+
+	pricing, ok := t.Pricings[key]
+	return pricing, ok
+}
+
+// CurrencyOf returns the currency of the prices of the pricing.
+func (t Tariff) CurrencyOf(p Pricing) string {
+	if p.Currency != "" {
+		return p.Currency
+	}
+
+	return t.Currency
+}
+
+// Matches returns whether a call to the destination phone number is
+// interplanetary.
+func (p InterplanetaryPricing) Matches(destinationPhone string) bool {
+	return p.Prefix != "" && strings.HasPrefix(destinationPhone, p.Prefix)
+}
+
+// Rate returns the pricing of a call to the destination phone number, using
+// the longest matching prefix of the rate table, with the increments and
+// currency of the default rate if it doesn't have its own. If no prefix
+// matches, it returns the default rate and isDefault is true.
+//
+// If the rate table is empty the default rate applies to all destinations, so
+// none of them is considered unknown.
+func (p InternationalPricing) Rate(destinationPhone string) (pricing Pricing, isDefault bool) {
+	if pricing, ok := p.Destinations.Match(destinationPhone); ok {
+		if pricing.Increments == (Increments{}) {
+			pricing.Increments = p.Default.Increments
+		}
+
+		if pricing.Currency == "" {
+			pricing.Currency = p.Default.Currency
+		}
+
+		return pricing, false
+	}
+
+	return p.Default, len(p.Destinations) > 0
+}
+
+// Match returns the pricing of the longest prefix of the phone number that is
+// in the table.
+func (r RateTable) Match(phoneNumber string) (Pricing, bool) {
+	for length := len(phoneNumber); length > 1; length-- {
+		if pricing, ok := r[phoneNumber[:length]]; ok {
+			return pricing, true
+		}
+	}
+
+	return Pricing{}, false
+}
+
+func (p Pricing) validate(doc config.Document, path ...interface{}) error {
+	if p.Currency != "" {
+		if err := money.ValidateCurrency(p.Currency); err != nil {
+			return config.Errorf(doc.Line(append(path, "currency")...), "%s", err)
+		}
+	}
+
+	components := []struct {
+		key   string
+		value money.Decimal
+	}{
+		{"per_call", p.PerCall},
+		{"per_second", p.PerSecond},
+		{"per_minute", p.PerMinute},
+	}
+
+	for _, c := range components {
+		if c.value.Sign() < 0 {
+			fieldPath := append(path, c.key)
+			return config.Errorf(doc.Line(fieldPath...), "%s can't be negative", joinPath(fieldPath))
+		}
+	}
+
+	return nil
+}
+
+// Cost returns the exact cost of a call that lasted the specified amount of
+// seconds, along with the duration that was billed according to the increments.
+// It returns an error if the cost is out of range.
+func (p Pricing) Cost(durationSecs uint) (cost money.Decimal, billedSecs uint, err error) {
+	billedSecs = p.Increments.Billed(durationSecs)
+	startedMinutes := roundUp(billedSecs, 60) / 60
+
+	perSecond, err := p.PerSecond.MulInt(int64(billedSecs))
+	if err != nil {
+		return money.Decimal{}, 0, err
+	}
+
+	perMinute, err := p.PerMinute.MulInt(int64(startedMinutes))
+	if err != nil {
+		return money.Decimal{}, 0, err
+	}
+
+	cost, err = p.PerCall.Add(perSecond)
+	if err != nil {
+		return money.Decimal{}, 0, err
+	}
+
+	cost, err = cost.Add(perMinute)
+	if err != nil {
+		return money.Decimal{}, 0, err
+	}
+
+	return cost, billedSecs, nil
+}
+
+// Billed returns the billed duration of a call. Calls that didn't last anything
+// aren't billed any time.
+func (i Increments) Billed(durationSecs uint) uint {
+	if durationSecs == 0 {
+		return 0
+	}
+
+	initial, subsequent := i.Initial, i.Subsequent
+	if initial == 0 {
+		initial = 1
+	}
+
+	if subsequent == 0 {
+		subsequent = 1
+	}
+
+	billed := initial
+	if durationSecs > initial {
+		billed += roundUp(durationSecs-initial, subsequent)
+	}
+
+	if billed < i.Minimum {
+		billed = i.Minimum
+	}
+
+	return billed
+}
+
+// roundUp rounds n up to a multiple of block.
+func roundUp(n, block uint) uint {
+	return (n + block - 1) / block * block
+}
+
+// joinPath joins a path of a configuration value for error messages, e.g.
+// international.destinations.+1.per_second
+func joinPath(path []interface{}) string {
+	elems := make([]string, len(path))
+	for i, elem := range path {
+		elems[i] = fmt.Sprint(elem)
+	}
+
+	return strings.Join(elems, ".")
+}
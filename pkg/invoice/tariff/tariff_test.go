@@ -0,0 +1,357 @@
+package tariff_test
+
+import (
+	"invoice-generator/pkg/invoice/tariff"
+	"invoice-generator/pkg/platform/money"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestLoadYAML(t *testing.T) {
+	loaded, err := tariff.Load([]byte(`
+currency: USD
+national:
+  per_call: 2.5
+international:
+  per_call: 1
+  per_minute: 0.5
+  increments: {initial: 60, subsequent: 60, minimum: 60}
+  destinations:
+    "+1": {per_second: 0.5}
+    "+1876":
+      per_second: 2
+`), callTypes)
+	require.NoError(t, err)
+
+	expected := tariff.Tariff{
+		Currency: "USD",
+		National: tariff.Pricing{PerCall: dec("2.5")},
+		International: tariff.InternationalPricing{
+			Default: tariff.Pricing{
+				PerCall:    dec("1"),
+				PerMinute:  dec("0.5"),
+				Increments: tariff.Increments{Initial: 60, Subsequent: 60, Minimum: 60},
+			},
+			Destinations: tariff.RateTable{
+				"+1":    {PerSecond: dec("0.5")},
+				"+1876": {PerSecond: dec("2")},
+			},
+		},
+	}
+	assert.Equal(t, expected, loaded)
+}
+
+func TestLoadJSON(t *testing.T) {
+	loaded, err := tariff.Load([]byte(`{
+  "national": {"per_call": 2.5},
+  "international": {"per_second": 1}
+}`), callTypes)
+	require.NoError(t, err)
+	assert.Equal(t, tariff.Default(), loaded)
+}
+
+func TestLoadInterplanetary(t *testing.T) {
+	loaded, err := tariff.Load([]byte(`
+national:
+  per_call: 2.5
+international:
+  per_second: 1
+interplanetary:
+  prefix: "+881"
+  per_second: 10
+  per_call: 50
+`), callTypes)
+	require.NoError(t, err)
+
+	expected := tariff.InterplanetaryPricing{
+		Prefix:  "+881",
+		Pricing: tariff.Pricing{PerSecond: dec("10"), PerCall: dec("50")},
+	}
+	assert.Equal(t, expected, loaded.Interplanetary)
+	assert.True(t, loaded.Interplanetary.Matches("+881612345678"))
+	assert.False(t, loaded.Interplanetary.Matches("+5491111111111"))
+	assert.False(t, tariff.Default().Interplanetary.Matches("+881612345678"), "without prefix there are no interplanetary calls")
+}
+
+func TestLoadErrorsHaveLines(t *testing.T) {
+	tests := []struct {
+		name    string
+		content string
+		err     string
+	}{
+		{
+			name:    "empty file",
+			content: ``,
+			err:     "empty file",
+		},
+		{
+			name: "syntax error",
+			content: `national:
+  per_call: 2.5
+international: [`,
+			err: "line 3: did not find expected node content",
+		},
+		{
+			name: "unknown field",
+			content: `national:
+  per_hour: 2.5
+international:
+  per_second: 1`,
+			err: "line 2: field per_hour not found in type tariff.Pricing",
+		},
+		{
+			name: "wrong type",
+			content: `national:
+  per_call: cheap
+international:
+  per_second: 1`,
+			err: "line 2: invalid decimal \"cheap\"",
+		},
+		{
+			name: "too many decimals",
+			content: `national:
+  per_call: 2.5
+international:
+  per_second: 0.0000000001`,
+			err: "line 4: invalid decimal \"0.0000000001\": at most 9 decimals are supported",
+		},
+		{
+			name: "negative increment",
+			content: `national:
+  per_call: 2.5
+  increments:
+    initial: -60
+international:
+  per_second: 1`,
+			err: "line 4: cannot unmarshal !!int `-60` into uint",
+		},
+		{
+			name: "invalid currency",
+			content: `currency: pesos
+national:
+  per_call: 2.5
+international:
+  per_second: 1`,
+			err: "line 1: invalid currency \"pesos\", should be an ISO 4217 code (e.g. ARS)",
+		},
+		{
+			name: "invalid currency of a destination",
+			content: `national:
+  per_call: 2.5
+international:
+  per_second: 1
+  destinations:
+    "+1": {per_second: 0.5, currency: dollars}`,
+			err: "line 6: invalid currency \"dollars\", should be an ISO 4217 code (e.g. ARS)",
+		},
+		{
+			name: "negative price",
+			content: `national:
+  per_call: 2.5
+international:
+  per_second: 1
+  per_minute: -3`,
+			err: "line 5: international.per_minute can't be negative",
+		},
+		{
+			name: "negative destination price",
+			content: `national:
+  per_call: 2.5
+international:
+  per_second: 1
+  destinations:
+    "+1": {per_second: 0.5}
+    "+1876":
+      per_call: -1`,
+			err: "line 8: international.destinations.+1876.per_call can't be negative",
+		},
+		{
+			name: "invalid destination prefix",
+			content: `national:
+  per_call: 2.5
+international:
+  per_second: 1
+  destinations:
+    "1876": {per_second: 2}`,
+			err: "line 6: invalid destination prefix \"1876\", should match ^\\+[0-9]+$",
+		},
+		{
+			name: "invalid interplanetary prefix",
+			content: `national:
+  per_call: 2.5
+international:
+  per_second: 1
+interplanetary:
+  prefix: mars
+  per_second: 10`,
+			err: "line 6: invalid prefix \"mars\", should match ^\\+[0-9]+$",
+		},
+		{
+			name: "negative interplanetary price",
+			content: `national:
+  per_call: 2.5
+international:
+  per_second: 1
+interplanetary:
+  prefix: "+881"
+  per_call: -50`,
+			err: "line 7: interplanetary.per_call can't be negative",
+		},
+		{
+			name: "missing call type",
+			content: `national:
+  per_call: 2.5`,
+			err: "line 1: missing pricing for international calls",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := tariff.Load([]byte(tt.content), callTypes)
+			assert.EqualError(t, err, tt.err)
+		})
+	}
+}
+
+func TestPricingCost(t *testing.T) {
+	pricing := tariff.Pricing{PerCall: dec("1"), PerSecond: dec("0.5"), PerMinute: dec("2")}
+
+	// 1 + 0.5 * 61 + 2 * 2 (started minutes)
+	cost, billed, err := pricing.Cost(61)
+	require.NoError(t, err)
+	assert.Equal(t, dec("35.5"), cost)
+	assert.Equal(t, uint(61), billed)
+
+	cost, billed, err = pricing.Cost(0)
+	require.NoError(t, err)
+	assert.Equal(t, dec("1"), cost)
+	assert.Equal(t, uint(0), billed)
+}
+
+func TestPricingCostOutOfRangeIsAnError(t *testing.T) {
+	// The longest call of a file, at 3 per second, is 12 billion
+	_, _, err := tariff.Pricing{PerSecond: dec("3")}.Cost(4_000_000_000)
+	assert.EqualError(t, err, "multiplying 3 by 4000000000: out of range")
+
+	_, _, err = tariff.Pricing{PerCall: dec("9000000000"), PerMinute: dec("9000000000")}.Cost(1)
+	assert.EqualError(t, err, "adding 9000000000 to 9000000000: out of range")
+}
+
+func TestPricingCostUsesBilledDuration(t *testing.T) {
+	pricing := tariff.Pricing{
+		PerSecond:  dec("0.5"),
+		Increments: tariff.Increments{Initial: 30, Subsequent: 6},
+	}
+
+	cost, billed, err := pricing.Cost(31)
+	require.NoError(t, err)
+	assert.Equal(t, dec("18"), cost)
+	assert.Equal(t, uint(36), billed)
+}
+
+func TestIncrementsBilled(t *testing.T) {
+	tests := []struct {
+		name       string
+		increments tariff.Increments
+		duration   uint
+		billed     uint
+	}{
+		{name: "exact by default", increments: tariff.Increments{}, duration: 61, billed: 61},
+		{name: "60/60 rounds to minutes", increments: tariff.Increments{Initial: 60, Subsequent: 60}, duration: 61, billed: 120},
+		{name: "60/60 initial block", increments: tariff.Increments{Initial: 60, Subsequent: 60}, duration: 5, billed: 60},
+		{name: "30/6", increments: tariff.Increments{Initial: 30, Subsequent: 6}, duration: 31, billed: 36},
+		{name: "30/6 exact block", increments: tariff.Increments{Initial: 30, Subsequent: 6}, duration: 42, billed: 42},
+		{name: "minimum", increments: tariff.Increments{Minimum: 60}, duration: 10, billed: 60},
+		{name: "minimum doesn't apply to longer calls", increments: tariff.Increments{Minimum: 60}, duration: 61, billed: 61},
+		{name: "empty calls aren't billed", increments: tariff.Increments{Initial: 60, Minimum: 60}, duration: 0, billed: 0},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			assert.Equal(t, tt.billed, tt.increments.Billed(tt.duration))
+		})
+	}
+}
+
+func TestDestinationsInheritDefaultIncrements(t *testing.T) {
+	pricing := tariff.InternationalPricing{
+		Default: tariff.Pricing{PerSecond: dec("1"), Increments: tariff.Increments{Initial: 60, Subsequent: 60}},
+		Destinations: tariff.RateTable{
+			"+1":  {PerSecond: dec("0.5")},
+			"+55": {PerSecond: dec("0.5"), Increments: tariff.Increments{Initial: 1, Subsequent: 1}},
+		},
+	}
+
+	rate, _ := pricing.Rate("+12125551234")
+	assert.Equal(t, tariff.Increments{Initial: 60, Subsequent: 60}, rate.Increments)
+
+	rate, _ = pricing.Rate("+551155551234")
+	assert.Equal(t, tariff.Increments{Initial: 1, Subsequent: 1}, rate.Increments)
+}
+
+func TestPricingsCanHaveTheirOwnCurrency(t *testing.T) {
+	loaded, err := tariff.Load([]byte(`
+national:
+  per_call: 2.5
+international:
+  currency: USD
+  per_second: 0.01
+  destinations:
+    "+1": {per_second: 0.005}
+    "+34": {per_second: 0.02, currency: EUR}
+`), callTypes)
+	require.NoError(t, err)
+
+	assert.Equal(t, tariff.DefaultCurrency, loaded.CurrencyOf(loaded.National))
+	assert.Equal(t, "USD", loaded.CurrencyOf(loaded.International.Default))
+
+	rate, _ := loaded.International.Rate("+12125551234")
+	assert.Equal(t, "USD", loaded.CurrencyOf(rate), "destinations inherit the currency of the default rate")
+
+	rate, _ = loaded.International.Rate("+34911111111")
+	assert.Equal(t, "EUR", loaded.CurrencyOf(rate))
+}
+
+func TestInternationalRateUsesLongestPrefix(t *testing.T) {
+	pricing := tariff.InternationalPricing{
+		Default: tariff.Pricing{PerSecond: dec("1")},
+		Destinations: tariff.RateTable{
+			"+1":    {PerSecond: dec("0.5")},
+			"+1876": {PerSecond: dec("2")},
+			"+55":   {PerSecond: dec("0.8")},
+			"+5511": {PerSecond: dec("0.7")},
+		},
+	}
+
+	tests := []struct {
+		phone     string
+		expected  tariff.Pricing
+		isDefault bool
+	}{
+		{phone: "+12125551234", expected: tariff.Pricing{PerSecond: dec("0.5")}},
+		{phone: "+18765551234", expected: tariff.Pricing{PerSecond: dec("2")}},
+		{phone: "+551155551234", expected: tariff.Pricing{PerSecond: dec("0.7")}},
+		{phone: "+552155551234", expected: tariff.Pricing{PerSecond: dec("0.8")}},
+		{phone: "+34911111111", expected: tariff.Pricing{PerSecond: dec("1")}, isDefault: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.phone, func(t *testing.T) {
+			rate, isDefault := pricing.Rate(tt.phone)
+			assert.Equal(t, tt.expected, rate)
+			assert.Equal(t, tt.isDefault, isDefault)
+		})
+	}
+}
+
+func TestInternationalRateWithoutTableIsNeverUnknown(t *testing.T) {
+	rate, isDefault := tariff.Default().International.Rate("+34911111111")
+	assert.Equal(t, tariff.Pricing{PerSecond: dec("1")}, rate)
+	assert.False(t, isDefault)
+}
+
+func dec(value string) money.Decimal {
+	return money.MustParseDecimal(value)
+}
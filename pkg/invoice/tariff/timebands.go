@@ -0,0 +1,256 @@
+package tariff
+
+import (
+	"errors"
+	"fmt"
+	"invoice-generator/pkg/platform/config"
+	"invoice-generator/pkg/platform/money"
+	"invoice-generator/pkg/platform/sliceutil"
+	"strings"
+	"time"
+
+	"gopkg.in/yaml.v3"
+)
+
+// PeakBand is the name of the band of the calls that weren't made in any of the
+// configured windows. Its cost isn't changed.
+const PeakBand = "peak"
+
+// TimeBands change the cost of calls depending on when they were made (e.g.
+// off-peak calls at night and on weekends are cheaper). Calls made on holidays
+// are rated as if they were made on a Sunday.
+//
+// Example:
+//
+//	time_bands:
+//	  time_zone: America/Argentina/Buenos_Aires
+//	  bands:
+//	    - name: off_peak
+//	      windows:
+//	        - days: [saturday, sunday]
+//	        - days: [monday, tuesday, wednesday, thursday, friday]
+//	          from: "20:00"
+//	          to: "08:00"
+//	      multipliers:
+//	        national: 0.5
+//	        international: 0.8
+type TimeBands struct {
+	TimeZone Location `yaml:"time_zone"` // Defaults to UTC
+	Bands    []Band   `yaml:"bands"`
+}
+
+// Location is a time zone, written as its IANA name in files (e.g.
+// America/Argentina/Buenos_Aires). The zero value is UTC.
+type Location struct {
+	*time.Location
+}
+
+// A Band is a set of windows of time in which calls cost differently.
+type Band struct {
+	Name    string   `yaml:"name"`
+	Windows []Window `yaml:"windows"`
+
+	// Multipliers of the base cost, by call type. Types without a multiplier
+	// cost the same.
+	Multipliers map[string]money.Decimal `yaml:"multipliers"`
+}
+
+// A Window is a range of hours, repeated on some days of the week. If To is
+// before From the window ends on the next day (e.g. from 20:00 to 08:00).
+type Window struct {
+	Days []Weekday `yaml:"days"`
+	From ClockTime `yaml:"from"` // Inclusive, defaults to 00:00
+	To   ClockTime `yaml:"to"`   // Exclusive, 00:00 (the default) is the end of the day
+}
+
+// Holidays tells whether the day a call was made on and the day before it are
+// holidays. They are resolved once, in the time zone of the user, so the bands
+// agree with the invoice on which calls were made on holidays even if their
+// time zone is on a different date.
+type Holidays struct {
+	Today     bool
+	Yesterday bool // For the early times of windows that started the day before
+}
+
+// A Weekday is a day of the week, written in lowercase english in files.
+type Weekday time.Weekday
+
+// ClockTime is a time of the day, written as HH:MM in files. It's stored as
+// minutes since midnight.
+type ClockTime uint
+
+const minutesInDay = 24 * 60
+
+// At returns the band a call made at the specified time belongs to, on
+// holidays or not. If no bands are configured, ok is false.
+func (b TimeBands) At(t time.Time, holidays Holidays) (band Band, ok bool) {
+	if len(b.Bands) == 0 {
+		return Band{}, false
+	}
+
+	local := b.TimeZone.In(t)
+	for _, band := range b.Bands {
+		if band.contains(local, holidays) {
+			return band, true
+		}
+	}
+
+	return Band{Name: PeakBand}, true
+}
+
+// Apply returns the cost of a call of the specified type in this band. It
+// returns an error if the cost is out of range once multiplied.
+func (b Band) Apply(callType string, cost money.Decimal) (money.Decimal, error) {
+	multiplier, ok := b.Multipliers[callType]
+	if !ok {
+		return cost, nil
+	}
+
+	return cost.Mul(multiplier)
+}
+
+func (b Band) contains(local time.Time, holidays Holidays) bool {
+	for _, window := range b.Windows {
+		if window.Contains(local, holidays) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// Contains returns whether the window contains the time, which must be in the
+// time zone of the window. Holidays are considered Sundays, both on their day
+// and in the early times of windows that started on them.
+func (w Window) Contains(local time.Time, holidays Holidays) bool {
+	from, to := int(w.From), int(w.To)
+	if to == 0 {
+		to = minutesInDay
+	}
+
+	now := local.Hour()*60 + local.Minute()
+	today := local.Weekday()
+	if holidays.Today {
+		today = time.Sunday
+	}
+
+	if from < to {
+		return w.hasDay(today) && from <= now && now < to
+	}
+
+	// The window wraps to the next day, so early times belong to the window
+	// that started the day before.
+	yesterday := (local.Weekday() + 6) % 7
+	if holidays.Yesterday {
+		yesterday = time.Sunday
+	}
+
+	return (w.hasDay(today) && now >= from) || (w.hasDay(yesterday) && now < to)
+}
+
+func (w Window) hasDay(day time.Weekday) bool {
+	for _, d := range w.Days {
+		if time.Weekday(d) == day {
+			return true
+		}
+	}
+
+	return false
+}
+
+func (b TimeBands) validate(doc config.Document, callTypes []string) error {
+	names := make(map[string]bool)
+	for i, band := range b.Bands {
+		path := []interface{}{"time_bands", "bands", i}
+
+		if band.Name == "" {
+			return config.Errorf(doc.Line(path...), "band must have a name")
+		}
+
+		if names[band.Name] {
+			return config.Errorf(doc.Line(append(path, "name")...), "duplicated band %q", band.Name)
+		}
+		names[band.Name] = true
+
+		if len(band.Windows) == 0 {
+			return config.Errorf(doc.Line(path...), "band %q must have at least one window", band.Name)
+		}
+
+		for j, window := range band.Windows {
+			if err := window.Validate(); err != nil {
+				return config.Errorf(doc.Line(append(path, "windows", j)...), "%s", err)
+			}
+		}
+
+		for _, callType := range sliceutil.SortedKeys(band.Multipliers) {
+			multiplier := band.Multipliers[callType]
+			line := doc.Line(append(path, "multipliers", callType)...)
+			if !sliceutil.Contains(callTypes, callType) {
+				return config.Errorf(line, "unknown call type %q, should be one of %s", callType, strings.Join(callTypes, ", "))
+			}
+
+			if multiplier.Sign() < 0 {
+				return config.Errorf(line, "multiplier can't be negative")
+			}
+		}
+	}
+
+	return nil
+}
+
+// Validate validates that the window has days and isn't empty.
+func (w Window) Validate() error {
+	if len(w.Days) == 0 {
+		return errors.New("window must have at least one day")
+	}
+
+	if w.From != 0 && w.From == w.To {
+		return errors.New("window can't start and end at the same time")
+	}
+
+	return nil
+}
+
+// In returns the time in the location.
+func (l Location) In(t time.Time) time.Time {
+	if l.Location == nil {
+		return t.In(time.UTC)
+	}
+
+	return t.In(l.Location)
+}
+
+func (l *Location) UnmarshalYAML(node *yaml.Node) error {
+	location, err := time.LoadLocation(node.Value)
+	if err != nil {
+		return config.Errorf(node.Line, "invalid time zone %q", node.Value)
+	}
+
+	l.Location = location
+	return nil
+}
+
+func (d *Weekday) UnmarshalYAML(node *yaml.Node) error {
+	for day := time.Sunday; day <= time.Saturday; day++ {
+		if strings.ToLower(day.String()) == node.Value {
+			*d = Weekday(day)
+			return nil
+		}
+	}
+
+	return config.Errorf(node.Line, "invalid day %q, should be a lowercase day of the week (e.g. monday)", node.Value)
+}
+
+func (c *ClockTime) UnmarshalYAML(node *yaml.Node) error {
+	var hours, minutes int
+	_, err := fmt.Sscanf(node.Value, "%2d:%2d", &hours, &minutes)
+
+	valid := err == nil && len(node.Value) == len("15:04") &&
+		hours >= 0 && hours < 24 && minutes >= 0 && minutes < 60
+	if !valid {
+		return config.Errorf(node.Line, "invalid time %q, should be HH:MM", node.Value)
+	}
+
+	*c = ClockTime(hours*60 + minutes)
+	return nil
+}
@@ -0,0 +1,237 @@
+package tariff_test
+
+import (
+	"invoice-generator/pkg/invoice/tariff"
+	"invoice-generator/pkg/platform/money"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+const tariffWithBands = `
+national:
+  per_call: 2
+international:
+  per_second: 1
+time_bands:
+  time_zone: America/Argentina/Buenos_Aires
+  bands:
+    - name: off_peak
+      windows:
+        - days: [saturday, sunday]
+        - days: [monday, tuesday, wednesday, thursday, friday]
+          from: "20:00"
+          to: "08:00"
+      multipliers:
+        national: 0.5
+`
+
+func TestTimeBandsAt(t *testing.T) {
+	loaded, err := tariff.Load([]byte(tariffWithBands), callTypes)
+	require.NoError(t, err)
+
+	// Times are in UTC, Buenos Aires is UTC-3
+	tests := []struct {
+		name string
+		date time.Time
+		band string
+	}{
+		{
+			name: "weekday during the day",
+			date: time.Date(2022, time.November, 9, 15, 0, 0, 0, time.UTC), // Wed 12:00
+			band: tariff.PeakBand,
+		},
+		{
+			name: "weekday night",
+			date: time.Date(2022, time.November, 9, 23, 0, 0, 0, time.UTC), // Wed 20:00
+			band: "off_peak",
+		},
+		{
+			name: "weekday early morning belongs to the previous night",
+			date: time.Date(2022, time.November, 10, 10, 59, 0, 0, time.UTC), // Thu 07:59
+			band: "off_peak",
+		},
+		{
+			name: "end of window is exclusive",
+			date: time.Date(2022, time.November, 10, 11, 0, 0, 0, time.UTC), // Thu 08:00
+			band: tariff.PeakBand,
+		},
+		{
+			name: "weekend in local time but not in UTC",
+			date: time.Date(2022, time.November, 12, 2, 0, 0, 0, time.UTC), // Fri 23:00
+			band: "off_peak",
+		},
+		{
+			name: "weekend",
+			date: time.Date(2022, time.November, 13, 15, 0, 0, 0, time.UTC), // Sun 12:00
+			band: "off_peak",
+		},
+		{
+			name: "whole day windows don't wrap to the next day",
+			date: time.Date(2022, time.November, 14, 10, 0, 0, 0, time.UTC), // Mon 07:00
+			band: tariff.PeakBand,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			band, ok := loaded.TimeBands.At(tt.date, tariff.Holidays{})
+			require.True(t, ok)
+			assert.Equal(t, tt.band, band.Name)
+		})
+	}
+}
+
+func TestHolidaysAreRatedLikeSundays(t *testing.T) {
+	loaded, err := tariff.Load([]byte(tariffWithBands), callTypes)
+	require.NoError(t, err)
+
+	wednesdayNoon := time.Date(2022, time.November, 9, 15, 0, 0, 0, time.UTC)
+	band, ok := loaded.TimeBands.At(wednesdayNoon, tariff.Holidays{Today: true})
+	require.True(t, ok)
+	assert.Equal(t, "off_peak", band.Name)
+
+	band, _ = loaded.TimeBands.At(wednesdayNoon, tariff.Holidays{})
+	assert.Equal(t, tariff.PeakBand, band.Name)
+}
+
+func TestHolidaysAreTheOnesOfTheUserInAnyTimeZone(t *testing.T) {
+	loaded, err := tariff.Load([]byte(`
+national: {per_call: 2}
+international: {per_second: 1}
+time_bands:
+  time_zone: America/Argentina/Buenos_Aires
+  bands:
+    - name: off_peak
+      windows:
+        - days: [saturday, sunday]
+`), callTypes)
+	require.NoError(t, err)
+
+	// A user in UTC calls on Thursday 01:00, a holiday for them, when it's still
+	// Wednesday 22:00 in Buenos Aires. The holiday was resolved in the time zone
+	// of the user, so the call is rated like a Sunday anyway.
+	thursday := time.Date(2022, time.November, 10, 1, 0, 0, 0, time.UTC)
+
+	band, _ := loaded.TimeBands.At(thursday, tariff.Holidays{})
+	assert.Equal(t, tariff.PeakBand, band.Name)
+
+	band, _ = loaded.TimeBands.At(thursday, tariff.Holidays{Today: true})
+	assert.Equal(t, "off_peak", band.Name)
+}
+
+func TestNightWindowsOfHolidaysEndLikeTheOnesOfSundays(t *testing.T) {
+	loaded, err := tariff.Load([]byte(tariffWithBands), callTypes)
+	require.NoError(t, err)
+
+	// The off-peak window of weekdays continues until 08:00 of the next day,
+	// but the one of weekends (and holidays) ends at midnight.
+	thursdayMorning := time.Date(2022, time.November, 10, 10, 0, 0, 0, time.UTC) // Thu 07:00
+
+	band, _ := loaded.TimeBands.At(thursdayMorning, tariff.Holidays{})
+	assert.Equal(t, "off_peak", band.Name)
+
+	band, _ = loaded.TimeBands.At(thursdayMorning, tariff.Holidays{Yesterday: true})
+	assert.Equal(t, tariff.PeakBand, band.Name, "the day before was a holiday, like a Sunday")
+}
+
+func TestWithoutTimeBandsThereIsNoBand(t *testing.T) {
+	_, ok := tariff.Default().TimeBands.At(time.Now(), tariff.Holidays{})
+	assert.False(t, ok)
+}
+
+func TestBandApplyMultipliesByCallType(t *testing.T) {
+	band := tariff.Band{
+		Name:        "off_peak",
+		Multipliers: map[string]money.Decimal{tariff.TypeNational: dec("0.5")},
+	}
+
+	national, err := band.Apply(tariff.TypeNational, dec("2.5"))
+	require.NoError(t, err)
+	assert.Equal(t, dec("1.25"), national)
+
+	international, err := band.Apply(tariff.TypeInternational, dec("60"))
+	require.NoError(t, err)
+	assert.Equal(t, dec("60"), international)
+}
+
+func TestLoadTimeBandsErrors(t *testing.T) {
+	const prices = `national:
+  per_call: 2
+international:
+  per_second: 1
+`
+
+	tests := []struct {
+		name    string
+		content string
+		err     string
+	}{
+		{
+			name: "invalid time zone",
+			content: prices + `time_bands:
+  time_zone: Mars/Olympus_Mons`,
+			err: `line 6: invalid time zone "Mars/Olympus_Mons"`,
+		},
+		{
+			name: "invalid day",
+			content: prices + `time_bands:
+  bands:
+    - name: weekend
+      windows:
+        - days: [saturday, domingo]`,
+			err: `line 9: invalid day "domingo", should be a lowercase day of the week (e.g. monday)`,
+		},
+		{
+			name: "invalid time",
+			content: prices + `time_bands:
+  bands:
+    - name: night
+      windows:
+        - days: [monday]
+          from: "8pm"`,
+			err: `line 10: invalid time "8pm", should be HH:MM`,
+		},
+		{
+			name: "unknown call type",
+			content: prices + `time_bands:
+  bands:
+    - name: night
+      windows:
+        - days: [monday]
+      multipliers:
+        satellite: 2`,
+			err: `line 11: unknown call type "satellite", should be one of national, international, interplanetary, rural, roaming`,
+		},
+		{
+			name: "duplicated band",
+			content: prices + `time_bands:
+  bands:
+    - name: night
+      windows:
+        - days: [monday]
+    - name: night
+      windows:
+        - days: [tuesday]`,
+			err: `line 10: duplicated band "night"`,
+		},
+		{
+			name: "window without days",
+			content: prices + `time_bands:
+  bands:
+    - name: night
+      windows:
+        - from: "20:00"`,
+			err: `line 9: window must have at least one day`,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := tariff.Load([]byte(tt.content), callTypes)
+			assert.EqualError(t, err, tt.err)
+		})
+	}
+}
@@ -0,0 +1,86 @@
+package tariff
+
+import "fmt"
+
+// builtInCallTypes are the types of calls every tariff prices.
+var builtInCallTypes = []callType{
+	{name: TypeNational, pricingKey: TypeNational},
+	{name: TypeInternational, pricingKey: TypeInternational},
+	{name: TypeInterplanetary, pricingKey: TypeInterplanetary},
+}
+
+// CallTypes are the types of calls that time bands, plans, taxes and promotions
+// can refer to by name, along with the key of the pricing of their calls in
+// tariff files (see Tariff.PricingOf). Several types can share the same
+// pricing.
+//
+// The built-in types are always included, so the zero value has only them.
+// Registries of call types keep the types they classify (see call.Registry),
+// which are passed to the loaders of the files that refer to them.
+type CallTypes struct {
+	added []callType
+}
+
+// A callType is the name of a type of call along with the key of its pricing in
+// tariff files.
+type callType struct {
+	name       string
+	pricingKey string
+}
+
+// Add returns the types with another one, by its name and the key of the
+// pricing of its calls. Adding a name again with the same pricing key does
+// nothing, and with another one is an error.
+func (c CallTypes) Add(name, pricingKey string) (CallTypes, error) {
+	for _, t := range c.all() {
+		if t.name != name {
+			continue
+		}
+
+		if t.pricingKey != pricingKey {
+			return CallTypes{}, fmt.Errorf("call type %s is already registered with pricing %s", name, t.pricingKey)
+		}
+
+		return c, nil
+	}
+
+	// Copied, so that adding to the types doesn't change other copies of them
+	added := append(append([]callType(nil), c.added...), callType{name: name, pricingKey: pricingKey})
+	return CallTypes{added: added}, nil
+}
+
+// Names returns the names of the types, the built-in ones first and then the
+// rest in the order they were added.
+func (c CallTypes) Names() []string {
+	var names []string
+	for _, t := range c.all() {
+		names = append(names, t.name)
+	}
+
+	return names
+}
+
+// pricingKeys returns the keys of the pricing of the types that aren't fields
+// of Tariff, in the order they were added.
+func (c CallTypes) pricingKeys() []string {
+	var keys []string
+	seen := make(map[string]bool)
+	for _, t := range c.added {
+		if isBuiltInPricing(t.pricingKey) || seen[t.pricingKey] {
+			continue
+		}
+
+		seen[t.pricingKey] = true
+		keys = append(keys, t.pricingKey)
+	}
+
+	return keys
+}
+
+func (c CallTypes) all() []callType {
+	return append(append([]callType(nil), builtInCallTypes...), c.added...)
+}
+
+func isBuiltInPricing(key string) bool {
+	return key == TypeNational || key == TypeInternational || key == TypeInterplanetary
+}
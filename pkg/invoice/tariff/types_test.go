@@ -0,0 +1,101 @@
+package tariff_test
+
+import (
+	"invoice-generator/pkg/invoice/tariff"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// callTypes are the types of calls of the tests of the package: rural calls
+// are priced with their own key, and roaming calls like international ones.
+var callTypes = mustAdd(tariff.CallTypes{}, "rural", "rural", "roaming", tariff.TypeInternational)
+
+// mustAdd adds the types, by pairs of name and pricing key.
+func mustAdd(types tariff.CallTypes, namesAndKeys ...string) tariff.CallTypes {
+	for i := 0; i < len(namesAndKeys); i += 2 {
+		var err error
+		types, err = types.Add(namesAndKeys[i], namesAndKeys[i+1])
+		if err != nil {
+			panic(err)
+		}
+	}
+
+	return types
+}
+
+func TestAddedCallTypesComeAfterTheBuiltInOnes(t *testing.T) {
+	expected := []string{tariff.TypeNational, tariff.TypeInternational, tariff.TypeInterplanetary, "rural", "roaming"}
+	assert.Equal(t, expected, callTypes.Names())
+
+	again, err := callTypes.Add("rural", "rural")
+	require.NoError(t, err)
+	assert.Equal(t, expected, again.Names(), "adding a type again does nothing")
+
+	_, err = callTypes.Add("rural", tariff.TypeNational)
+	assert.EqualError(t, err, "call type rural is already registered with pricing rural")
+
+	_, err = tariff.CallTypes{}.Add(tariff.TypeNational, "rural")
+	assert.EqualError(t, err, "call type national is already registered with pricing national")
+}
+
+func TestAddingCallTypesDoesNotChangeOtherCopies(t *testing.T) {
+	satellite, err := callTypes.Add("satellite", "satellite")
+	require.NoError(t, err)
+	marine, err := callTypes.Add("marine", "satellite")
+	require.NoError(t, err)
+
+	assert.Contains(t, satellite.Names(), "satellite")
+	assert.NotContains(t, satellite.Names(), "marine")
+	assert.Contains(t, marine.Names(), "marine")
+	assert.NotContains(t, marine.Names(), "satellite")
+	assert.NotContains(t, callTypes.Names(), "satellite")
+}
+
+func TestTypesThatWereNotAddedCanNotBeReferredTo(t *testing.T) {
+	_, err := tariff.Load([]byte(`national: {per_call: 2.5}
+international: {per_second: 1}
+rural: {per_second: 0.5}`), tariff.CallTypes{})
+	assert.EqualError(t, err, `line 3: unknown pricing "rural", should be one of national, international, interplanetary`)
+}
+
+func TestLoadPricingOfAddedTypes(t *testing.T) {
+	loaded, err := tariff.Load([]byte(`
+national: {per_call: 2.5}
+international: {per_second: 1}
+rural: {per_second: 0.5, currency: USD}
+time_bands:
+  bands:
+    - name: night
+      windows:
+        - days: [monday]
+      multipliers: {rural: 0.5, roaming: 2}
+`), callTypes)
+	require.NoError(t, err)
+
+	rural, ok := loaded.PricingOf("rural")
+	require.True(t, ok)
+	assert.Equal(t, tariff.Pricing{PerSecond: dec("0.5"), Currency: "USD"}, rural)
+
+	international, ok := loaded.PricingOf(tariff.TypeInternational)
+	require.True(t, ok)
+	assert.Equal(t, loaded.International.Default, international)
+
+	_, ok = tariff.Default().PricingOf("rural")
+	assert.False(t, ok, "pricings of added types are optional")
+}
+
+func TestLoadPricingOfUnknownTypesShouldReturnAnError(t *testing.T) {
+	_, err := tariff.Load([]byte(`national: {per_call: 2.5}
+international: {per_second: 1}
+satellite: {per_second: 5}`), callTypes)
+	assert.EqualError(t, err, `line 3: unknown pricing "satellite", should be one of national, international, interplanetary, rural`)
+
+	_, err = tariff.LoadHistory([]byte(`versions:
+  - effective_from: 2022-01-01
+    national: {per_call: 2.5}
+    international: {per_second: 1}
+    rural: {per_second: -1}`), callTypes)
+	assert.EqualError(t, err, `line 5: rural.per_second can't be negative`)
+}
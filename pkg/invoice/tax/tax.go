@@ -0,0 +1,121 @@
+// Package tax implements the taxes charged on top of the cost of calls (e.g.
+// VAT). Taxes are declared in a configuration file, like tariffs.
+package tax
+
+import (
+	"invoice-generator/pkg/invoice/tariff"
+	"invoice-generator/pkg/platform/config"
+	"invoice-generator/pkg/platform/money"
+	"invoice-generator/pkg/platform/sliceutil"
+	"strings"
+)
+
+// Taxes are the taxes charged on invoices, in order.
+//
+// Example file:
+//
+//	currency: ARS # of fixed amounts, defaults to ARS
+//	taxes:
+//	  - name: IVA
+//	    percentage: 21
+//	  - name: Tasa internacional
+//	    percentage: 4
+//	    call_types: [international]
+//	  - name: Cargo fijo
+//	    fixed: 5
+type Taxes struct {
+	Currency string `yaml:"currency"`
+	Taxes    []Tax  `yaml:"taxes"`
+}
+
+// A Tax is either a percentage of the cost of the calls it applies to, or a
+// fixed amount charged once per invoice that has any of them.
+type Tax struct {
+	Name       string        `yaml:"name"`
+	Percentage money.Decimal `yaml:"percentage"`
+	Fixed      money.Decimal `yaml:"fixed"` // In the currency of the taxes
+
+	// CallTypes the tax applies to (e.g. international). Empty means all.
+	CallTypes []string `yaml:"call_types"`
+}
+
+// Load loads the taxes from the content of a taxes file, which can only refer
+// to the types of calls.
+func Load(content []byte, types tariff.CallTypes) (Taxes, error) {
+	var taxes Taxes
+	doc, err := config.Decode(content, &taxes)
+	if err != nil {
+		return Taxes{}, err
+	}
+
+	if taxes.Currency == "" {
+		taxes.Currency = tariff.DefaultCurrency
+	}
+
+	if err := money.ValidateCurrency(taxes.Currency); err != nil {
+		return Taxes{}, config.Errorf(doc.Line("currency"), "%s", err)
+	}
+
+	names := make(map[string]bool)
+	for i, tax := range taxes.Taxes {
+		line := func(path ...interface{}) int {
+			return doc.Line(append([]interface{}{"taxes", i}, path...)...)
+		}
+
+		if tax.Name == "" {
+			return Taxes{}, config.Errorf(line(), "tax must have a name")
+		}
+
+		if names[tax.Name] {
+			return Taxes{}, config.Errorf(line("name"), "duplicated tax %q", tax.Name)
+		}
+		names[tax.Name] = true
+
+		if tax.Percentage.IsZero() == tax.Fixed.IsZero() {
+			return Taxes{}, config.Errorf(line(), "tax %q must have either a percentage or a fixed amount", tax.Name)
+		}
+
+		if tax.Percentage.Sign() < 0 || tax.Fixed.Sign() < 0 {
+			return Taxes{}, config.Errorf(line(), "tax %q can't be negative", tax.Name)
+		}
+
+		for j, callType := range tax.CallTypes {
+			if !sliceutil.Contains(types.Names(), callType) {
+				return Taxes{}, config.Errorf(
+					line("call_types", j),
+					"unknown call type %q, should be one of %s", callType, strings.Join(types.Names(), ", "),
+				)
+			}
+		}
+	}
+
+	return taxes, nil
+}
+
+// IsFixed returns whether the tax is a fixed amount.
+func (t Tax) IsFixed() bool {
+	return !t.Fixed.IsZero()
+}
+
+// AppliesTo returns whether the tax is charged on calls of the type.
+func (t Tax) AppliesTo(callType string) bool {
+	return len(t.CallTypes) == 0 || sliceutil.Contains(t.CallTypes, callType)
+}
+
+// AppliesToCharges returns whether the tax is charged on the charges that
+// aren't calls, which only the taxes of all the types of calls are.
+func (t Tax) AppliesToCharges() bool {
+	return len(t.CallTypes) == 0
+}
+
+// Charge returns the percentage of the base charged by the tax, rounded to the
+// currency of the base. Fixed taxes don't depend on the base, see FixedAmount.
+// It returns an error if the amount is out of range.
+func (t Tax) Charge(base money.Money) (money.Money, error) {
+	return base.Percent(t.Percentage)
+}
+
+// FixedAmount returns the amount of a fixed tax, in the currency of the taxes.
+func (t Taxes) FixedAmount(tax Tax) money.Money {
+	return money.FromDecimal(tax.Fixed, t.Currency)
+}
@@ -0,0 +1,105 @@
+package tax_test
+
+import (
+	"invoice-generator/pkg/invoice/tariff"
+	"invoice-generator/pkg/invoice/tax"
+	"invoice-generator/pkg/platform/money"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestLoad(t *testing.T) {
+	loaded, err := tax.Load([]byte(`
+taxes:
+  - name: IVA
+    percentage: 21
+  - name: Tasa internacional
+    percentage: 4.5
+    call_types: [international]
+  - name: Cargo fijo
+    fixed: 5
+`), tariff.CallTypes{})
+	require.NoError(t, err)
+
+	expected := tax.Taxes{
+		Currency: tariff.DefaultCurrency,
+		Taxes: []tax.Tax{
+			{Name: "IVA", Percentage: money.MustParseDecimal("21")},
+			{Name: "Tasa internacional", Percentage: money.MustParseDecimal("4.5"), CallTypes: []string{tariff.TypeInternational}},
+			{Name: "Cargo fijo", Fixed: money.MustParseDecimal("5")},
+		},
+	}
+	assert.Equal(t, expected, loaded)
+
+	assert.True(t, loaded.Taxes[0].AppliesTo(tariff.TypeNational))
+	assert.False(t, loaded.Taxes[1].AppliesTo(tariff.TypeNational))
+	assert.True(t, loaded.Taxes[2].IsFixed())
+	assert.Equal(t, money.MustParse("5", "ARS"), loaded.FixedAmount(loaded.Taxes[2]))
+}
+
+func TestChargeRoundsToTheCurrency(t *testing.T) {
+	iva := tax.Tax{Name: "IVA", Percentage: money.MustParseDecimal("21")}
+
+	// 10.05 * 0.21 = 2.1105
+	amount, err := iva.Charge(money.MustParse("10.05", "ARS"))
+	require.NoError(t, err)
+	assert.Equal(t, money.MustParse("2.11", "ARS"), amount)
+}
+
+func TestLoadErrors(t *testing.T) {
+	tests := []struct {
+		name    string
+		content string
+		err     string
+	}{
+		{
+			name: "missing name",
+			content: `taxes:
+  - percentage: 21`,
+			err: `line 2: tax must have a name`,
+		},
+		{
+			name: "duplicated name",
+			content: `taxes:
+  - {name: IVA, percentage: 21}
+  - {name: IVA, percentage: 10.5}`,
+			err: `line 3: duplicated tax "IVA"`,
+		},
+		{
+			name: "both percentage and fixed",
+			content: `taxes:
+  - {name: IVA, percentage: 21, fixed: 1}`,
+			err: `line 2: tax "IVA" must have either a percentage or a fixed amount`,
+		},
+		{
+			name: "negative",
+			content: `taxes:
+  - {name: IVA, percentage: -21}`,
+			err: `line 2: tax "IVA" can't be negative`,
+		},
+		{
+			name: "unknown call type",
+			content: `taxes:
+  - name: IVA
+    percentage: 21
+    call_types:
+      - national
+      - satellite`,
+			err: `line 6: unknown call type "satellite", should be one of national, international, interplanetary`,
+		},
+		{
+			name:    "invalid currency",
+			content: `currency: usd`,
+			err:     `line 1: invalid currency "usd", should be an ISO 4217 code (e.g. ARS)`,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := tax.Load([]byte(tt.content), tariff.CallTypes{})
+			assert.EqualError(t, err, tt.err)
+		})
+	}
+}
@@ -0,0 +1,123 @@
+// Package config decodes configuration files. Files are written in YAML (JSON
+// also works, since it's a subset of it) and every error points to the line of
+// the file that caused it.
+package config
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"strings"
+
+	"gopkg.in/yaml.v3"
+)
+
+// A Document is a decoded configuration file. It remembers where each value was
+// declared, so that validations made after decoding can report the line of the
+// offending value.
+type Document struct {
+	root *yaml.Node
+}
+
+// Decode strictly decodes the content of a configuration file into out (which
+// must be a pointer). Unknown fields are considered an error.
+func Decode(content []byte, out interface{}) (Document, error) {
+	var root yaml.Node
+	if err := yaml.Unmarshal(content, &root); err != nil {
+		return Document{}, cleanError(err)
+	}
+
+	if len(root.Content) == 0 {
+		return Document{}, errors.New("empty file")
+	}
+
+	decoder := yaml.NewDecoder(bytes.NewReader(content))
+	decoder.KnownFields(true)
+	if err := decoder.Decode(out); err != nil && err != io.EOF {
+		return Document{}, cleanError(err)
+	}
+
+	return Document{root: root.Content[0]}, nil
+}
+
+// Line returns the line where the value in the specified path was declared.
+// Each element of the path is either a string (the key of a mapping) or an int
+// (the index of a sequence). If the path doesn't exist, it returns the line of
+// the deepest value that does.
+func (d Document) Line(path ...interface{}) int {
+	node := d.root
+	if node == nil {
+		return 0
+	}
+
+	for _, elem := range path {
+		next := child(node, elem)
+		if next == nil {
+			break
+		}
+
+		node = next
+	}
+
+	return node.Line
+}
+
+// Sub returns the document rooted at the value in the specified path, so that
+// a value nested in a file can be validated like a file of its own. If the
+// path doesn't exist, it's rooted at the deepest value that does.
+func (d Document) Sub(path ...interface{}) Document {
+	node := d.root
+	for _, elem := range path {
+		if node == nil {
+			break
+		}
+
+		next := child(node, elem)
+		if next == nil {
+			break
+		}
+
+		node = next
+	}
+
+	return Document{root: node}
+}
+
+func child(node *yaml.Node, elem interface{}) *yaml.Node {
+	switch key := elem.(type) {
+	case string:
+		if node.Kind != yaml.MappingNode {
+			return nil
+		}
+
+		// Mapping content alternates keys and values
+		for i := 0; i+1 < len(node.Content); i += 2 {
+			if node.Content[i].Value == key {
+				return node.Content[i+1]
+			}
+		}
+	case int:
+		if node.Kind == yaml.SequenceNode && key >= 0 && key < len(node.Content) {
+			return node.Content[key]
+		}
+	}
+
+	return nil
+}
+
+// Errorf formats an error that happened on the specified line.
+func Errorf(line int, format string, args ...interface{}) error {
+	return fmt.Errorf("line %d: %s", line, fmt.Sprintf(format, args...))
+}
+
+// cleanError makes yaml errors follow the same "line N: message" format as the
+// ones returned by Errorf.
+func cleanError(err error) error {
+	var typeErr *yaml.TypeError
+	if errors.As(err, &typeErr) {
+		return errors.New(strings.Join(typeErr.Errors, "; "))
+	}
+
+	return errors.New(strings.TrimPrefix(err.Error(), "yaml: "))
+}
@@ -0,0 +1,211 @@
+package money
+
+import (
+	"errors"
+	"fmt"
+	"invoice-generator/pkg/platform/config"
+	"math/big"
+	"regexp"
+	"strings"
+
+	"gopkg.in/yaml.v3"
+)
+
+// DecimalPlaces is the precision of a Decimal.
+const DecimalPlaces = 9
+
+var decimalScale = pow10(DecimalPlaces)
+
+// A Decimal is an exact decimal number with up to DecimalPlaces decimals, used
+// for prices and rates that are more precise than the currency (e.g. $0.0125
+// per second) and for factors (e.g. 0.8 times the price). The zero value is 0.
+type Decimal struct {
+	scaled int64 // value * 10^DecimalPlaces
+}
+
+var decimalFormat = regexp.MustCompile(`^-?[0-9]+(\.[0-9]+)?$`)
+
+// ParseDecimal parses a decimal number such as 2, 2.5 or -0.0125.
+func ParseDecimal(s string) (Decimal, error) {
+	if !decimalFormat.MatchString(s) {
+		return Decimal{}, fmt.Errorf("invalid decimal %q", s)
+	}
+
+	integer, fraction, _ := strings.Cut(s, ".")
+	if len(fraction) > DecimalPlaces {
+		return Decimal{}, fmt.Errorf("invalid decimal %q: at most %d decimals are supported", s, DecimalPlaces)
+	}
+
+	digits := integer + fraction + strings.Repeat("0", DecimalPlaces-len(fraction))
+	value, ok := new(big.Int).SetString(digits, 10)
+	if !ok || !value.IsInt64() {
+		return Decimal{}, fmt.Errorf("invalid decimal %q: out of range", s)
+	}
+
+	return Decimal{scaled: value.Int64()}, nil
+}
+
+// MustParseDecimal is like ParseDecimal but panics if the number is invalid.
+// Meant for constants and tests.
+func MustParseDecimal(s string) Decimal {
+	d, err := ParseDecimal(s)
+	if err != nil {
+		panic(err)
+	}
+
+	return d
+}
+
+// IntDecimal returns the decimal of an integer. It returns an error if it's out
+// of the range of a Decimal.
+func IntDecimal(n int64) (Decimal, error) {
+	scaled := new(big.Int).Mul(big.NewInt(n), big.NewInt(decimalScale))
+	if !scaled.IsInt64() {
+		return Decimal{}, fmt.Errorf("%d is out of range of a decimal", n)
+	}
+
+	return Decimal{scaled: scaled.Int64()}, nil
+}
+
+// Add returns d + other. It returns an error if the sum is out of the range of
+// a Decimal.
+func (d Decimal) Add(other Decimal) (Decimal, error) {
+	sum := new(big.Int).Add(big.NewInt(d.scaled), big.NewInt(other.scaled))
+	if !sum.IsInt64() {
+		return Decimal{}, fmt.Errorf("adding %s to %s: %s", other, d, errOutOfRange)
+	}
+
+	return Decimal{scaled: sum.Int64()}, nil
+}
+
+// MulInt returns d * n. It returns an error if the product is out of the range
+// of a Decimal.
+func (d Decimal) MulInt(n int64) (Decimal, error) {
+	product := new(big.Int).Mul(big.NewInt(d.scaled), big.NewInt(n))
+	if !product.IsInt64() {
+		return Decimal{}, fmt.Errorf("multiplying %s by %d: %s", d, n, errOutOfRange)
+	}
+
+	return Decimal{scaled: product.Int64()}, nil
+}
+
+// Mul returns d * other, rounded half away from zero to DecimalPlaces. It
+// returns an error if the product is out of the range of a Decimal.
+func (d Decimal) Mul(other Decimal) (Decimal, error) {
+	product := new(big.Int).Mul(big.NewInt(d.scaled), big.NewInt(other.scaled))
+	scaled, err := divRound(product, decimalScale)
+	if err != nil {
+		return Decimal{}, fmt.Errorf("multiplying %s by %s: %s", d, other, err)
+	}
+
+	return Decimal{scaled: scaled}, nil
+}
+
+// Sign returns -1, 0 or 1 depending on the sign of d.
+func (d Decimal) Sign() int {
+	switch {
+	case d.scaled < 0:
+		return -1
+	case d.scaled > 0:
+		return 1
+	default:
+		return 0
+	}
+}
+
+// Cmp compares d and other, returning -1 if d < other, 0 if they are equal and
+// 1 if d > other.
+func (d Decimal) Cmp(other Decimal) int {
+	switch {
+	case d.scaled < other.scaled:
+		return -1
+	case d.scaled > other.scaled:
+		return 1
+	default:
+		return 0
+	}
+}
+
+// IsZero returns whether d is 0.
+func (d Decimal) IsZero() bool {
+	return d.scaled == 0
+}
+
+// String returns the number without trailing zeros, e.g. 2.5
+func (d Decimal) String() string {
+	s := formatScaled(d.scaled, DecimalPlaces)
+	if strings.Contains(s, ".") {
+		s = strings.TrimRight(strings.TrimRight(s, "0"), ".")
+	}
+
+	return s
+}
+
+// MarshalJSON encodes the number as a decimal string, e.g. "2.5", like Money.
+func (d Decimal) MarshalJSON() ([]byte, error) {
+	return []byte(`"` + d.String() + `"`), nil
+}
+
+// UnmarshalYAML decodes decimals written as numbers (2.5) or strings ("2.5").
+func (d *Decimal) UnmarshalYAML(node *yaml.Node) error {
+	parsed, err := ParseDecimal(node.Value)
+	if err != nil {
+		return config.Errorf(node.Line, "%s", err)
+	}
+
+	*d = parsed
+	return nil
+}
+
+// errOutOfRange is returned when the result of an operation doesn't fit.
+var errOutOfRange = errors.New("out of range")
+
+// divRound divides n by divisor rounding half away from zero. It returns
+// errOutOfRange if the quotient doesn't fit in an int64.
+func divRound(n *big.Int, divisor int64) (int64, error) {
+	quotient, remainder := new(big.Int).QuoRem(n, big.NewInt(divisor), new(big.Int))
+
+	// |remainder| * 2 >= divisor means we have to round away from zero
+	remainder.Abs(remainder).Mul(remainder, big.NewInt(2))
+	if remainder.Cmp(big.NewInt(divisor)) >= 0 {
+		quotient.Add(quotient, big.NewInt(int64(n.Sign())))
+	}
+
+	if !quotient.IsInt64() {
+		return 0, errOutOfRange
+	}
+
+	return quotient.Int64(), nil
+}
+
+// formatScaled formats an integer scaled by 10^places as a decimal with exactly
+// that amount of decimals.
+func formatScaled(scaled int64, places int) string {
+	sign := ""
+	abs := new(big.Int).SetInt64(scaled)
+	if scaled < 0 {
+		sign = "-"
+		abs.Neg(abs)
+	}
+
+	digits := abs.String()
+	if places == 0 {
+		return sign + digits
+	}
+
+	if len(digits) <= places {
+		digits = strings.Repeat("0", places-len(digits)+1) + digits
+	}
+
+	split := len(digits) - places
+	return sign + digits[:split] + "." + digits[split:]
+}
+
+func pow10(n int) int64 {
+	result := int64(1)
+	for i := 0; i < n; i++ {
+		result *= 10
+	}
+
+	return result
+}
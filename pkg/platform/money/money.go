@@ -0,0 +1,221 @@
+// Package money implements exact amounts of money, to avoid the rounding errors
+// of floating point numbers on financial documents.
+//
+// Amounts of Money are integer amounts of the minor unit of their currency
+// (e.g. cents), so adding them is always exact. Rounding only happens when
+// converting a Decimal (like the result of multiplying a per second price by
+// the duration of a call) into Money, and it's always half away from zero
+// (e.g. 0.125 is rounded to 0.13, -0.125 to -0.13).
+package money
+
+import (
+	"fmt"
+	"math/big"
+	"regexp"
+)
+
+// Money is an amount of money of a currency. The zero value is zero in any
+// currency, so it can be used as the starting value of sums.
+type Money struct {
+	minor    int64  // amount in minor units of the currency
+	currency string // ISO 4217 code
+}
+
+// New returns an amount of money from its minor units (e.g. New(250, "ARS") is
+// ARS 2.50).
+func New(minorUnits int64, currency string) Money {
+	return Money{minor: minorUnits, currency: currency}
+}
+
+// Zero returns zero of the currency.
+func Zero(currency string) Money {
+	return Money{currency: currency}
+}
+
+// FromDecimal returns the decimal amount as money of the currency, rounded to
+// its minor units half away from zero.
+func FromDecimal(amount Decimal, currency string) Money {
+	divisor := pow10(DecimalPlaces - MinorUnitDigits(currency))
+
+	// Dividing by at least 1 can't overflow
+	minor, _ := divRound(big.NewInt(amount.scaled), divisor)
+	return Money{minor: minor, currency: currency}
+}
+
+// Parse parses a decimal amount of money of the currency, e.g. 2.50. It can't
+// have more decimals than the currency.
+func Parse(amount string, currency string) (Money, error) {
+	parsed, err := ParseDecimal(amount)
+	if err != nil {
+		return Money{}, err
+	}
+
+	m := FromDecimal(parsed, currency)
+	if exact, err := m.Decimal(); err != nil || exact != parsed {
+		return Money{}, fmt.Errorf("invalid amount %q: %s has %d decimals", amount, currency, MinorUnitDigits(currency))
+	}
+
+	return m, nil
+}
+
+// MustParse is like Parse but panics if the amount is invalid. Meant for
+// constants and tests.
+func MustParse(amount string, currency string) Money {
+	m, err := Parse(amount, currency)
+	if err != nil {
+		panic(err)
+	}
+
+	return m
+}
+
+// Currency returns the ISO 4217 code of the currency.
+func (m Money) Currency() string {
+	return m.currency
+}
+
+// MinorUnits returns the amount in minor units of the currency.
+func (m Money) MinorUnits() int64 {
+	return m.minor
+}
+
+// Decimal returns the amount as a decimal number. It returns an error if the
+// amount is out of the range of a Decimal, which has more decimals than any
+// currency.
+func (m Money) Decimal() (Decimal, error) {
+	scaled := new(big.Int).Mul(big.NewInt(m.minor), big.NewInt(pow10(DecimalPlaces-MinorUnitDigits(m.currency))))
+	if !scaled.IsInt64() {
+		return Decimal{}, fmt.Errorf("%s is %s of a decimal", m, errOutOfRange)
+	}
+
+	return Decimal{scaled: scaled.Int64()}, nil
+}
+
+// IsZero returns whether the amount is zero.
+func (m Money) IsZero() bool {
+	return m.minor == 0
+}
+
+// Sign returns -1, 0 or 1 depending on the sign of the amount.
+func (m Money) Sign() int {
+	return Decimal{scaled: m.minor}.Sign()
+}
+
+// Add returns m + other. Both must be of the same currency, adding different
+// currencies is a programming error and panics.
+func (m Money) Add(other Money) Money {
+	currency := m.sameCurrency(other)
+	return Money{minor: m.minor + other.minor, currency: currency}
+}
+
+// Sub returns m - other. Both must be of the same currency, like in Add.
+func (m Money) Sub(other Money) Money {
+	currency := m.sameCurrency(other)
+	return Money{minor: m.minor - other.minor, currency: currency}
+}
+
+// Mul returns m * factor, rounded half away from zero to minor units. It
+// returns an error if the product is out of range.
+func (m Money) Mul(factor Decimal) (Money, error) {
+	amount, err := m.Decimal()
+	if err != nil {
+		return Money{}, err
+	}
+
+	product, err := amount.Mul(factor)
+	if err != nil {
+		return Money{}, err
+	}
+
+	return FromDecimal(product, m.currency), nil
+}
+
+// percent is the factor of one percent.
+var percent = MustParseDecimal("0.01")
+
+// Percent returns the percentage of m (e.g. 21 for 21%), rounded half away from
+// zero to minor units. It returns an error if the amount is out of range.
+func (m Money) Percent(percentage Decimal) (Money, error) {
+	factor, err := percentage.Mul(percent)
+	if err != nil {
+		return Money{}, err
+	}
+
+	return m.Mul(factor)
+}
+
+// Prorate returns the part of m corresponding to part out of whole (e.g. the
+// seconds of a call that weren't covered), rounded half away from zero to minor
+// units. part must be between 0 and whole, which must be positive, so that the
+// result is never more than m. Other values are a programming error and panic.
+func (m Money) Prorate(part, whole int64) Money {
+	if whole <= 0 || part < 0 || part > whole {
+		panic(fmt.Sprintf("prorating %d out of %d", part, whole))
+	}
+
+	product := new(big.Int).Mul(big.NewInt(m.minor), big.NewInt(part))
+	minor, _ := divRound(product, whole) // can't be more than m
+	return Money{minor: minor, currency: m.currency}
+}
+
+// String returns the amount with its currency, e.g. 2.50 ARS
+func (m Money) String() string {
+	return m.Amount() + " " + m.currency
+}
+
+// Amount returns the amount as a decimal string with all the digits of the
+// currency, e.g. 2.50
+func (m Money) Amount() string {
+	return formatScaled(m.minor, MinorUnitDigits(m.currency))
+}
+
+// MarshalJSON encodes the amount as a decimal string, e.g. "2.50", so that
+// decoders don't lose precision by parsing it as a float.
+func (m Money) MarshalJSON() ([]byte, error) {
+	return []byte(`"` + m.Amount() + `"`), nil
+}
+
+// sameCurrency returns the currency of both amounts, panicking if they differ.
+// Zero without currency can be operated with any currency.
+func (m Money) sameCurrency(other Money) string {
+	switch {
+	case m.currency == other.currency:
+		return m.currency
+	case m.currency == "" && m.minor == 0:
+		return other.currency
+	case other.currency == "" && other.minor == 0:
+		return m.currency
+	default:
+		panic(fmt.Sprintf("operating with different currencies: %s and %s", m.currency, other.currency))
+	}
+}
+
+var currencyFormat = regexp.MustCompile(`^[A-Z]{3}$`)
+
+// ValidateCurrency validates that the currency is an ISO 4217 code (three
+// uppercase letters, e.g. ARS).
+func ValidateCurrency(currency string) error {
+	if !currencyFormat.MatchString(currency) {
+		return fmt.Errorf("invalid currency %q, should be an ISO 4217 code (e.g. ARS)", currency)
+	}
+
+	return nil
+}
+
+// MinorUnitDigits returns the amount of decimals of the currency according to
+// ISO 4217. Most currencies have 2.
+func MinorUnitDigits(currency string) int {
+	if digits, ok := minorUnitExceptions[currency]; ok {
+		return digits
+	}
+
+	return 2
+}
+
+// Currencies whose minor unit isn't the hundredth
+var minorUnitExceptions = map[string]int{
+	"BHD": 3, "IQD": 3, "JOD": 3, "KWD": 3, "LYD": 3, "OMR": 3, "TND": 3,
+	"BIF": 0, "CLP": 0, "DJF": 0, "GNF": 0, "ISK": 0, "JPY": 0, "KMF": 0,
+	"KRW": 0, "PYG": 0, "RWF": 0, "UGX": 0, "UYI": 0, "VND": 0, "VUV": 0,
+	"XAF": 0, "XOF": 0, "XPF": 0,
+}
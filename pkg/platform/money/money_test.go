@@ -0,0 +1,155 @@
+package money_test
+
+import (
+	"encoding/json"
+	"invoice-generator/pkg/platform/money"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestSumsAreExact(t *testing.T) {
+	// Adding 0.1 ten thousand times with floats doesn't give 1000
+	total := money.Zero("ARS")
+	for i := 0; i < 10000; i++ {
+		total = total.Add(money.MustParse("0.10", "ARS"))
+	}
+
+	assert.Equal(t, money.MustParse("1000", "ARS"), total)
+	assert.Equal(t, int64(100000), total.MinorUnits())
+}
+
+func TestFromDecimalRoundsHalfAwayFromZero(t *testing.T) {
+	tests := []struct {
+		decimal  string
+		currency string
+		expected string
+	}{
+		{decimal: "0.125", currency: "ARS", expected: "0.13"},
+		{decimal: "0.124999999", currency: "ARS", expected: "0.12"},
+		{decimal: "-0.125", currency: "ARS", expected: "-0.13"},
+		{decimal: "2.5", currency: "JPY", expected: "3"},
+		{decimal: "1.0005", currency: "KWD", expected: "1.001"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.decimal+" "+tt.currency, func(t *testing.T) {
+			m := money.FromDecimal(money.MustParseDecimal(tt.decimal), tt.currency)
+			assert.Equal(t, tt.expected, m.Amount())
+			assert.Equal(t, tt.currency, m.Currency())
+		})
+	}
+}
+
+func TestMulRoundsToMinorUnits(t *testing.T) {
+	m := money.MustParse("2.55", "ARS")
+
+	half, err := m.Mul(money.MustParseDecimal("0.5"))
+	require.NoError(t, err)
+	assert.Equal(t, money.MustParse("1.28", "ARS"), half)
+
+	discounted, err := m.Mul(money.MustParseDecimal("0.8"))
+	require.NoError(t, err)
+	assert.Equal(t, money.MustParse("2.04", "ARS"), discounted)
+}
+
+func TestPercentRoundsToMinorUnits(t *testing.T) {
+	// 10.05 * 21% = 2.1105
+	amount, err := money.MustParse("10.05", "ARS").Percent(money.MustParseDecimal("21"))
+	require.NoError(t, err)
+	assert.Equal(t, money.MustParse("2.11", "ARS"), amount)
+}
+
+func TestOverflowsAreErrors(t *testing.T) {
+	huge := money.MustParseDecimal("9000000000")
+
+	_, err := huge.Mul(money.MustParseDecimal("2"))
+	assert.EqualError(t, err, "multiplying 9000000000 by 2: out of range")
+
+	_, err = huge.MulInt(2)
+	assert.EqualError(t, err, "multiplying 9000000000 by 2: out of range")
+
+	_, err = huge.Add(huge)
+	assert.EqualError(t, err, "adding 9000000000 to 9000000000: out of range")
+
+	_, err = money.IntDecimal(10000000000)
+	assert.EqualError(t, err, "10000000000 is out of range of a decimal")
+
+	// The amount fits in minor units, but not with the decimals of a Decimal
+	m := money.New(1000000000000, "ARS")
+	_, err = m.Decimal()
+	assert.EqualError(t, err, "10000000000.00 ARS is out of range of a decimal")
+
+	_, err = m.Mul(money.MustParseDecimal("0.5"))
+	assert.EqualError(t, err, "10000000000.00 ARS is out of range of a decimal")
+
+	_, err = money.MustParse("1000", "ARS").Mul(huge)
+	assert.EqualError(t, err, "multiplying 1000 by 9000000000: out of range")
+}
+
+func TestProrateRoundsToMinorUnits(t *testing.T) {
+	m := money.MustParse("10", "ARS")
+	assert.Equal(t, money.MustParse("3.33", "ARS"), m.Prorate(1, 3))
+	assert.Equal(t, money.MustParse("6.67", "ARS"), m.Prorate(2, 3))
+	assert.Equal(t, m, m.Prorate(30, 30))
+	assert.Equal(t, money.Zero("ARS"), m.Prorate(0, 30))
+}
+
+func TestDecimalOperations(t *testing.T) {
+	perSecond := money.MustParseDecimal("0.0125")
+
+	multiplied, err := perSecond.MulInt(600)
+	require.NoError(t, err)
+	assert.Equal(t, "7.5", multiplied.String())
+
+	product, err := perSecond.Mul(money.MustParseDecimal("0.8"))
+	require.NoError(t, err)
+	assert.Equal(t, "0.01", product.String())
+
+	one, err := money.IntDecimal(1)
+	require.NoError(t, err)
+	sum, err := perSecond.Add(one)
+	require.NoError(t, err)
+	assert.Equal(t, "1.0125", sum.String())
+
+	minusThree, err := money.IntDecimal(-3)
+	require.NoError(t, err)
+	assert.Equal(t, "-3", minusThree.String())
+	assert.Equal(t, "0", money.Decimal{}.String())
+	assert.Equal(t, -1, money.MustParseDecimal("-0.5").Sign())
+}
+
+func TestParseErrors(t *testing.T) {
+	_, err := money.ParseDecimal("1,5")
+	assert.EqualError(t, err, `invalid decimal "1,5"`)
+
+	_, err = money.ParseDecimal("1e3")
+	assert.EqualError(t, err, `invalid decimal "1e3"`)
+
+	_, err = money.ParseDecimal("0.1234567891")
+	assert.EqualError(t, err, `invalid decimal "0.1234567891": at most 9 decimals are supported`)
+
+	_, err = money.Parse("2.505", "ARS")
+	assert.EqualError(t, err, `invalid amount "2.505": ARS has 2 decimals`)
+}
+
+func TestJSONIsADecimalString(t *testing.T) {
+	encoded, err := json.Marshal(struct {
+		Amount money.Money `json:"amount"`
+	}{Amount: money.New(-5, "ARS")})
+	require.NoError(t, err)
+
+	assert.JSONEq(t, `{"amount": "-0.05"}`, string(encoded))
+}
+
+func TestZeroValueAddsToAnyCurrency(t *testing.T) {
+	assert.Equal(t, money.MustParse("1", "USD"), money.Money{}.Add(money.MustParse("1", "USD")))
+	assert.Equal(t, money.MustParse("1", "USD"), money.MustParse("1", "USD").Sub(money.Money{}))
+}
+
+func TestOperatingWithDifferentCurrenciesPanics(t *testing.T) {
+	assert.PanicsWithValue(t, "operating with different currencies: ARS and USD", func() {
+		money.MustParse("1", "ARS").Add(money.MustParse("1", "USD"))
+	})
+}
@@ -0,0 +1,115 @@
+// Package phone parses phone numbers in E.164 format (+<country code><national
+// number>) into their parts.
+package phone
+
+import (
+	"bufio"
+	_ "embed"
+	"fmt"
+	"strings"
+)
+
+const (
+	// MaxDigits is the maximum amount of digits of an E.164 number, including
+	// the country code.
+	MaxDigits = 15
+
+	// MinNationalDigits is the minimum amount of digits of a national number.
+	// E.164 doesn't define it, but there are no shorter numbers in use.
+	MinNationalDigits = 4
+)
+
+// A Number is a parsed E.164 phone number.
+type Number struct {
+	CountryCode    string // e.g. 54 for +5491111111111
+	NationalNumber string // e.g. 91111111111 for +5491111111111
+}
+
+// String returns the number in E.164 format.
+func (n Number) String() string {
+	return "+" + n.CountryCode + n.NationalNumber
+}
+
+// Region returns the ISO 3166-1 alpha-2 code of the main region of the
+// number's country code (e.g. AR for +54, US for +1).
+func (n Number) Region() string {
+	return countryCodes[n.CountryCode]
+}
+
+// Parse parses a phone number in E.164 format. The whole string must be the
+// number, without spaces or any other separators.
+func Parse(number string) (Number, error) {
+	if !strings.HasPrefix(number, "+") {
+		return Number{}, fmt.Errorf("invalid number %q: must start with +", number)
+	}
+
+	digits := number[1:]
+	if digits == "" || strings.Trim(digits, "0123456789") != "" {
+		return Number{}, fmt.Errorf("invalid number %q: must only have digits after the +", number)
+	}
+
+	if len(digits) > MaxDigits {
+		return Number{}, fmt.Errorf("invalid number %q: must have at most %d digits", number, MaxDigits)
+	}
+
+	countryCode, ok := findCountryCode(digits)
+	if !ok {
+		return Number{}, fmt.Errorf("invalid number %q: unknown country code", number)
+	}
+
+	national := digits[len(countryCode):]
+	if len(national) < MinNationalDigits {
+		return Number{}, fmt.Errorf("invalid number %q: national number must have at least %d digits", number, MinNationalDigits)
+	}
+
+	return Number{CountryCode: countryCode, NationalNumber: national}, nil
+}
+
+// findCountryCode returns the country code the digits start with. Country codes
+// have from 1 to 3 digits and no code is a prefix of another one, so at most
+// one of them matches.
+func findCountryCode(digits string) (string, bool) {
+	for length := 1; length <= 3 && length <= len(digits); length++ {
+		if _, ok := countryCodes[digits[:length]]; ok {
+			return digits[:length], true
+		}
+	}
+
+	return "", false
+}
+
+//go:embed country_codes.txt
+var countryCodesTable string
+
+// countryCodes maps each country code to its main region.
+var countryCodes = mustParseCountryCodes(countryCodesTable)
+
+func mustParseCountryCodes(table string) map[string]string {
+	codes := make(map[string]string)
+
+	scanner := bufio.NewScanner(strings.NewReader(table))
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+
+		fields := strings.Fields(line)
+		if len(fields) != 2 {
+			panic(fmt.Sprintf("invalid country code table line %q", line))
+		}
+
+		codes[fields[0]] = fields[1]
+	}
+
+	// Parsing relies on the codes being prefix free
+	for code := range codes {
+		for length := 1; length < len(code); length++ {
+			if _, ok := codes[code[:length]]; ok {
+				panic(fmt.Sprintf("country code %s has %s as prefix", code, code[:length]))
+			}
+		}
+	}
+
+	return codes
+}
@@ -0,0 +1,72 @@
+package phone_test
+
+import (
+	"invoice-generator/pkg/platform/phone"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestParseVariableLengthCountryCodes(t *testing.T) {
+	tests := []struct {
+		number   string
+		expected phone.Number
+		region   string
+	}{
+		{
+			number:   "+12125551234",
+			expected: phone.Number{CountryCode: "1", NationalNumber: "2125551234"},
+			region:   "US",
+		},
+		{
+			number:   "+5491167980950",
+			expected: phone.Number{CountryCode: "54", NationalNumber: "91167980950"},
+			region:   "AR",
+		},
+		{
+			number:   "+59899123456",
+			expected: phone.Number{CountryCode: "598", NationalNumber: "99123456"},
+			region:   "UY",
+		},
+		{
+			number:   "+79161234567",
+			expected: phone.Number{CountryCode: "7", NationalNumber: "9161234567"},
+			region:   "RU",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.number, func(t *testing.T) {
+			number, err := phone.Parse(tt.number)
+			require.NoError(t, err)
+			assert.Equal(t, tt.expected, number)
+			assert.Equal(t, tt.region, number.Region())
+			assert.Equal(t, tt.number, number.String())
+		})
+	}
+}
+
+func TestParseInvalidNumbers(t *testing.T) {
+	tests := []struct {
+		number string
+		err    string
+	}{
+		{number: "", err: `invalid number "": must start with +`},
+		{number: "5491167980950", err: `invalid number "5491167980950": must start with +`},
+		{number: "abc+5491111111111xyz", err: `invalid number "abc+5491111111111xyz": must start with +`},
+		{number: "+5491111111111xyz", err: `invalid number "+5491111111111xyz": must only have digits after the +`},
+		{number: "+54 9 11 1111 1111", err: `invalid number "+54 9 11 1111 1111": must only have digits after the +`},
+		{number: "+", err: `invalid number "+": must only have digits after the +`},
+		{number: "+5491111111111111", err: `invalid number "+5491111111111111": must have at most 15 digits`},
+		{number: "+99911111111", err: `invalid number "+99911111111": unknown country code`},
+		{number: "+54911", err: `invalid number "+54911": national number must have at least 4 digits`},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.number, func(t *testing.T) {
+			_, err := phone.Parse(tt.number)
+			assert.EqualError(t, err, tt.err)
+		})
+	}
+}
@@ -0,0 +1,29 @@
+// Package sliceutil has helpers for slices that the standard library doesn't
+// have (yet).
+package sliceutil
+
+import "sort"
+
+// Contains returns whether the value is one of the values.
+func Contains[T comparable](values []T, value T) bool {
+	for _, v := range values {
+		if v == value {
+			return true
+		}
+	}
+
+	return false
+}
+
+// SortedKeys returns the keys of the map sorted. Maps are iterated in random
+// order, so validations of configuration files go through their keys with it
+// to always fail on the same key.
+func SortedKeys[V any](m map[string]V) []string {
+	keys := make([]string, 0, len(m))
+	for key := range m {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	return keys
+}
@@ -0,0 +1,19 @@
+package sliceutil_test
+
+import (
+	"invoice-generator/pkg/platform/sliceutil"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestContains(t *testing.T) {
+	assert.True(t, sliceutil.Contains([]string{"national", "international"}, "international"))
+	assert.False(t, sliceutil.Contains([]string{"national"}, "international"))
+	assert.False(t, sliceutil.Contains(nil, "national"))
+}
+
+func TestSortedKeys(t *testing.T) {
+	assert.Equal(t, []string{"international", "national", "rural"}, sliceutil.SortedKeys(map[string]int{"rural": 3, "national": 1, "international": 2}))
+	assert.Empty(t, sliceutil.SortedKeys(map[string]int(nil)))
+}
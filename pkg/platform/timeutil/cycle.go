@@ -0,0 +1,66 @@
+package timeutil
+
+import (
+	"fmt"
+	"time"
+)
+
+// A Cycle is a monthly billing cycle. Each period starts at midnight of the
+// anchor day of a month, in the location of the cycle, and ends when the next
+// one starts.
+//
+// Months without the anchor day start their period on their last day instead,
+// so with an anchor of 31 the period of February starts on the 28th (or the
+// 29th on leap years) and the one of March on the 31st.
+type Cycle struct {
+	anchorDay int
+	location  *time.Location
+}
+
+// NewCycle returns the cycle that starts on the anchor day (1 to 31) of every
+// month, in the location.
+func NewCycle(anchorDay int, location *time.Location) (Cycle, error) {
+	if anchorDay < 1 || anchorDay > 31 {
+		return Cycle{}, fmt.Errorf("invalid cycle day %d, should be between 1 and 31", anchorDay)
+	}
+
+	if location == nil {
+		location = time.UTC
+	}
+
+	return Cycle{anchorDay: anchorDay, location: location}, nil
+}
+
+// Starting returns the period of the cycle that starts in the month.
+func (c Cycle) Starting(year int, month time.Month) Period {
+	return Period{Start: c.start(year, month), End: c.start(year, month+1)}
+}
+
+// Containing returns the period of the cycle that contains the time.
+func (c Cycle) Containing(t time.Time) Period {
+	local := t.In(c.location)
+	period := c.Starting(local.Year(), local.Month())
+	if t.Before(period.Start) {
+		return c.Starting(local.Year(), local.Month()-1)
+	}
+
+	return period
+}
+
+// start returns the start of the period of the month. Months out of range are
+// normalized, so month 13 is January of the next year.
+func (c Cycle) start(year int, month time.Month) time.Time {
+	firstOfMonth := time.Date(year, month, 1, 0, 0, 0, 0, c.location)
+
+	day := c.anchorDay
+	if last := daysIn(firstOfMonth.Year(), firstOfMonth.Month()); day > last {
+		day = last
+	}
+
+	return time.Date(firstOfMonth.Year(), firstOfMonth.Month(), day, 0, 0, 0, 0, c.location)
+}
+
+// daysIn returns the number of days of the month.
+func daysIn(year int, month time.Month) int {
+	return time.Date(year, month+1, 0, 0, 0, 0, 0, time.UTC).Day()
+}
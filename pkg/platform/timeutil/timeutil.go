@@ -5,12 +5,51 @@ import "time"
 // 2021-01-17T18:57:34Z
 const LayoutISO8601 = "2006-01-02T15:04:05Z"
 
-// Period represents a period of time
+// LayoutDate is the format of dates: 2021-01-17 (AAAA-MM-DD)
+const LayoutDate = "2006-01-02"
+
+// Period represents a period of time. It's half-open: it includes its Start,
+// but not its End, so consecutive periods don't overlap and no instant between
+// them is left out.
 type Period struct {
 	Start time.Time
 	End   time.Time
 }
 
+// Days returns the period of the whole days from first to last (both included)
+// in the location: from the midnight that starts first until the one that
+// ends last. Only the dates of first and last are used, not their times.
+//
+// Days are calendar days, so they can be 23 or 25 hours long if the clocks of
+// the location change on them (e.g. daylight saving time).
+func Days(first, last time.Time, loc *time.Location) Period {
+	return Period{
+		Start: Midnight(first, loc),
+		End:   Midnight(last.AddDate(0, 0, 1), loc),
+	}
+}
+
+// Midnight returns the start of the date of the time in the location, e.g. the
+// date of a file (which is read as UTC) as a local day. Only the date of the
+// time is used.
+func Midnight(date time.Time, loc *time.Location) time.Time {
+	return time.Date(date.Year(), date.Month(), date.Day(), 0, 0, 0, 0, loc)
+}
+
+// Contains returns whether the time is in the period: at or after its start,
+// and before its end.
 func (p Period) Contains(t time.Time) bool {
-	return t.After(p.Start) && t.Before(p.End)
+	return !t.Before(p.Start) && t.Before(p.End)
+}
+
+// Last returns the last instant of the period, the one before its End. Things
+// that happen at the end of a period, like converting its recurring charges,
+// happen at it rather than at End, which is already the next period.
+func (p Period) Last() time.Time {
+	return p.End.Add(-time.Nanosecond)
+}
+
+// Location returns the location the period was defined in.
+func (p Period) Location() *time.Location {
+	return p.Start.Location()
 }
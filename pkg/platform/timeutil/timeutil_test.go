@@ -0,0 +1,161 @@
+package timeutil_test
+
+import (
+	"invoice-generator/pkg/platform/timeutil"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestPeriodIsHalfOpen(t *testing.T) {
+	period := timeutil.Period{
+		Start: time.Date(2022, time.November, 1, 0, 0, 0, 0, time.UTC),
+		End:   time.Date(2022, time.December, 1, 0, 0, 0, 0, time.UTC),
+	}
+
+	assert.True(t, period.Contains(period.Start), "the start is included")
+	assert.True(t, period.Contains(period.Last()), "the last instant is included")
+	assert.Equal(t, time.Date(2022, time.November, 30, 23, 59, 59, 999999999, time.UTC), period.Last())
+	assert.False(t, period.Contains(period.End), "the end isn't included")
+	assert.False(t, period.Contains(period.Start.Add(-time.Nanosecond)))
+}
+
+func TestDaysAreWholeLocalDays(t *testing.T) {
+	buenosAires, err := time.LoadLocation("America/Argentina/Buenos_Aires")
+	require.NoError(t, err)
+
+	first := time.Date(2022, time.November, 1, 0, 0, 0, 0, time.UTC)
+	last := time.Date(2022, time.November, 30, 0, 0, 0, 0, time.UTC)
+	period := timeutil.Days(first, last, buenosAires)
+
+	// Buenos Aires is UTC-3
+	assert.Equal(t, time.Date(2022, time.November, 1, 3, 0, 0, 0, time.UTC), period.Start.UTC())
+	assert.Equal(t, time.Date(2022, time.December, 1, 3, 0, 0, 0, time.UTC), period.End.UTC())
+	assert.Equal(t, buenosAires, period.Location())
+
+	assert.True(t, period.Contains(time.Date(2022, time.December, 1, 2, 59, 59, 0, time.UTC)), "last second of the last day")
+	assert.False(t, period.Contains(time.Date(2022, time.November, 1, 2, 59, 59, 0, time.UTC)), "last second of the day before")
+}
+
+func TestMidnightIsTheStartOfTheLocalDate(t *testing.T) {
+	buenosAires, err := time.LoadLocation("America/Argentina/Buenos_Aires")
+	require.NoError(t, err)
+
+	// A date read from a file is midnight in UTC
+	date := time.Date(2022, time.November, 15, 0, 0, 0, 0, time.UTC)
+	assert.Equal(t, time.Date(2022, time.November, 15, 3, 0, 0, 0, time.UTC), timeutil.Midnight(date, buenosAires).UTC())
+}
+
+func TestDaysWhenTheClocksChange(t *testing.T) {
+	newYork, err := time.LoadLocation("America/New_York")
+	require.NoError(t, err)
+
+	// Clocks went back an hour on the 6th of November of 2022
+	day := time.Date(2022, time.November, 6, 0, 0, 0, 0, time.UTC)
+	period := timeutil.Days(day, day, newYork)
+
+	assert.Equal(t, 25*time.Hour, period.End.Sub(period.Start))
+	assert.Equal(t, "2022-11-06T00:00:00-04:00", period.Start.Format(time.RFC3339))
+	assert.Equal(t, "2022-11-07T00:00:00-05:00", period.End.Format(time.RFC3339))
+}
+
+func TestCycleStartsOnTheAnchorDay(t *testing.T) {
+	cycle, err := timeutil.NewCycle(15, time.UTC)
+	require.NoError(t, err)
+
+	period := cycle.Starting(2022, time.November)
+	assert.Equal(t, time.Date(2022, time.November, 15, 0, 0, 0, 0, time.UTC), period.Start)
+	assert.Equal(t, time.Date(2022, time.December, 15, 0, 0, 0, 0, time.UTC), period.End)
+
+	december := cycle.Starting(2022, time.December)
+	assert.Equal(t, time.Date(2023, time.January, 15, 0, 0, 0, 0, time.UTC), december.End, "the year rolls over")
+}
+
+func TestCycleOnMonthEnds(t *testing.T) {
+	tests := []struct {
+		name      string
+		anchorDay int
+		year      int
+		month     time.Month
+		start     time.Time
+		end       time.Time
+	}{
+		{
+			name:      "month shorter than the anchor day",
+			anchorDay: 31,
+			year:      2022, month: time.January,
+			start: time.Date(2022, time.January, 31, 0, 0, 0, 0, time.UTC),
+			end:   time.Date(2022, time.February, 28, 0, 0, 0, 0, time.UTC),
+		},
+		{
+			name:      "back to the anchor day after a short month",
+			anchorDay: 31,
+			year:      2022, month: time.February,
+			start: time.Date(2022, time.February, 28, 0, 0, 0, 0, time.UTC),
+			end:   time.Date(2022, time.March, 31, 0, 0, 0, 0, time.UTC),
+		},
+		{
+			name:      "leap year",
+			anchorDay: 30,
+			year:      2024, month: time.February,
+			start: time.Date(2024, time.February, 29, 0, 0, 0, 0, time.UTC),
+			end:   time.Date(2024, time.March, 30, 0, 0, 0, 0, time.UTC),
+		},
+		{
+			name:      "anchor day of 29 on a leap year",
+			anchorDay: 29,
+			year:      2024, month: time.January,
+			start: time.Date(2024, time.January, 29, 0, 0, 0, 0, time.UTC),
+			end:   time.Date(2024, time.February, 29, 0, 0, 0, 0, time.UTC),
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cycle, err := timeutil.NewCycle(tt.anchorDay, time.UTC)
+			require.NoError(t, err)
+
+			period := cycle.Starting(tt.year, tt.month)
+			assert.Equal(t, tt.start, period.Start)
+			assert.Equal(t, tt.end, period.End)
+		})
+	}
+}
+
+func TestCycleWhenTheClocksChange(t *testing.T) {
+	newYork, err := time.LoadLocation("America/New_York")
+	require.NoError(t, err)
+
+	cycle, err := timeutil.NewCycle(1, newYork)
+	require.NoError(t, err)
+
+	// Clocks went back an hour on the 6th of November of 2022, so both
+	// boundaries are at midnight but with different offsets.
+	period := cycle.Starting(2022, time.November)
+	assert.Equal(t, "2022-11-01T00:00:00-04:00", period.Start.Format(time.RFC3339))
+	assert.Equal(t, "2022-12-01T00:00:00-05:00", period.End.Format(time.RFC3339))
+	assert.Equal(t, 30*24*time.Hour+time.Hour, period.End.Sub(period.Start))
+}
+
+func TestCycleContaining(t *testing.T) {
+	buenosAires, err := time.LoadLocation("America/Argentina/Buenos_Aires")
+	require.NoError(t, err)
+
+	cycle, err := timeutil.NewCycle(15, buenosAires)
+	require.NoError(t, err)
+
+	// 2022-11-15T02:00:00Z is still the 14th in Buenos Aires (UTC-3)
+	assert.Equal(t, cycle.Starting(2022, time.October), cycle.Containing(time.Date(2022, time.November, 15, 2, 0, 0, 0, time.UTC)))
+	assert.Equal(t, cycle.Starting(2022, time.November), cycle.Containing(time.Date(2022, time.November, 15, 3, 0, 0, 0, time.UTC)))
+	assert.Equal(t, cycle.Starting(2021, time.December), cycle.Containing(time.Date(2022, time.January, 1, 12, 0, 0, 0, time.UTC)))
+}
+
+func TestInvalidCycleDay(t *testing.T) {
+	_, err := timeutil.NewCycle(0, time.UTC)
+	assert.EqualError(t, err, "invalid cycle day 0, should be between 1 and 31")
+
+	_, err = timeutil.NewCycle(32, time.UTC)
+	assert.EqualError(t, err, "invalid cycle day 32, should be between 1 and 31")
+}
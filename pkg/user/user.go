@@ -18,6 +18,22 @@ type User struct {
 	Address string        `json:"address"`
 	Phone   PhoneNumber   `json:"phone_number"`
 	Friends []PhoneNumber `json:"friends"`
+
+	// Currency the user is invoiced in (ISO 4217 code). Empty means the
+	// currency of the tariff.
+	Currency string `json:"currency,omitempty"`
+
+	// TaxExemptions are the names of the taxes the user doesn't pay.
+	TaxExemptions []string `json:"tax_exemptions,omitempty"`
+
+	// Plan the user is subscribed to, with allowances of minutes. Empty means
+	// the user pays for every call.
+	Plan string `json:"plan,omitempty"`
+
+	// TimeZone of the user (IANA name, e.g. America/Argentina/Buenos_Aires),
+	// in which the holidays of their calls are resolved. Empty means the time
+	// zone of the billing period.
+	TimeZone string `json:"time_zone,omitempty"`
 }
 
 // A Finder knows how to find users